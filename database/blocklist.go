@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"sync"
+
+	"api/nsfw"
+	"api/structs"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// BlockedHashes reads the moderation blocklist from the blocked_hashes table.
+// The hashes are cached until the counter at blocklist:version in redis changes,
+// which the routes bump on every change so all processes reload.
+type BlockedHashes struct {
+	DB    *gorm.DB
+	Redis *redis.Client
+
+	mutex   sync.Mutex
+	loaded  bool
+	version string
+	hashes  []uint64
+}
+
+func (b *BlockedHashes) BlockedHashes(ctx context.Context) ([]uint64, error) {
+	// Read the version first, a change after this makes the next call reload
+	version, err := b.Redis.Get(ctx, "blocklist:version").Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.loaded && b.version == version {
+		return b.hashes, nil
+	}
+
+	var encoded []string
+	if err := b.DB.WithContext(ctx).Model(&structs.BlockedHash{}).Pluck("hash", &encoded).Error; err != nil {
+		return nil, err
+	}
+	hashes := make([]uint64, 0, len(encoded))
+	for _, hash := range encoded {
+		parsed, err := nsfw.ParseHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, parsed)
+	}
+	b.loaded, b.version, b.hashes = true, version, hashes
+	return hashes, nil
+}
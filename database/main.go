@@ -12,11 +12,15 @@ import (
 
 func Connect(env *structs.Environment) *gorm.DB {
 	url := env.TursoUrl + "?authToken=" + env.TursoToken
-	db, err := gorm.Open(libsql.Open(url), &gorm.Config{})
+	// Unique index violations are compared with gorm.ErrDuplicatedKey
+	db, err := gorm.Open(libsql.Open(url), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal("failed to connect to db", err)
 	}
-	db.AutoMigrate(&structs.User{}, &structs.Project{})
+	db.AutoMigrate(&structs.User{}, &structs.Project{}, &structs.Invite{}, &structs.Upload{}, &structs.Asset{}, &structs.ModerationDecision{}, &structs.BlockedHash{}, &structs.Template{}, &structs.ProjectMember{}, &structs.ProjectInvitation{}, &structs.Organization{}, &structs.OrganizationMember{}, &structs.OrganizationInvitation{}, &structs.Team{}, &structs.TeamMember{}, &structs.TeamProject{}, &structs.ProjectTransfer{}, &structs.Promotion{})
+	if err := seedTemplates(db); err != nil {
+		log.Fatal("failed to seed templates", err)
+	}
 
 	return db
 }
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"fmt"
+
+	"api/git"
+	"api/structs"
+
+	"gorm.io/gorm"
+)
+
+// BackfillProjects stores the repository of projects created before it was
+// kept on the project. Those were named <userId>-<projectId> under the account
+// of their provider, on dev and prod branches. It needs git to be connected.
+func BackfillProjects(db *gorm.DB) error {
+	updates := []struct {
+		where  string
+		column string
+		value  interface{}
+	}{
+		{"provider IS NULL OR provider = ''", "provider", git.Default},
+		{"repo IS NULL OR repo = ''", "repo", gorm.Expr("user_id || '-' || id")},
+		{"prod_branch IS NULL OR prod_branch = ''", "prod_branch", "prod"},
+		{"dev_branch IS NULL OR dev_branch = ''", "dev_branch", "dev"},
+	}
+	for _, update := range updates {
+		if err := db.Model(&structs.Project{}).Where(update.where).Update(update.column, update.value).Error; err != nil {
+			return err
+		}
+	}
+
+	var providers []string
+	err := db.Model(&structs.Project{}).Where("repo_owner IS NULL OR repo_owner = ''").Distinct().Pluck("provider", &providers).Error
+	if err != nil {
+		return err
+	}
+	for _, name := range providers {
+		provider, err := git.For(name)
+		if err != nil {
+			// Projects on providers that are no longer configured are left for when they are
+			fmt.Println(err.Error())
+			continue
+		}
+		err = db.Model(&structs.Project{}).Where("(repo_owner IS NULL OR repo_owner = '') AND provider = ?", name).Update("repo_owner", provider.Owner()).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"api/secrets"
+	"api/structs"
+
+	"gorm.io/gorm"
+)
+
+// EnvironmentData binds the value of a variable of an env setting to the
+// project, setting and variable it belongs to
+func EnvironmentData(projectId string, setting string, variable string) string {
+	return projectId + ":" + setting + ":" + variable
+}
+
+// RotateSecrets re-encrypts every stored secret that is plaintext, sealed
+// with a key other than the current one or not yet bound to its row, and
+// returns how many rows changed. Secrets are TOTP seeds of users and the
+// variables of env settings of projects.
+func RotateSecrets(db *gorm.DB) (int, error) {
+	if secrets.CurrentKey() == "" {
+		return 0, secrets.ErrNoKeys
+	}
+	var users []structs.User
+	err := db.Model(&structs.User{}).Select("ID", "TotpSecret", "TotpKeyID").
+		Where("totp_secret <> ''").
+		Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, user := range users {
+		if secrets.IsCurrent(user.TotpSecret) {
+			continue
+		}
+		plaintext, err := secrets.Decrypt(user.TotpSecret, user.ID)
+		if err != nil {
+			return rotated, err
+		}
+		encoded, keyId, err := secrets.Encrypt(plaintext, user.ID)
+		if err != nil {
+			return rotated, err
+		}
+		err = db.Model(&structs.User{}).Where("ID = ?", user.ID).Updates(map[string]interface{}{"totp_secret": encoded, "totp_key_id": keyId}).Error
+		if err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+
+	var projects []structs.Project
+	if err := db.Model(&structs.Project{}).Select("ID", "Settings").Where("settings IS NOT NULL").Find(&projects).Error; err != nil {
+		return rotated, err
+	}
+	for _, project := range projects {
+		changed := false
+		for setting, value := range project.Settings {
+			// Only env settings hold objects
+			variables, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for variable, stored := range variables {
+				text, ok := stored.(string)
+				if !ok || secrets.IsCurrent(text) {
+					continue
+				}
+				data := EnvironmentData(project.ID, setting, variable)
+				plaintext, err := secrets.Decrypt(text, data)
+				if err != nil {
+					return rotated, err
+				}
+				if variables[variable], _, err = secrets.Encrypt(plaintext, data); err != nil {
+					return rotated, err
+				}
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := db.Model(&project).Select("Settings").Updates(&project).Error; err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"api/structs"
+
+	"gorm.io/gorm"
+)
+
+// seedTemplates registers the templates that were built in before the
+// template registry existed, as long as no template is registered yet
+func seedTemplates(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&structs.Template{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	templates := []structs.Template{
+		{Name: "splitscript_javascript", Title: "SplitScript JavaScript", Language: "javascript", Tags: `["splitscript"]`},
+		{Name: "splitscript_typescript", Title: "SplitScript TypeScript", Language: "typescript", Tags: `["splitscript"]`},
+	}
+	return db.Create(&templates).Error
+}
@@ -0,0 +1,56 @@
+package email
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed disposable.txt
+var disposableList string
+
+var disposable = parseDomains(disposableList)
+
+func parseDomains(list string) map[string]bool {
+	domains := map[string]bool{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[strings.ToLower(line)] = true
+	}
+	return domains
+}
+
+// Domain returns the lowercased domain part of an email address
+func Domain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at == -1 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(address[at+1:]))
+}
+
+// IsDisposable reports whether address belongs to a known disposable email provider or one of its subdomains
+func IsDisposable(address string) bool {
+	return matchesDomain(Domain(address), disposable)
+}
+
+// DomainAllowed reports whether address belongs to one of allowed or one of their subdomains
+func DomainAllowed(address string, allowed []string) bool {
+	return matchesDomain(Domain(address), parseDomains(strings.Join(allowed, "\n")))
+}
+
+func matchesDomain(domain string, domains map[string]bool) bool {
+	for domain != "" {
+		if domains[domain] {
+			return true
+		}
+		dot := strings.Index(domain, ".")
+		if dot == -1 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
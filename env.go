@@ -30,15 +30,6 @@ func checkEnv() structs.Environment {
 
 		{"PORT", &env.Port},
 		{"REQUESTS_PER_SECOND", &env.RequestsPerSecond},
-
-		{"GIT_TOKEN", &env.GitToken},
-		{"GIT_URL", &env.GitUrl},
-		{"GIT_USERNAME", &env.GitUsername},
-
-		{"MINIO_ENDPOINT", &env.MinioEndpoint},
-		{"MINIO_ACCESS_KEY_ID", &env.MinioAccessKeyId},
-		{"MINIO_ACCESS_KEY", &env.MinioAccessKey},
-		{"MINIO_AVATAR_BUCKET", &env.MinioAvatarBucket},
 	}
 
 	for _, v := range envVars {
@@ -49,5 +40,100 @@ func checkEnv() structs.Environment {
 		*v.field = value
 	}
 
+	optionalEnvVars := []struct {
+		name     string
+		field    *string
+		fallback string
+	}{
+		{"STORAGE_BACKEND", &env.StorageBackend, "minio"},
+		{"STORAGE_PATH", &env.StoragePath, "./data"},
+		{"STORAGE_PUBLIC_URL", &env.StoragePublicUrl, ""},
+		{"STORAGE_SIGNING_KEY", &env.StorageSigningKey, ""},
+		{"STORAGE_AVATARS_BUCKET", &env.StorageAvatarsBucket, ""},
+		{"STORAGE_AVATARS_PREFIX", &env.StorageAvatarsPrefix, ""},
+		{"STORAGE_EXPORTS_BUCKET", &env.StorageExportsBucket, "exports"},
+		{"STORAGE_EXPORTS_PREFIX", &env.StorageExportsPrefix, ""},
+		{"STORAGE_ASSETS_BUCKET", &env.StorageAssetsBucket, "assets"},
+		{"STORAGE_ASSETS_PREFIX", &env.StorageAssetsPrefix, ""},
+		{"STORAGE_QUARANTINE_BUCKET", &env.StorageQuarantineBucket, "quarantine"},
+		{"STORAGE_QUARANTINE_PREFIX", &env.StorageQuarantinePrefix, ""},
+
+		{"MINIO_ENDPOINT", &env.MinioEndpoint, ""},
+		{"MINIO_ACCESS_KEY_ID", &env.MinioAccessKeyId, ""},
+		{"MINIO_ACCESS_KEY", &env.MinioAccessKey, ""},
+		{"MINIO_AVATAR_BUCKET", &env.MinioAvatarBucket, "avatars"},
+		{"MINIO_SECURE", &env.MinioSecure, "true"},
+
+		{"PASSWORD_ALGORITHM", &env.PasswordAlgorithm, "argon2id"},
+		{"ARGON2_TIME", &env.Argon2Time, ""},
+		{"ARGON2_MEMORY", &env.Argon2Memory, ""},
+		{"ARGON2_THREADS", &env.Argon2Threads, ""},
+		{"BCRYPT_COST", &env.BcryptCost, ""},
+
+		{"ENCRYPTION_KEYS", &env.EncryptionKeys, ""},
+		{"ENCRYPTION_KEY_ID", &env.EncryptionKeyId, ""},
+
+		{"GIT_PROVIDER", &env.GitProvider, "gitea"},
+		{"GIT_PROVIDERS", &env.GitProviders, ""},
+		{"GIT_TOKEN", &env.GitToken, ""},
+		{"GIT_URL", &env.GitUrl, ""},
+		{"GIT_USERNAME", &env.GitUsername, ""},
+		{"GIT_VERSION", &env.GitVersion, ""},
+		{"GIT_LOCAL_PATH", &env.GitLocalPath, "./repos"},
+		{"FORGEJO_URL", &env.ForgejoUrl, ""},
+		{"FORGEJO_TOKEN", &env.ForgejoToken, ""},
+		{"FORGEJO_OWNER", &env.ForgejoOwner, ""},
+		{"GITHUB_URL", &env.GithubUrl, ""},
+		{"GITHUB_TOKEN", &env.GithubToken, ""},
+		{"GITHUB_OWNER", &env.GithubOwner, ""},
+		{"GITLAB_URL", &env.GitlabUrl, ""},
+		{"GITLAB_TOKEN", &env.GitlabToken, ""},
+		{"GITLAB_OWNER", &env.GitlabOwner, ""},
+
+		{"REGISTRATION_MODE", &env.RegistrationMode, "open"},
+		{"REGISTRATION_DOMAINS", &env.RegistrationDomains, ""},
+		{"INVITES_PER_USER", &env.InvitesPerUser, "5"},
+
+		{"CAPTCHA_PROVIDER", &env.CaptchaProvider, "none"},
+		{"CAPTCHA_SECRET", &env.CaptchaSecret, ""},
+		{"CAPTCHA_ROUTES", &env.CaptchaRoutes, ""},
+		{"CAPTCHA_FAILURE_THRESHOLD", &env.CaptchaFailureThreshold, "3"},
+
+		{"AVATAR_DEFAULT", &env.AvatarDefault, "initials"},
+		{"AVATAR_MAX_BYTES", &env.AvatarMaxBytes, "4194304"},
+		{"AVATAR_MAX_DIMENSION", &env.AvatarMaxDimension, "4096"},
+		{"BODY_LIMIT", &env.BodyLimit, "8388608"},
+
+		{"ASSET_MAX_BYTES", &env.AssetMaxBytes, "52428800"},
+		{"ASSET_CONTENT_TYPES", &env.AssetContentTypes, "image/png,image/jpeg,image/gif,image/webp,application/pdf,text/plain"},
+		{"UPLOAD_EXPIRY", &env.UploadExpiry, "15m"},
+
+		{"MODERATION_CLASSIFIERS", &env.ModerationClassifiers, "blocklist,heuristic"},
+		{"MODERATION_THRESHOLDS", &env.ModerationThresholds, "nsfw:0.5"},
+		{"MODERATION_FAIL_MODE", &env.ModerationFailMode, "closed"},
+		{"MODERATION_REMOTE_URL", &env.ModerationRemoteUrl, ""},
+		{"MODERATION_REMOTE_TOKEN", &env.ModerationRemoteToken, ""},
+		{"MODERATION_WORKERS", &env.ModerationWorkers, ""},
+		{"MODERATION_TIMEOUT", &env.ModerationTimeout, "10s"},
+		{"MODERATION_ADMIN_EMAILS", &env.ModerationAdminEmails, ""},
+		{"MODERATION_BLOCKLIST_DISTANCE", &env.ModerationBlocklistDistance, "6"},
+	}
+
+	for _, v := range optionalEnvVars {
+		value, exists := os.LookupEnv(v.name)
+		if !exists {
+			value = v.fallback
+		}
+		*v.field = value
+	}
+
+	// MINIO_AVATAR_BUCKET predates the per purpose storage settings
+	if env.StorageAvatarsBucket == "" {
+		env.StorageAvatarsBucket = env.MinioAvatarBucket
+	}
+	if env.StoragePublicUrl == "" {
+		env.StoragePublicUrl = "http://localhost:" + env.Port
+	}
+
 	return env
 }
@@ -9,13 +9,71 @@ func MalformedBody(err error) fiber.Map {
 	return fiber.Map{"code": "malformed_body", "error": err.Error()}
 }
 
+// ImageQuarantined is ImageNsfw with the moderation decision that can be appealed
+func ImageQuarantined(decisionId string) fiber.Map {
+	return fiber.Map{"code": "image_nsfw", "decision_id": decisionId}
+}
+
+// TemplateVariablesInvalid lists why each variable was rejected: required, pattern or unknown
+func TemplateVariablesInvalid(problems map[string]string) fiber.Map {
+	return fiber.Map{"code": "template_variables_invalid", "variables": problems}
+}
+func TemplateManifestInvalid(err error) fiber.Map {
+	return fiber.Map{"code": "template_manifest_invalid", "error": err.Error()}
+}
+func TemplateSettingsInvalid(err error) fiber.Map {
+	return fiber.Map{"code": "template_settings_invalid", "error": err.Error()}
+}
+
+// ProjectSettingsInvalid lists why each setting was rejected: unknown, type, max, pattern or invalid
+func ProjectSettingsInvalid(problems map[string]string) fiber.Map {
+	return fiber.Map{"code": "project_settings_invalid", "settings": problems}
+}
+
+// PromotionConflicts lists the files that keep the development branch from being merged
+func PromotionConflicts(files []string) fiber.Map {
+	return fiber.Map{"code": "promotion_conflicts", "files": files}
+}
+
 var UserAlreadyVerified = fiber.Map{"code": "user_already_verified"}
 var UserEmailTaken = fiber.Map{"code": "user_email_taken"}
 var UserCredentialsInvalid = fiber.Map{"code": "user_credentials_invalid"}
+var PasswordTooLong = fiber.Map{"code": "password_too_long"}
+var UserUsernameTaken = fiber.Map{"code": "user_username_taken"}
+var UserEmailDomainInvalid = fiber.Map{"code": "user_email_domain_invalid"}
+var UserEmailDisposable = fiber.Map{"code": "user_email_disposable"}
+var InviteRequired = fiber.Map{"code": "invite_required"}
+var InviteInvalid = fiber.Map{"code": "invite_invalid"}
+var InviteLimitReached = fiber.Map{"code": "invite_limit_reached"}
+var CaptchaRequired = fiber.Map{"code": "captcha_required"}
+var CaptchaInvalid = fiber.Map{"code": "captcha_invalid"}
 var MissingParameter = fiber.Map{"code": "missing_parameter"}
 var NotFound = fiber.Map{"code": "not_found"}
 var ImageNsfw = fiber.Map{"code": "image_nsfw"}
+var AppealExists = fiber.Map{"code": "appeal_exists"}
+var ReviewFinished = fiber.Map{"code": "review_finished"}
+var HashBlocked = fiber.Map{"code": "hash_already_blocked"}
+var HashMissing = fiber.Map{"code": "hash_missing"}
+var ImageTooLarge = fiber.Map{"code": "image_too_large"}
+var ImageDimensionsInvalid = fiber.Map{"code": "image_dimensions_invalid"}
+var ImageFormatInvalid = fiber.Map{"code": "image_format_invalid"}
+var ImageInvalid = fiber.Map{"code": "image_invalid"}
+var ImageCropInvalid = fiber.Map{"code": "image_crop_invalid"}
+var InvalidAvatarSize = fiber.Map{"code": "invalid_avatar_size"}
+var InvalidAvatarDefault = fiber.Map{"code": "invalid_avatar_default"}
+var UploadExpired = fiber.Map{"code": "upload_expired"}
+var UploadIncomplete = fiber.Map{"code": "upload_incomplete"}
+var UploadFinished = fiber.Map{"code": "upload_finished"}
+var AssetTooLarge = fiber.Map{"code": "asset_too_large"}
+var AssetContentTypeInvalid = fiber.Map{"code": "asset_content_type_invalid"}
+var AssetContentTypeMismatch = fiber.Map{"code": "asset_content_type_mismatch"}
+var InvalidLimit = fiber.Map{"code": "invalid_limit"}
 var InvalidTemplate = fiber.Map{"code": "invalid_template_name"}
+var TemplateExists = fiber.Map{"code": "template_already_exists"}
+var TemplateRepoMissing = fiber.Map{"code": "template_repository_not_found"}
+var TemplateBranchNotDefault = fiber.Map{"code": "template_branch_not_default"}
+var InvalidPath = fiber.Map{"code": "invalid_path"}
+var InvalidProvider = fiber.Map{"code": "invalid_git_provider"}
 
 var ServerEmailSend = fiber.Map{"code": "server_failed_email"}
 var ServerHash = fiber.Map{"code": "server_failed_hash"}
@@ -28,5 +86,30 @@ var ServerImageError = fiber.Map{"code": "server_image_error"}
 var ServerStorageError = fiber.Map{"code": "server_storage_error"}
 var ServerGitError = fiber.Map{"code": "server_git_error"}
 var ServerTotpError = fiber.Map{"code": "server_totp_error"}
+var ServerCaptchaError = fiber.Map{"code": "server_captcha_error"}
+var ServerModerationError = fiber.Map{"code": "server_moderation_unavailable"}
+var ServerEncryptError = fiber.Map{"code": "server_failed_encrypt"}
+
+var SignatureInvalid = fiber.Map{"code": "signature_invalid"}
 
 var ProjectNoAccess = fiber.Map{"code": "project_access_missing"}
+var ProjectMemberExists = fiber.Map{"code": "project_member_exists"}
+var ProjectInvitationExists = fiber.Map{"code": "project_invitation_exists"}
+var ProjectInvitationExpired = fiber.Map{"code": "project_invitation_expired"}
+var ProjectOwnerRole = fiber.Map{"code": "project_owner_role"}
+var OrganizationSlugTaken = fiber.Map{"code": "organization_slug_taken"}
+var OrganizationMemberExists = fiber.Map{"code": "organization_member_exists"}
+var OrganizationInvitationExists = fiber.Map{"code": "organization_invitation_exists"}
+var OrganizationInvitationExpired = fiber.Map{"code": "organization_invitation_expired"}
+var OrganizationMemberMissing = fiber.Map{"code": "organization_member_missing"}
+var OrganizationOwnerRequired = fiber.Map{"code": "organization_owner_required"}
+var OrganizationHasProjects = fiber.Map{"code": "organization_has_projects"}
+var ProjectAlreadyOwned = fiber.Map{"code": "project_already_owned"}
+var ProjectInOrganization = fiber.Map{"code": "project_in_organization"}
+var ProjectTransferPending = fiber.Map{"code": "project_transfer_pending"}
+var ProjectTransferExpired = fiber.Map{"code": "project_transfer_expired"}
+var ProjectTransferStale = fiber.Map{"code": "project_transfer_stale"}
+var ProjectUpToDate = fiber.Map{"code": "project_up_to_date"}
+var ProjectPullRequestExists = fiber.Map{"code": "project_pull_request_exists"}
+var PromotionRefused = fiber.Map{"code": "promotion_refused"}
+var PullRequestsUnsupported = fiber.Map{"code": "git_pull_requests_unsupported"}
@@ -0,0 +1,321 @@
+package git
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"code.gitea.io/sdk/gitea"
+)
+
+// giteaProvider uses the Gitea SDK, plus the change files endpoint the SDK
+// does not cover. Forgejo keeps the Gitea API and is served by it as well.
+type giteaProvider struct {
+	client *gitea.Client
+	rest   restClient
+	owner  string
+}
+
+// NewGitea connects to the Gitea server at baseUrl. version skips asking the
+// server for its version when set. client may be nil.
+func NewGitea(baseUrl string, token string, owner string, version string, client *http.Client) (GitProvider, error) {
+	if baseUrl == "" || token == "" || owner == "" {
+		return nil, fmt.Errorf("GIT_URL, GIT_TOKEN and GIT_USERNAME are required for the gitea provider")
+	}
+	return newGitea(baseUrl, token, owner, version, client)
+}
+
+// NewForgejo connects to the Forgejo server at baseUrl. Forgejo versions do
+// not follow Gitea's, so the SDK's version checks are turned off.
+func NewForgejo(baseUrl string, token string, owner string, client *http.Client) (GitProvider, error) {
+	if baseUrl == "" || token == "" || owner == "" {
+		return nil, fmt.Errorf("FORGEJO_URL, FORGEJO_TOKEN and FORGEJO_OWNER are required for the forgejo provider")
+	}
+	return newGitea(baseUrl, token, owner, "", client)
+}
+
+func newGitea(baseUrl string, token string, owner string, version string, client *http.Client) (*giteaProvider, error) {
+	client = newHttpClient(client)
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	options := []gitea.ClientOption{gitea.SetToken(token), gitea.SetHTTPClient(client), gitea.SetGiteaVersion(version)}
+	sdk, err := gitea.NewClient(baseUrl, options...)
+	// Servers reporting a version the SDK cannot parse still work
+	var unknown *gitea.ErrUnknownVersion
+	if err != nil && !errors.As(err, &unknown) {
+		return nil, err
+	}
+	return &giteaProvider{
+		client: sdk,
+		rest: restClient{
+			base: baseUrl + "/api/v1",
+			http: client,
+			authorize: func(request *http.Request) {
+				request.Header.Set("Authorization", "token "+token)
+			},
+		},
+		owner: owner,
+	}, nil
+}
+
+// giteaError turns SDK 404 responses into ErrNotFound
+func giteaError(response *gitea.Response, err error) error {
+	if err != nil && response != nil && response.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, err.Error())
+	}
+	return err
+}
+
+func (g *giteaProvider) Owner() string {
+	return g.owner
+}
+
+// CreateFromTemplate copies the default branch of the template, which branch has to be
+func (g *giteaProvider) CreateFromTemplate(ctx context.Context, template Repo, branch string, repo Repo, description string) error {
+	source, response, err := g.client.GetRepo(template.Owner, template.Name)
+	if err != nil {
+		return giteaError(response, err)
+	}
+	if source.DefaultBranch != branch {
+		return fmt.Errorf("%w: %s is not the default branch of %s/%s", ErrNotDefault, branch, template.Owner, template.Name)
+	}
+	_, response, err = g.client.CreateRepoFromTemplate(template.Owner, template.Name, gitea.CreateRepoFromTemplateOption{
+		Owner:       repo.Owner,
+		Name:        repo.Name,
+		Description: description,
+		Private:     true,
+		GitContent:  true,
+	})
+	if err != nil && response != nil && response.StatusCode == http.StatusConflict {
+		return ErrExists
+	}
+	return giteaError(response, err)
+}
+
+func (g *giteaProvider) CreateBranch(ctx context.Context, repo Repo, branch string, from string) error {
+	_, response, err := g.client.CreateBranch(repo.Owner, repo.Name, gitea.CreateBranchOption{BranchName: branch, OldBranchName: from})
+	return giteaError(response, err)
+}
+
+func (g *giteaProvider) BranchHead(ctx context.Context, repo Repo, branch string) (string, error) {
+	result, response, err := g.client.GetRepoBranch(repo.Owner, repo.Name, branch)
+	if err != nil {
+		return "", giteaError(response, err)
+	}
+	return result.Commit.ID, nil
+}
+
+// ReadTree returns the first page of the tree, which Gitea sizes by its
+// DEFAULT_GIT_TREES_PER_PAGE setting
+func (g *giteaProvider) ReadTree(ctx context.Context, repo Repo, ref string) ([]TreeEntry, error) {
+	tree, response, err := g.client.GetTrees(repo.Owner, repo.Name, ref, true)
+	if err != nil {
+		return nil, giteaError(response, err)
+	}
+	entries := make([]TreeEntry, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		entries = append(entries, TreeEntry{Path: entry.Path, Type: EntryType(entry.Type), SHA: entry.SHA, Size: entry.Size})
+	}
+	return entries, nil
+}
+
+func (g *giteaProvider) ReadFile(ctx context.Context, repo Repo, ref string, path string) ([]byte, error) {
+	content, response, err := g.client.GetFile(repo.Owner, repo.Name, ref, path)
+	if err != nil {
+		return nil, giteaError(response, err)
+	}
+	return content, nil
+}
+
+type giteaFileChange struct {
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Content   string `json:"content,omitempty"`
+	SHA       string `json:"sha,omitempty"`
+}
+
+// Commit uses POST /repos/{owner}/{repo}/contents. Updates and deletes need
+// the SHA of the current blob, so the branch tree is read first.
+func (g *giteaProvider) Commit(ctx context.Context, repo Repo, commit Commit) (string, error) {
+	tree, err := g.ReadTree(ctx, repo, commit.Branch)
+	if err != nil {
+		return "", err
+	}
+	existing := map[string]string{}
+	for _, entry := range tree {
+		if entry.Type == Blob {
+			existing[entry.Path] = entry.SHA
+		}
+	}
+
+	files := make([]giteaFileChange, 0, len(commit.Changes))
+	for _, change := range commit.Changes {
+		sha, exists := existing[change.Path]
+		switch {
+		case change.Delete && !exists:
+			continue
+		case change.Delete:
+			files = append(files, giteaFileChange{Operation: "delete", Path: change.Path, SHA: sha})
+		case exists:
+			files = append(files, giteaFileChange{Operation: "update", Path: change.Path, SHA: sha, Content: base64.StdEncoding.EncodeToString(change.Content)})
+		default:
+			files = append(files, giteaFileChange{Operation: "create", Path: change.Path, Content: base64.StdEncoding.EncodeToString(change.Content)})
+		}
+	}
+	if len(files) == 0 {
+		return g.BranchHead(ctx, repo, commit.Branch)
+	}
+
+	var result struct {
+		Commit struct {
+			SHA string `json:"sha"`
+		} `json:"commit"`
+	}
+	_, err = g.rest.do(ctx, http.MethodPost, "/repos/"+url.PathEscape(repo.Owner)+"/"+url.PathEscape(repo.Name)+"/contents", map[string]interface{}{
+		"branch":  commit.Branch,
+		"message": commit.Message,
+		"author":  map[string]string{"name": commit.AuthorName, "email": commit.AuthorEmail},
+		"files":   files,
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	return result.Commit.SHA, nil
+}
+
+func (g *giteaProvider) SetDescription(ctx context.Context, repo Repo, description string) error {
+	_, response, err := g.client.EditRepo(repo.Owner, repo.Name, gitea.EditRepoOption{Description: &description})
+	return giteaError(response, err)
+}
+
+// Gitea fast-forwards pull requests with this style since 1.21
+const giteaFastForward gitea.MergeStyle = "fast-forward-only"
+
+// createPullRequest opens a pull request of head into base. Gitea answers
+// 409 when one is open already and 422 when head has nothing to merge.
+func (g *giteaProvider) createPullRequest(repo Repo, base string, head string, title string, body string) (*gitea.PullRequest, error) {
+	pr, response, err := g.client.CreatePullRequest(repo.Owner, repo.Name, gitea.CreatePullRequestOption{Head: head, Base: base, Title: title, Body: body})
+	if err != nil && response != nil {
+		switch response.StatusCode {
+		case http.StatusConflict:
+			return nil, ErrExists
+		case http.StatusUnprocessableEntity:
+			return nil, ErrUpToDate
+		}
+	}
+	return pr, giteaError(response, err)
+}
+
+// Merge goes through a pull request, the Gitea API cannot merge branches
+// directly. Gitea refuses conflicting merges without naming the files, and
+// refuses mergeable pull requests the base branch protection blocks.
+func (g *giteaProvider) Merge(ctx context.Context, repo Repo, merge Merge) (MergeResult, error) {
+	base, err := g.BranchHead(ctx, repo, merge.Base)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	head, err := g.BranchHead(ctx, repo, merge.Head)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	result := MergeResult{HeadSHA: head}
+	if base == head {
+		return result, ErrUpToDate
+	}
+
+	pr, err := g.createPullRequest(repo, merge.Base, merge.Head, merge.Message, "")
+	created := err == nil
+	if err == ErrExists {
+		open, response, listErr := g.client.ListRepoPullRequests(repo.Owner, repo.Name, gitea.ListPullRequestsOptions{State: gitea.StateOpen})
+		if listErr != nil {
+			return result, giteaError(response, listErr)
+		}
+		for _, candidate := range open {
+			if candidate.Head != nil && candidate.Base != nil && candidate.Head.Ref == merge.Head && candidate.Base.Ref == merge.Base {
+				pr, err = candidate, nil
+				break
+			}
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+	closePullRequest := func() {
+		if created {
+			closed := gitea.StateClosed
+			g.client.EditPullRequest(repo.Owner, repo.Name, pr.Index, gitea.EditPullRequestOption{State: &closed})
+		}
+	}
+	if pr.MergeBase == head {
+		closePullRequest()
+		return result, ErrUpToDate
+	}
+
+	style := gitea.MergeStyleMerge
+	if pr.MergeBase == base {
+		style = giteaFastForward
+	}
+	// Gitea checks whether a pull request can be merged after it is created
+	// and answers 405 until then, and for good when it conflicts or the base
+	// branch protection blocks it. The check may finish between the merge and
+	// looking at the pull request, so one that turned mergeable is merged
+	// again and only refused if that fails too.
+	merged, mergeable := false, false
+	for attempt := 0; attempt < 20 && !merged; attempt++ {
+		var response *gitea.Response
+		merged, response, err = g.client.MergePullRequest(repo.Owner, repo.Name, pr.Index, gitea.MergePullRequestOption{Style: style, Title: merge.Message, HeadCommitId: head})
+		if err != nil {
+			return result, giteaError(response, err)
+		}
+		if merged {
+			break
+		}
+		if mergeable {
+			closePullRequest()
+			return result, fmt.Errorf("%w: pull request %d", ErrRefused, pr.Index)
+		}
+		checked, response, checkErr := g.client.GetPullRequest(repo.Owner, repo.Name, pr.Index)
+		if checkErr != nil {
+			return result, giteaError(response, checkErr)
+		}
+		if mergeable = checked.Mergeable; mergeable {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+	if !merged {
+		closePullRequest()
+		result.Conflicts, err = conflictingFiles(ctx, g, repo, pr.MergeBase, base, head)
+		return result, err
+	}
+
+	pr, response, err := g.client.GetPullRequest(repo.Owner, repo.Name, pr.Index)
+	if err != nil {
+		return result, giteaError(response, err)
+	}
+	result.SHA, result.FastForward = head, style == giteaFastForward
+	if pr.MergedCommitID != nil {
+		result.SHA = *pr.MergedCommitID
+	}
+	return result, nil
+}
+
+func (g *giteaProvider) OpenPullRequest(ctx context.Context, repo Repo, base string, head string, title string, body string) (PullRequest, error) {
+	pr, err := g.createPullRequest(repo, base, head, title, body)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	return PullRequest{Number: pr.Index, URL: pr.HTMLURL}, nil
+}
+
+func (g *giteaProvider) DeleteRepo(ctx context.Context, repo Repo) error {
+	return giteaError(g.client.DeleteRepo(repo.Owner, repo.Name))
+}
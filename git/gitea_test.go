@@ -0,0 +1,212 @@
+package git
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestGitea(t *testing.T, routes map[string]fakeRoute) (GitProvider, *fakeApi) {
+	t.Helper()
+	api, server := newFakeApi(t, routes)
+	provider, err := NewGitea(server.URL, "token", "runik", "1.21.0", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, api
+}
+
+// fastPolling shortens the wait between merge checks for one test
+func fastPolling(t *testing.T) {
+	interval := pollInterval
+	pollInterval = time.Millisecond
+	t.Cleanup(func() { pollInterval = interval })
+}
+
+// giteaTree lists blobs by path and SHA
+func giteaTree(blobs map[string]string) fakeRoute {
+	var tree []map[string]interface{}
+	for file, sha := range blobs {
+		tree = append(tree, map[string]interface{}{"path": file, "type": "blob", "sha": sha, "size": 1})
+	}
+	return reply(http.StatusOK, map[string]interface{}{"tree": tree})
+}
+
+// giteaRaw serves a file by ref
+func giteaRaw(t *testing.T, versions map[string]string) fakeRoute {
+	return func(request *http.Request, body map[string]interface{}) (int, interface{}) {
+		ref := request.URL.Query().Get("ref")
+		content, ok := versions[ref]
+		if !ok {
+			t.Errorf("file requested at %s", ref)
+			return http.StatusNotFound, `{"message":"Not Found"}`
+		}
+		return http.StatusOK, content
+	}
+}
+
+func giteaBranch(sha string) fakeRoute {
+	return reply(http.StatusOK, map[string]interface{}{"commit": map[string]string{"id": sha}})
+}
+
+func TestGiteaCreateFromTemplate(t *testing.T) {
+	template := Repo{Owner: "runik", Name: "template_go"}
+	repo := Repo{Owner: "runik", Name: "user-project"}
+	routes := map[string]fakeRoute{
+		"GET /api/v1/repos/runik/template_go":           reply(http.StatusOK, map[string]string{"default_branch": "prod"}),
+		"POST /api/v1/repos/runik/template_go/generate": reply(http.StatusCreated, map[string]string{"name": "user-project"}),
+	}
+	provider, api := newTestGitea(t, routes)
+	if err := provider.CreateFromTemplate(testCtx, template, "prod", repo, "My project"); err != nil {
+		t.Fatal(err)
+	}
+	body := api.body("POST /api/v1/repos/runik/template_go/generate")
+	if body["owner"] != "runik" || body["name"] != "user-project" || body["description"] != "My project" || body["private"] != true || body["git_content"] != true {
+		t.Fatalf("generate body = %v", body)
+	}
+
+	provider, api = newTestGitea(t, routes)
+	if err := provider.CreateFromTemplate(testCtx, template, "main", repo, ""); !errors.Is(err, ErrNotDefault) {
+		t.Fatalf("another branch returned %v, want ErrNotDefault", err)
+	}
+	if api.called("POST /api/v1/repos/runik/template_go/generate") {
+		t.Fatal("generated a repository from another branch")
+	}
+
+	routes["POST /api/v1/repos/runik/template_go/generate"] = reply(http.StatusConflict, `{"message":"The repository with the same name already exists."}`)
+	provider, _ = newTestGitea(t, routes)
+	if err := provider.CreateFromTemplate(testCtx, template, "prod", repo, ""); !errors.Is(err, ErrExists) {
+		t.Fatalf("existing repository returned %v, want ErrExists", err)
+	}
+
+	provider, _ = newTestGitea(t, map[string]fakeRoute{})
+	if err := provider.CreateFromTemplate(testCtx, template, "prod", repo, ""); !IsNotFound(err) {
+		t.Fatalf("missing template returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestGiteaCommit(t *testing.T) {
+	prefix := "/api/v1/repos/runik/project"
+	provider, api := newTestGitea(t, map[string]fakeRoute{
+		"GET " + prefix + "/git/trees/dev": giteaTree(map[string]string{"README.md": "b0", "old.go": "b1"}),
+		"POST " + prefix + "/contents":     reply(http.StatusCreated, map[string]interface{}{"commit": map[string]string{"sha": "c2"}}),
+		"GET " + prefix + "/branches/dev":  giteaBranch("c1"),
+	})
+	repo := Repo{Owner: "runik", Name: "project"}
+	sha, err := provider.Commit(testCtx, repo, Commit{
+		Branch:      "dev",
+		Message:     "Update",
+		AuthorName:  "Tester",
+		AuthorEmail: "tester@example.com",
+		Changes: []FileChange{
+			{Path: "README.md", Content: []byte("hello")},
+			{Path: "new.go", Content: []byte("package main")},
+			{Path: "old.go", Delete: true},
+			{Path: "missing.go", Delete: true},
+		},
+	})
+	if err != nil || sha != "c2" {
+		t.Fatalf("Commit = %s, %v", sha, err)
+	}
+	body := api.body("POST " + prefix + "/contents")
+	author, _ := body["author"].(map[string]interface{})
+	if body["branch"] != "dev" || body["message"] != "Update" || author["email"] != "tester@example.com" {
+		t.Fatalf("commit body = %v", body)
+	}
+	var files []string
+	for _, file := range body["files"].([]interface{}) {
+		file := file.(map[string]interface{})
+		sha, _ := file["sha"].(string)
+		files = append(files, file["operation"].(string)+" "+file["path"].(string)+" "+sha)
+	}
+	if strings.Join(files, ",") != "update README.md b0,create new.go ,delete old.go b1" {
+		t.Fatalf("files = %v", files)
+	}
+
+	// Only deleting files that are gone commits nothing
+	sha, err = provider.Commit(testCtx, repo, Commit{Branch: "dev", Changes: []FileChange{{Path: "missing.go", Delete: true}}})
+	if err != nil || sha != "c1" {
+		t.Fatalf("empty commit = %s, %v, want the branch head", sha, err)
+	}
+}
+
+func TestGiteaMerge(t *testing.T) {
+	fastPolling(t)
+	prefix := "/api/v1/repos/runik/project"
+	repo := Repo{Owner: "runik", Name: "project"}
+	merge := Merge{Base: "prod", Head: "dev", Message: "Promote"}
+	routes := func(mergeBase string, merge fakeRoute, mergeable bool) map[string]fakeRoute {
+		return map[string]fakeRoute{
+			"GET " + prefix + "/branches/prod":  giteaBranch("base"),
+			"GET " + prefix + "/branches/dev":   giteaBranch("head"),
+			"POST " + prefix + "/pulls":         reply(http.StatusCreated, map[string]interface{}{"number": 3, "merge_base": mergeBase}),
+			"POST " + prefix + "/pulls/3/merge": merge,
+			"GET " + prefix + "/pulls/3":        reply(http.StatusOK, map[string]interface{}{"number": 3, "mergeable": mergeable, "merge_commit_sha": "merged"}),
+			"PATCH " + prefix + "/pulls/3":      reply(http.StatusCreated, map[string]interface{}{"number": 3}),
+		}
+	}
+
+	provider, api := newTestGitea(t, routes("head", reply(http.StatusOK, nil), true))
+	if _, err := provider.Merge(testCtx, repo, merge); !errors.Is(err, ErrUpToDate) {
+		t.Fatalf("up to date returned %v, want ErrUpToDate", err)
+	}
+	if body := api.body("PATCH " + prefix + "/pulls/3"); body["state"] != "closed" {
+		t.Fatalf("pull request without changes was not closed: %v", body)
+	}
+
+	// Gitea reports the head as the merge commit of a fast-forward
+	ahead := routes("base", reply(http.StatusOK, nil), true)
+	ahead["GET "+prefix+"/pulls/3"] = reply(http.StatusOK, map[string]interface{}{"number": 3, "merge_commit_sha": "head"})
+	provider, api = newTestGitea(t, ahead)
+	result, err := provider.Merge(testCtx, repo, merge)
+	if err != nil || !result.FastForward || result.SHA != "head" {
+		t.Fatalf("fast-forward = %+v, %v", result, err)
+	}
+	if body := api.body("POST " + prefix + "/pulls/3/merge"); body["Do"] != string(giteaFastForward) || body["head_commit_id"] != "head" {
+		t.Fatalf("merge body = %v", body)
+	}
+
+	provider, _ = newTestGitea(t, routes("original", reply(http.StatusOK, nil), true))
+	result, err = provider.Merge(testCtx, repo, merge)
+	if err != nil || result.FastForward || result.SHA != "merged" || result.HeadSHA != "head" {
+		t.Fatalf("merge = %+v, %v", result, err)
+	}
+
+	// The check finishes between the first merge and looking at the pull request
+	checking := reply(http.StatusMethodNotAllowed, `{"message":"Please try again later"}`)
+	provider, api = newTestGitea(t, routes("original", sequence(checking, reply(http.StatusOK, nil)), true))
+	result, err = provider.Merge(testCtx, repo, merge)
+	if err != nil || result.SHA != "merged" {
+		t.Fatalf("merge after the check = %+v, %v", result, err)
+	}
+	if api.called("PATCH " + prefix + "/pulls/3") {
+		t.Fatal("closed a pull request that merged")
+	}
+
+	// Branch protection refuses a mergeable pull request
+	provider, api = newTestGitea(t, routes("original", checking, true))
+	if _, err = provider.Merge(testCtx, repo, merge); !errors.Is(err, ErrRefused) {
+		t.Fatalf("refused merge returned %v, want ErrRefused", err)
+	}
+	if body := api.body("PATCH " + prefix + "/pulls/3"); body["state"] != "closed" {
+		t.Fatalf("refused pull request was not closed: %v", body)
+	}
+
+	// README.md changes the same line on both sides, main.go different ones
+	conflict := routes("original", checking, false)
+	conflict["GET "+prefix+"/git/trees/original"] = giteaTree(map[string]string{"README.md": "r0", "main.go": "m0"})
+	conflict["GET "+prefix+"/git/trees/base"] = giteaTree(map[string]string{"README.md": "r1", "main.go": "m1"})
+	conflict["GET "+prefix+"/git/trees/head"] = giteaTree(map[string]string{"README.md": "r2", "main.go": "m2"})
+	conflict["GET "+prefix+"/raw/README.md"] = giteaRaw(t, map[string]string{"original": "title\n", "base": "prod title\n", "head": "dev title\n"})
+	conflict["GET "+prefix+"/raw/main.go"] = giteaRaw(t, map[string]string{"original": "a\nb\nc\n", "base": "A\nb\nc\n", "head": "a\nb\nC\n"})
+	provider, api = newTestGitea(t, conflict)
+	result, err = provider.Merge(testCtx, repo, merge)
+	if err != nil || result.SHA != "" || strings.Join(result.Conflicts, ",") != "README.md" {
+		t.Fatalf("conflict = %+v, %v", result, err)
+	}
+	if body := api.body("PATCH " + prefix + "/pulls/3"); body["state"] != "closed" {
+		t.Fatalf("conflicting pull request was not closed: %v", body)
+	}
+}
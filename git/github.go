@@ -0,0 +1,346 @@
+package git
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// githubProvider uses the GitHub REST API. Commits are built with the git
+// data API so any number of files land in a single commit.
+type githubProvider struct {
+	rest  restClient
+	owner string
+}
+
+// NewGitHub connects to the GitHub API at baseUrl, https://api.github.com
+// when empty. client may be nil.
+func NewGitHub(baseUrl string, token string, owner string, client *http.Client) (GitProvider, error) {
+	if token == "" || owner == "" {
+		return nil, fmt.Errorf("GITHUB_TOKEN and GITHUB_OWNER are required for the github provider")
+	}
+	if baseUrl == "" {
+		baseUrl = "https://api.github.com"
+	}
+	return &githubProvider{
+		rest: restClient{
+			base: strings.TrimSuffix(baseUrl, "/"),
+			http: newHttpClient(client),
+			authorize: func(request *http.Request) {
+				request.Header.Set("Authorization", "Bearer "+token)
+				request.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+			},
+		},
+		owner: owner,
+	}, nil
+}
+
+func githubRepoPath(repo Repo) string {
+	return "/repos/" + url.PathEscape(repo.Owner) + "/" + url.PathEscape(repo.Name)
+}
+
+func (g *githubProvider) Owner() string {
+	return g.owner
+}
+
+// CreateFromTemplate generates the repository and waits until GitHub has
+// copied the default branch, which happens after the response. Generating
+// only copies the default branch, so branch has to be it.
+func (g *githubProvider) CreateFromTemplate(ctx context.Context, template Repo, branch string, repo Repo, description string) error {
+	var source struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if _, err := g.rest.do(ctx, http.MethodGet, githubRepoPath(template), nil, &source); err != nil {
+		return err
+	}
+	if source.DefaultBranch != branch {
+		return fmt.Errorf("%w: %s is not the default branch of %s/%s", ErrNotDefault, branch, template.Owner, template.Name)
+	}
+	_, err := g.rest.do(ctx, http.MethodPost, githubRepoPath(template)+"/generate", map[string]interface{}{
+		"owner":       repo.Owner,
+		"name":        repo.Name,
+		"description": description,
+		"private":     true,
+	}, nil)
+	var status *httpError
+	if errors.As(err, &status) && status.Status == http.StatusUnprocessableEntity {
+		return ErrExists
+	} else if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 20; attempt++ {
+		if _, err = g.BranchHead(ctx, repo, branch); !IsNotFound(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+	return err
+}
+
+func (g *githubProvider) CreateBranch(ctx context.Context, repo Repo, branch string, from string) error {
+	sha, err := g.BranchHead(ctx, repo, from)
+	if err != nil {
+		return err
+	}
+	_, err = g.rest.do(ctx, http.MethodPost, githubRepoPath(repo)+"/git/refs", map[string]string{"ref": "refs/heads/" + branch, "sha": sha}, nil)
+	return err
+}
+
+func (g *githubProvider) BranchHead(ctx context.Context, repo Repo, branch string) (string, error) {
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	_, err := g.rest.do(ctx, http.MethodGet, githubRepoPath(repo)+"/git/ref/heads/"+escapePath(branch), nil, &ref)
+	return ref.Object.SHA, err
+}
+
+func (g *githubProvider) ReadTree(ctx context.Context, repo Repo, ref string) ([]TreeEntry, error) {
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+			SHA  string `json:"sha"`
+			Size int64  `json:"size"`
+		} `json:"tree"`
+	}
+	_, err := g.rest.do(ctx, http.MethodGet, githubRepoPath(repo)+"/git/trees/"+escapePath(ref)+"?recursive=1", nil, &tree)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]TreeEntry, 0, len(tree.Tree))
+	for _, entry := range tree.Tree {
+		// Submodules are listed as commits
+		if entry.Type == string(Blob) || entry.Type == string(Tree) {
+			entries = append(entries, TreeEntry{Path: entry.Path, Type: EntryType(entry.Type), SHA: entry.SHA, Size: entry.Size})
+		}
+	}
+	return entries, nil
+}
+
+type githubContent struct {
+	Type     string `json:"type"`
+	SHA      string `json:"sha"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+// ReadFile uses the contents API, which leaves content empty for files over
+// 1 MB, so those are read through the blob API
+func (g *githubProvider) ReadFile(ctx context.Context, repo Repo, ref string, path string) ([]byte, error) {
+	var raw []byte
+	_, err := g.rest.do(ctx, http.MethodGet, githubRepoPath(repo)+"/contents/"+escapePath(path)+"?ref="+url.QueryEscape(ref), nil, &raw)
+	if err != nil {
+		return nil, err
+	}
+	// Directories are listed as an array
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+		return nil, fmt.Errorf("%w: %s is not a file", ErrNotFound, path)
+	}
+	var content githubContent
+	if err := sonic.Unmarshal(raw, &content); err != nil {
+		return nil, err
+	}
+	if content.Type != "file" {
+		return nil, fmt.Errorf("%w: %s is not a file", ErrNotFound, path)
+	}
+	if content.Encoding != "base64" || content.Content == "" {
+		var blob githubContent
+		if _, err := g.rest.do(ctx, http.MethodGet, githubRepoPath(repo)+"/git/blobs/"+content.SHA, nil, &blob); err != nil {
+			return nil, err
+		}
+		content = blob
+	}
+	return base64.StdEncoding.DecodeString(strings.ReplaceAll(content.Content, "\n", ""))
+}
+
+type githubTreeEntry struct {
+	Path string  `json:"path"`
+	Mode string  `json:"mode"`
+	Type string  `json:"type"`
+	SHA  *string `json:"sha"`
+}
+
+func (g *githubProvider) Commit(ctx context.Context, repo Repo, commit Commit) (string, error) {
+	head, err := g.BranchHead(ctx, repo, commit.Branch)
+	if err != nil {
+		return "", err
+	}
+	var parent struct {
+		Tree struct {
+			SHA string `json:"sha"`
+		} `json:"tree"`
+	}
+	if _, err := g.rest.do(ctx, http.MethodGet, githubRepoPath(repo)+"/git/commits/"+head, nil, &parent); err != nil {
+		return "", err
+	}
+	// Deleting a path that does not exist fails, so deletes are checked against the tree
+	existing, err := g.ReadTree(ctx, repo, head)
+	if err != nil {
+		return "", err
+	}
+	files := map[string]bool{}
+	for _, entry := range existing {
+		files[entry.Path] = entry.Type == Blob
+	}
+
+	var entries []githubTreeEntry
+	for _, change := range commit.Changes {
+		if change.Delete {
+			if files[change.Path] {
+				entries = append(entries, githubTreeEntry{Path: change.Path, Mode: "100644", Type: string(Blob)})
+			}
+			continue
+		}
+		var blob struct {
+			SHA string `json:"sha"`
+		}
+		_, err := g.rest.do(ctx, http.MethodPost, githubRepoPath(repo)+"/git/blobs", map[string]string{
+			"content":  base64.StdEncoding.EncodeToString(change.Content),
+			"encoding": "base64",
+		}, &blob)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, githubTreeEntry{Path: change.Path, Mode: "100644", Type: string(Blob), SHA: &blob.SHA})
+	}
+	if len(entries) == 0 {
+		return head, nil
+	}
+
+	var tree struct {
+		SHA string `json:"sha"`
+	}
+	_, err = g.rest.do(ctx, http.MethodPost, githubRepoPath(repo)+"/git/trees", map[string]interface{}{"base_tree": parent.Tree.SHA, "tree": entries}, &tree)
+	if err != nil {
+		return "", err
+	}
+	if tree.SHA == parent.Tree.SHA {
+		return head, nil
+	}
+	body := map[string]interface{}{"message": commit.Message, "tree": tree.SHA, "parents": []string{head}}
+	// GitHub needs an email for the author, without one the token owner is the author
+	if commit.AuthorName != "" && commit.AuthorEmail != "" {
+		body["author"] = map[string]string{"name": commit.AuthorName, "email": commit.AuthorEmail}
+	}
+	var created struct {
+		SHA string `json:"sha"`
+	}
+	if _, err := g.rest.do(ctx, http.MethodPost, githubRepoPath(repo)+"/git/commits", body, &created); err != nil {
+		return "", err
+	}
+	// Not forced, so a branch that moved in the meantime is not overwritten
+	_, err = g.rest.do(ctx, http.MethodPatch, githubRepoPath(repo)+"/git/refs/heads/"+escapePath(commit.Branch), map[string]interface{}{"sha": created.SHA, "force": false}, nil)
+	if err != nil {
+		return "", err
+	}
+	return created.SHA, nil
+}
+
+func (g *githubProvider) SetDescription(ctx context.Context, repo Repo, description string) error {
+	_, err := g.rest.do(ctx, http.MethodPatch, githubRepoPath(repo), map[string]string{"description": description}, nil)
+	return err
+}
+
+// Merge compares the branches first, a base without commits of its own is
+// moved to the head. GitHub refuses conflicting merges without naming the
+// files and answers 409 for protected branches as well, told apart by message.
+func (g *githubProvider) Merge(ctx context.Context, repo Repo, merge Merge) (MergeResult, error) {
+	base, err := g.BranchHead(ctx, repo, merge.Base)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	head, err := g.BranchHead(ctx, repo, merge.Head)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	result := MergeResult{HeadSHA: head}
+	var comparison struct {
+		Status    string `json:"status"`
+		MergeBase struct {
+			SHA string `json:"sha"`
+		} `json:"merge_base_commit"`
+	}
+	if _, err := g.rest.do(ctx, http.MethodGet, githubRepoPath(repo)+"/compare/"+base+"..."+head, nil, &comparison); err != nil {
+		return result, err
+	}
+
+	switch comparison.Status {
+	case "identical", "behind":
+		return result, ErrUpToDate
+	case "ahead":
+		_, err := g.rest.do(ctx, http.MethodPatch, githubRepoPath(repo)+"/git/refs/heads/"+escapePath(merge.Base), map[string]interface{}{"sha": head, "force": false}, nil)
+		var status *httpError
+		if errors.As(err, &status) && status.Status == http.StatusUnprocessableEntity && strings.Contains(status.Body, "Protected branch") {
+			return result, fmt.Errorf("%w: %s", ErrRefused, status.Body)
+		} else if err != nil {
+			return result, err
+		}
+		result.SHA, result.FastForward = head, true
+		return result, nil
+	}
+
+	var merged struct {
+		SHA string `json:"sha"`
+	}
+	_, err = g.rest.do(ctx, http.MethodPost, githubRepoPath(repo)+"/merges", map[string]string{"base": merge.Base, "head": head, "commit_message": merge.Message}, &merged)
+	var status *httpError
+	if errors.As(err, &status) && status.Status == http.StatusConflict && strings.Contains(status.Body, "Merge conflict") {
+		result.Conflicts, err = conflictingFiles(ctx, g, repo, comparison.MergeBase.SHA, base, head)
+		return result, err
+	} else if errors.As(err, &status) && status.Status == http.StatusConflict {
+		return result, fmt.Errorf("%w: %s", ErrRefused, status.Body)
+	} else if err != nil {
+		return result, err
+	}
+	result.SHA = merged.SHA
+	return result, nil
+}
+
+func (g *githubProvider) OpenPullRequest(ctx context.Context, repo Repo, base string, head string, title string, body string) (PullRequest, error) {
+	var created struct {
+		Number  int64  `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	_, err := g.rest.do(ctx, http.MethodPost, githubRepoPath(repo)+"/pulls", map[string]string{"title": title, "head": head, "base": base, "body": body}, &created)
+	var status *httpError
+	if errors.As(err, &status) && status.Status == http.StatusUnprocessableEntity {
+		if strings.Contains(status.Body, "No commits between") {
+			return PullRequest{}, ErrUpToDate
+		}
+		if strings.Contains(status.Body, "already exists") {
+			return PullRequest{}, ErrExists
+		}
+	}
+	if err != nil {
+		return PullRequest{}, err
+	}
+	return PullRequest{Number: created.Number, URL: created.HTMLURL}, nil
+}
+
+func (g *githubProvider) DeleteRepo(ctx context.Context, repo Repo) error {
+	_, err := g.rest.do(ctx, http.MethodDelete, githubRepoPath(repo), nil, nil)
+	return err
+}
+
+// escapePath escapes every segment of a slash separated path
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,235 @@
+package git
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newTestGitHub(t *testing.T, routes map[string]fakeRoute) (GitProvider, *fakeApi) {
+	t.Helper()
+	api, server := newFakeApi(t, routes)
+	provider, err := NewGitHub(server.URL, "token", "runik", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, api
+}
+
+func githubRef(sha string) fakeRoute {
+	return reply(http.StatusOK, map[string]interface{}{"object": map[string]string{"sha": sha}})
+}
+
+// githubTree lists blobs by path and SHA
+func githubTree(blobs map[string]string) fakeRoute {
+	var tree []map[string]interface{}
+	for file, sha := range blobs {
+		tree = append(tree, map[string]interface{}{"path": file, "type": "blob", "sha": sha, "size": 1})
+	}
+	return reply(http.StatusOK, map[string]interface{}{"tree": tree})
+}
+
+// githubContents serves a file by ref
+func githubContents(t *testing.T, versions map[string]string) fakeRoute {
+	return func(request *http.Request, body map[string]interface{}) (int, interface{}) {
+		ref := request.URL.Query().Get("ref")
+		content, ok := versions[ref]
+		if !ok {
+			t.Errorf("file requested at %s", ref)
+			return http.StatusNotFound, `{"message":"Not Found"}`
+		}
+		return http.StatusOK, map[string]string{"type": "file", "encoding": "base64", "content": base64.StdEncoding.EncodeToString([]byte(content))}
+	}
+}
+
+func TestGitHubCreateFromTemplate(t *testing.T) {
+	template := Repo{Owner: "runik", Name: "template_go"}
+	repo := Repo{Owner: "runik", Name: "user-project"}
+	routes := map[string]fakeRoute{
+		"GET /repos/runik/template_go":                     reply(http.StatusOK, map[string]string{"default_branch": "prod"}),
+		"POST /repos/runik/template_go/generate":           reply(http.StatusCreated, map[string]string{"default_branch": "prod"}),
+		"GET /repos/runik/user-project/git/ref/heads/prod": githubRef("c1"),
+	}
+	provider, api := newTestGitHub(t, routes)
+	if err := provider.CreateFromTemplate(testCtx, template, "prod", repo, "My project"); err != nil {
+		t.Fatal(err)
+	}
+	body := api.body("POST /repos/runik/template_go/generate")
+	if body["owner"] != "runik" || body["name"] != "user-project" || body["description"] != "My project" || body["private"] != true {
+		t.Fatalf("generate body = %v", body)
+	}
+
+	provider, api = newTestGitHub(t, routes)
+	err := provider.CreateFromTemplate(testCtx, template, "main", repo, "")
+	if !errors.Is(err, ErrNotDefault) {
+		t.Fatalf("another branch returned %v, want ErrNotDefault", err)
+	}
+	if api.called("POST /repos/runik/template_go/generate") {
+		t.Fatal("generated a repository from another branch")
+	}
+
+	routes["POST /repos/runik/template_go/generate"] = reply(http.StatusUnprocessableEntity, `{"message":"Name already exists on this account"}`)
+	provider, _ = newTestGitHub(t, routes)
+	if err := provider.CreateFromTemplate(testCtx, template, "prod", repo, ""); !errors.Is(err, ErrExists) {
+		t.Fatalf("existing repository returned %v, want ErrExists", err)
+	}
+
+	provider, _ = newTestGitHub(t, map[string]fakeRoute{})
+	if err := provider.CreateFromTemplate(testCtx, template, "prod", repo, ""); !IsNotFound(err) {
+		t.Fatalf("missing template returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestGitHubCommit(t *testing.T) {
+	prefix := "/repos/runik/project"
+	provider, api := newTestGitHub(t, map[string]fakeRoute{
+		"GET " + prefix + "/git/ref/heads/dev":    githubRef("c1"),
+		"GET " + prefix + "/git/commits/c1":       reply(http.StatusOK, map[string]interface{}{"tree": map[string]string{"sha": "t1"}}),
+		"GET " + prefix + "/git/trees/c1":         githubTree(map[string]string{"README.md": "b0", "old.go": "b1"}),
+		"POST " + prefix + "/git/blobs":           reply(http.StatusCreated, map[string]string{"sha": "b2"}),
+		"POST " + prefix + "/git/trees":           reply(http.StatusCreated, map[string]string{"sha": "t2"}),
+		"POST " + prefix + "/git/commits":         reply(http.StatusCreated, map[string]string{"sha": "c2"}),
+		"PATCH " + prefix + "/git/refs/heads/dev": reply(http.StatusOK, map[string]interface{}{"object": map[string]string{"sha": "c2"}}),
+	})
+	sha, err := provider.Commit(testCtx, Repo{Owner: "runik", Name: "project"}, Commit{
+		Branch:      "dev",
+		Message:     "Update",
+		AuthorName:  "Tester",
+		AuthorEmail: "tester@example.com",
+		Changes: []FileChange{
+			{Path: "README.md", Content: []byte("hello")},
+			{Path: "old.go", Delete: true},
+			{Path: "missing.go", Delete: true},
+		},
+	})
+	if err != nil || sha != "c2" {
+		t.Fatalf("Commit = %s, %v", sha, err)
+	}
+
+	tree := api.body("POST " + prefix + "/git/trees")
+	entries, _ := tree["tree"].([]interface{})
+	if tree["base_tree"] != "t1" || len(entries) != 2 {
+		t.Fatalf("tree body = %v, want two entries on t1", tree)
+	}
+	deleted, _ := entries[1].(map[string]interface{})
+	if deleted["path"] != "old.go" || deleted["sha"] != nil {
+		t.Fatalf("delete entry = %v", deleted)
+	}
+	commit := api.body("POST " + prefix + "/git/commits")
+	author, _ := commit["author"].(map[string]interface{})
+	parents, _ := commit["parents"].([]interface{})
+	if commit["tree"] != "t2" || len(parents) != 1 || parents[0] != "c1" || author["email"] != "tester@example.com" {
+		t.Fatalf("commit body = %v", commit)
+	}
+	ref := api.body("PATCH " + prefix + "/git/refs/heads/dev")
+	if ref["sha"] != "c2" || ref["force"] != false {
+		t.Fatalf("ref body = %v, want an unforced update to c2", ref)
+	}
+}
+
+func TestGitHubMerge(t *testing.T) {
+	prefix := "/repos/runik/project"
+	repo := Repo{Owner: "runik", Name: "project"}
+	merge := Merge{Base: "prod", Head: "dev", Message: "Promote"}
+	routes := func(status string) map[string]fakeRoute {
+		return map[string]fakeRoute{
+			"GET " + prefix + "/git/ref/heads/prod": githubRef("base"),
+			"GET " + prefix + "/git/ref/heads/dev":  githubRef("head"),
+			"GET " + prefix + "/compare/base...head": reply(http.StatusOK, map[string]interface{}{
+				"status":            status,
+				"merge_base_commit": map[string]string{"sha": "original"},
+			}),
+		}
+	}
+
+	for _, status := range []string{"identical", "behind"} {
+		provider, _ := newTestGitHub(t, routes(status))
+		if _, err := provider.Merge(testCtx, repo, merge); !errors.Is(err, ErrUpToDate) {
+			t.Fatalf("%s returned %v, want ErrUpToDate", status, err)
+		}
+	}
+
+	ahead := routes("ahead")
+	ahead["PATCH "+prefix+"/git/refs/heads/prod"] = reply(http.StatusOK, nil)
+	provider, api := newTestGitHub(t, ahead)
+	result, err := provider.Merge(testCtx, repo, merge)
+	if err != nil || !result.FastForward || result.SHA != "head" {
+		t.Fatalf("fast-forward = %+v, %v", result, err)
+	}
+	if body := api.body("PATCH " + prefix + "/git/refs/heads/prod"); body["sha"] != "head" || body["force"] != false {
+		t.Fatalf("fast-forward body = %v", body)
+	}
+
+	diverged := routes("diverged")
+	diverged["POST "+prefix+"/merges"] = reply(http.StatusCreated, map[string]string{"sha": "merged"})
+	provider, api = newTestGitHub(t, diverged)
+	result, err = provider.Merge(testCtx, repo, merge)
+	if err != nil || result.FastForward || result.SHA != "merged" || result.HeadSHA != "head" {
+		t.Fatalf("merge = %+v, %v", result, err)
+	}
+	if body := api.body("POST " + prefix + "/merges"); body["base"] != "prod" || body["head"] != "head" || body["commit_message"] != "Promote" {
+		t.Fatalf("merge body = %v", body)
+	}
+
+	// README.md changes the same line on both sides, main.go different ones
+	// and notes.md is removed from prod while dev changes it
+	conflict := routes("diverged")
+	conflict["POST "+prefix+"/merges"] = reply(http.StatusConflict, `{"message":"Merge conflict"}`)
+	conflict["GET "+prefix+"/git/trees/original"] = githubTree(map[string]string{"README.md": "r0", "main.go": "m0", "notes.md": "n0"})
+	conflict["GET "+prefix+"/git/trees/base"] = githubTree(map[string]string{"README.md": "r1", "main.go": "m1"})
+	conflict["GET "+prefix+"/git/trees/head"] = githubTree(map[string]string{"README.md": "r2", "main.go": "m2", "notes.md": "n2"})
+	conflict["GET "+prefix+"/contents/README.md"] = githubContents(t, map[string]string{"original": "title\n", "base": "prod title\n", "head": "dev title\n"})
+	conflict["GET "+prefix+"/contents/main.go"] = githubContents(t, map[string]string{"original": "a\nb\nc\n", "base": "A\nb\nc\n", "head": "a\nb\nC\n"})
+	provider, _ = newTestGitHub(t, conflict)
+	result, err = provider.Merge(testCtx, repo, merge)
+	if err != nil || result.SHA != "" || strings.Join(result.Conflicts, ",") != "README.md,notes.md" {
+		t.Fatalf("conflict = %+v, %v", result, err)
+	}
+
+	// Files GitHub cannot merge are all listed when they merge line by line
+	conflict["GET "+prefix+"/git/trees/base"] = githubTree(map[string]string{"README.md": "r0", "main.go": "m1", "notes.md": "n0"})
+	provider, _ = newTestGitHub(t, conflict)
+	result, err = provider.Merge(testCtx, repo, merge)
+	if err != nil || strings.Join(result.Conflicts, ",") != "main.go" {
+		t.Fatalf("conflict merging line by line = %+v, %v", result, err)
+	}
+
+	protected := routes("diverged")
+	protected["POST "+prefix+"/merges"] = reply(http.StatusConflict, `{"message":"Required status check \"build\" is expected."}`)
+	provider, _ = newTestGitHub(t, protected)
+	if result, err = provider.Merge(testCtx, repo, merge); !errors.Is(err, ErrRefused) || len(result.Conflicts) != 0 {
+		t.Fatalf("protected branch = %+v, %v, want ErrRefused", result, err)
+	}
+}
+
+func TestGitHubErrors(t *testing.T) {
+	prefix := "/repos/runik/project"
+	repo := Repo{Owner: "runik", Name: "project"}
+	provider, _ := newTestGitHub(t, map[string]fakeRoute{
+		"POST " + prefix + "/pulls":            reply(http.StatusUnprocessableEntity, `{"message":"Validation Failed","errors":[{"message":"A pull request already exists for runik:dev."}]}`),
+		"GET " + prefix + "/git/ref/heads/dev": githubRef("c1"),
+		"POST " + prefix + "/git/refs":         reply(http.StatusUnprocessableEntity, `{"message":"Reference already exists"}`),
+	})
+	if _, err := provider.OpenPullRequest(testCtx, repo, "prod", "dev", "Promote", ""); !errors.Is(err, ErrExists) {
+		t.Fatalf("open pull request returned %v, want ErrExists", err)
+	}
+	if _, err := provider.BranchHead(testCtx, repo, "missing"); !IsNotFound(err) {
+		t.Fatalf("missing branch returned %v, want ErrNotFound", err)
+	}
+	if _, err := provider.ReadFile(testCtx, repo, "dev", "missing.go"); !IsNotFound(err) {
+		t.Fatalf("missing file returned %v, want ErrNotFound", err)
+	}
+	var status *httpError
+	if err := provider.CreateBranch(testCtx, repo, "feature", "dev"); !errors.As(err, &status) || status.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("existing branch returned %v", err)
+	}
+
+	provider, _ = newTestGitHub(t, map[string]fakeRoute{
+		"POST " + prefix + "/pulls": reply(http.StatusUnprocessableEntity, `{"message":"Validation Failed","errors":[{"message":"No commits between prod and dev"}]}`),
+	})
+	if _, err := provider.OpenPullRequest(testCtx, repo, "prod", "dev", "Promote", ""); !errors.Is(err, ErrUpToDate) {
+		t.Fatalf("empty pull request returned %v, want ErrUpToDate", err)
+	}
+}
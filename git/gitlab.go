@@ -0,0 +1,354 @@
+package git
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitlabProvider uses the GitLab REST API v4. Owner is the path of the user
+// or group namespace projects are created in.
+type gitlabProvider struct {
+	rest  restClient
+	owner string
+}
+
+// NewGitLab connects to the GitLab instance at baseUrl, https://gitlab.com
+// when empty. client may be nil.
+func NewGitLab(baseUrl string, token string, owner string, client *http.Client) (GitProvider, error) {
+	if token == "" || owner == "" {
+		return nil, fmt.Errorf("GITLAB_TOKEN and GITLAB_OWNER are required for the gitlab provider")
+	}
+	if baseUrl == "" {
+		baseUrl = "https://gitlab.com"
+	}
+	return &gitlabProvider{
+		rest: restClient{
+			base: strings.TrimSuffix(baseUrl, "/") + "/api/v4",
+			http: newHttpClient(client),
+			authorize: func(request *http.Request) {
+				request.Header.Set("PRIVATE-TOKEN", token)
+			},
+		},
+		owner: owner,
+	}, nil
+}
+
+// gitlabProjectPath addresses a project by its URL encoded full path
+func gitlabProjectPath(repo Repo) string {
+	return "/projects/" + url.PathEscape(repo.Owner+"/"+repo.Name)
+}
+
+func (g *gitlabProvider) Owner() string {
+	return g.owner
+}
+
+// CreateFromTemplate creates an empty project and commits the files of
+// branch of the template to it. GitLab's own custom project templates need
+// a paid tier, copying works on every instance.
+func (g *gitlabProvider) CreateFromTemplate(ctx context.Context, template Repo, branch string, repo Repo, description string) error {
+	tree, err := g.ReadTree(ctx, template, branch)
+	if err != nil {
+		return err
+	}
+	var changes []FileChange
+	for _, entry := range tree {
+		if entry.Type != Blob {
+			continue
+		}
+		content, err := g.ReadFile(ctx, template, branch, entry.Path)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, FileChange{Path: entry.Path, Content: content})
+	}
+
+	var namespace struct {
+		ID int64 `json:"id"`
+	}
+	if _, err := g.rest.do(ctx, http.MethodGet, "/namespaces/"+url.PathEscape(repo.Owner), nil, &namespace); err != nil {
+		return err
+	}
+	_, err = g.rest.do(ctx, http.MethodPost, "/projects", map[string]interface{}{
+		"name":           repo.Name,
+		"path":           repo.Name,
+		"namespace_id":   namespace.ID,
+		"description":    description,
+		"visibility":     "private",
+		"default_branch": branch,
+	}, nil)
+	var status *httpError
+	if errors.As(err, &status) && status.Status == http.StatusBadRequest && strings.Contains(status.Body, "has already been taken") {
+		return ErrExists
+	} else if err != nil {
+		return err
+	}
+
+	// The first commit to an empty repository creates its branch
+	_, err = g.rest.do(ctx, http.MethodPost, gitlabProjectPath(repo)+"/repository/commits", map[string]interface{}{
+		"branch":         branch,
+		"commit_message": "Create from " + template.Owner + "/" + template.Name,
+		"actions":        gitlabActions(changes, nil),
+	}, nil)
+	if err != nil {
+		g.DeleteRepo(ctx, repo)
+		return err
+	}
+	return nil
+}
+
+func (g *gitlabProvider) CreateBranch(ctx context.Context, repo Repo, branch string, from string) error {
+	query := url.Values{"branch": {branch}, "ref": {from}}
+	_, err := g.rest.do(ctx, http.MethodPost, gitlabProjectPath(repo)+"/repository/branches?"+query.Encode(), nil, nil)
+	return err
+}
+
+func (g *gitlabProvider) BranchHead(ctx context.Context, repo Repo, branch string) (string, error) {
+	var result struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+	_, err := g.rest.do(ctx, http.MethodGet, gitlabProjectPath(repo)+"/repository/branches/"+url.PathEscape(branch), nil, &result)
+	return result.Commit.ID, err
+}
+
+// ReadTree follows the pagination of the tree API. GitLab does not report file sizes.
+func (g *gitlabProvider) ReadTree(ctx context.Context, repo Repo, ref string) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	for page := "1"; page != ""; {
+		query := url.Values{"ref": {ref}, "recursive": {"true"}, "per_page": {"100"}, "page": {page}}
+		var result []struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+			Path string `json:"path"`
+		}
+		response, err := g.rest.do(ctx, http.MethodGet, gitlabProjectPath(repo)+"/repository/tree?"+query.Encode(), nil, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range result {
+			if entry.Type == string(Blob) || entry.Type == string(Tree) {
+				entries = append(entries, TreeEntry{Path: entry.Path, Type: EntryType(entry.Type), SHA: entry.ID})
+			}
+		}
+		page = response.Header.Get("X-Next-Page")
+	}
+	return entries, nil
+}
+
+func (g *gitlabProvider) ReadFile(ctx context.Context, repo Repo, ref string, path string) ([]byte, error) {
+	var content []byte
+	_, err := g.rest.do(ctx, http.MethodGet, gitlabProjectPath(repo)+"/repository/files/"+url.PathEscape(path)+"/raw?ref="+url.QueryEscape(ref), nil, &content)
+	return content, err
+}
+
+// gitlabActions turns changes into commit actions. GitLab separates creating
+// from updating, so existing lists the files of the branch; nil means it is empty.
+func gitlabActions(changes []FileChange, existing map[string]bool) []map[string]string {
+	actions := make([]map[string]string, 0, len(changes))
+	for _, change := range changes {
+		switch {
+		case change.Delete && existing[change.Path]:
+			actions = append(actions, map[string]string{"action": "delete", "file_path": change.Path})
+		case change.Delete:
+		default:
+			action := "create"
+			if existing[change.Path] {
+				action = "update"
+			}
+			actions = append(actions, map[string]string{
+				"action":    action,
+				"file_path": change.Path,
+				"content":   base64.StdEncoding.EncodeToString(change.Content),
+				"encoding":  "base64",
+			})
+		}
+	}
+	return actions
+}
+
+func (g *gitlabProvider) Commit(ctx context.Context, repo Repo, commit Commit) (string, error) {
+	tree, err := g.ReadTree(ctx, repo, commit.Branch)
+	if err != nil {
+		return "", err
+	}
+	existing := map[string]bool{}
+	for _, entry := range tree {
+		existing[entry.Path] = entry.Type == Blob
+	}
+	actions := gitlabActions(commit.Changes, existing)
+	if len(actions) == 0 {
+		return g.BranchHead(ctx, repo, commit.Branch)
+	}
+
+	body := map[string]interface{}{
+		"branch":         commit.Branch,
+		"commit_message": commit.Message,
+		"actions":        actions,
+	}
+	if commit.AuthorName != "" {
+		body["author_name"] = commit.AuthorName
+	}
+	if commit.AuthorEmail != "" {
+		body["author_email"] = commit.AuthorEmail
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if _, err := g.rest.do(ctx, http.MethodPost, gitlabProjectPath(repo)+"/repository/commits", body, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (g *gitlabProvider) SetDescription(ctx context.Context, repo Repo, description string) error {
+	_, err := g.rest.do(ctx, http.MethodPut, gitlabProjectPath(repo), map[string]string{"description": description}, nil)
+	return err
+}
+
+type gitlabMergeRequest struct {
+	IID                 int64  `json:"iid"`
+	WebURL              string `json:"web_url"`
+	HasConflicts        bool   `json:"has_conflicts"`
+	DetailedMergeStatus string `json:"detailed_merge_status"`
+	MergeCommitSHA      string `json:"merge_commit_sha"`
+}
+
+func gitlabMergeRequestPath(repo Repo, iid int64) string {
+	return gitlabProjectPath(repo) + "/merge_requests/" + strconv.FormatInt(iid, 10)
+}
+
+func (g *gitlabProvider) mergeBase(ctx context.Context, repo Repo, a string, b string) (string, error) {
+	query := url.Values{"refs[]": {a, b}}
+	var commit struct {
+		ID string `json:"id"`
+	}
+	_, err := g.rest.do(ctx, http.MethodGet, gitlabProjectPath(repo)+"/repository/merge_base?"+query.Encode(), nil, &commit)
+	return commit.ID, err
+}
+
+// createMergeRequest opens a merge request of head into base, ErrExists when one is open already
+func (g *gitlabProvider) createMergeRequest(ctx context.Context, repo Repo, base string, head string, title string, body string) (gitlabMergeRequest, error) {
+	var request gitlabMergeRequest
+	_, err := g.rest.do(ctx, http.MethodPost, gitlabProjectPath(repo)+"/merge_requests", map[string]string{
+		"source_branch": head,
+		"target_branch": base,
+		"title":         title,
+		"description":   body,
+	}, &request)
+	var status *httpError
+	if errors.As(err, &status) && status.Status == http.StatusConflict {
+		return request, ErrExists
+	}
+	return request, err
+}
+
+// Merge goes through a merge request, GitLab cannot merge branches directly.
+// Whether it fast-forwards follows the merge method of the project. GitLab
+// answers 405 to merge requests blocked by approvals, pipelines or protection.
+func (g *gitlabProvider) Merge(ctx context.Context, repo Repo, merge Merge) (MergeResult, error) {
+	base, err := g.BranchHead(ctx, repo, merge.Base)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	head, err := g.BranchHead(ctx, repo, merge.Head)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	result := MergeResult{HeadSHA: head}
+	mergeBase, err := g.mergeBase(ctx, repo, base, head)
+	if err != nil {
+		return result, err
+	}
+	if mergeBase == head {
+		return result, ErrUpToDate
+	}
+
+	request, err := g.createMergeRequest(ctx, repo, merge.Base, merge.Head, merge.Message, "")
+	created := err == nil
+	if err == ErrExists {
+		var open []gitlabMergeRequest
+		query := url.Values{"state": {"opened"}, "source_branch": {merge.Head}, "target_branch": {merge.Base}}
+		if _, err = g.rest.do(ctx, http.MethodGet, gitlabProjectPath(repo)+"/merge_requests?"+query.Encode(), nil, &open); err == nil && len(open) == 0 {
+			err = fmt.Errorf("%w: merge request of %s into %s", ErrNotFound, merge.Head, merge.Base)
+		} else if err == nil {
+			request = open[0]
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// GitLab checks whether a merge request can be merged after it is created
+	for attempt := 0; attempt < 20; attempt++ {
+		if _, err := g.rest.do(ctx, http.MethodGet, gitlabMergeRequestPath(repo, request.IID), nil, &request); err != nil {
+			return result, err
+		}
+		if request.DetailedMergeStatus != "unchecked" && request.DetailedMergeStatus != "checking" && request.DetailedMergeStatus != "preparing" {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+	closeMergeRequest := func() {
+		if created {
+			g.rest.do(ctx, http.MethodPut, gitlabMergeRequestPath(repo, request.IID), map[string]string{"state_event": "close"}, nil)
+		}
+	}
+	if request.HasConflicts {
+		closeMergeRequest()
+		result.Conflicts, err = conflictingFiles(ctx, g, repo, mergeBase, base, head)
+		return result, err
+	}
+
+	_, err = g.rest.do(ctx, http.MethodPut, gitlabMergeRequestPath(repo, request.IID)+"/merge", map[string]string{"merge_commit_message": merge.Message, "sha": head}, &request)
+	var status *httpError
+	if errors.As(err, &status) && status.Status == http.StatusMethodNotAllowed {
+		closeMergeRequest()
+		return result, fmt.Errorf("%w: %s", ErrRefused, status.Body)
+	} else if err != nil {
+		return result, err
+	}
+	result.SHA = request.MergeCommitSHA
+	if result.SHA == "" {
+		result.SHA, result.FastForward = head, true
+	}
+	return result, nil
+}
+
+func (g *gitlabProvider) OpenPullRequest(ctx context.Context, repo Repo, base string, head string, title string, body string) (PullRequest, error) {
+	baseSHA, err := g.BranchHead(ctx, repo, base)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	headSHA, err := g.BranchHead(ctx, repo, head)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	// GitLab opens merge requests without changes
+	if mergeBase, err := g.mergeBase(ctx, repo, baseSHA, headSHA); err != nil {
+		return PullRequest{}, err
+	} else if mergeBase == headSHA {
+		return PullRequest{}, ErrUpToDate
+	}
+	request, err := g.createMergeRequest(ctx, repo, base, head, title, body)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	return PullRequest{Number: request.IID, URL: request.WebURL}, nil
+}
+
+func (g *gitlabProvider) DeleteRepo(ctx context.Context, repo Repo) error {
+	_, err := g.rest.do(ctx, http.MethodDelete, gitlabProjectPath(repo), nil, nil)
+	return err
+}
@@ -0,0 +1,251 @@
+package git
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newTestGitLab(t *testing.T, routes map[string]fakeRoute) (GitProvider, *fakeApi) {
+	t.Helper()
+	api, server := newFakeApi(t, routes)
+	provider, err := NewGitLab(server.URL, "token", "runik", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, api
+}
+
+// gitlabTree lists blobs by path and SHA at the ref it expects
+func gitlabTree(t *testing.T, ref string, blobs map[string]string) fakeRoute {
+	return func(request *http.Request, body map[string]interface{}) (int, interface{}) {
+		if got := request.URL.Query().Get("ref"); got != ref {
+			t.Errorf("tree of %s requested, want %s", got, ref)
+		}
+		var tree []map[string]string
+		for file, sha := range blobs {
+			tree = append(tree, map[string]string{"id": sha, "type": "blob", "path": file})
+		}
+		return http.StatusOK, tree
+	}
+}
+
+func gitlabBranch(sha string) fakeRoute {
+	return reply(http.StatusOK, map[string]interface{}{"commit": map[string]string{"id": sha}})
+}
+
+// gitlabRaw serves a file by ref
+func gitlabRaw(t *testing.T, versions map[string]string) fakeRoute {
+	return func(request *http.Request, body map[string]interface{}) (int, interface{}) {
+		ref := request.URL.Query().Get("ref")
+		content, ok := versions[ref]
+		if !ok {
+			t.Errorf("file requested at %s", ref)
+			return http.StatusNotFound, `{"message":"404 File Not Found"}`
+		}
+		return http.StatusOK, content
+	}
+}
+
+func TestGitLabCreateFromTemplate(t *testing.T) {
+	template := Repo{Owner: "runik", Name: "template_go"}
+	repo := Repo{Owner: "runik", Name: "user-project"}
+	routes := map[string]fakeRoute{
+		"GET /api/v4/projects/runik%2Ftemplate_go/repository/tree":              gitlabTree(t, "release", map[string]string{"main.go": "m1"}),
+		"GET /api/v4/projects/runik%2Ftemplate_go/repository/files/main.go/raw": reply(http.StatusOK, "package main\n"),
+		"GET /api/v4/namespaces/runik":                                          reply(http.StatusOK, map[string]int64{"id": 7}),
+		"POST /api/v4/projects":                                                 reply(http.StatusCreated, map[string]int64{"id": 8}),
+		"POST /api/v4/projects/runik%2Fuser-project/repository/commits":         reply(http.StatusCreated, map[string]string{"id": "c1"}),
+	}
+	provider, api := newTestGitLab(t, routes)
+	if err := provider.CreateFromTemplate(testCtx, template, "release", repo, "My project"); err != nil {
+		t.Fatal(err)
+	}
+	project := api.body("POST /api/v4/projects")
+	if project["path"] != "user-project" || project["namespace_id"] != float64(7) || project["default_branch"] != "release" || project["visibility"] != "private" {
+		t.Fatalf("project body = %v", project)
+	}
+	commit := api.body("POST /api/v4/projects/runik%2Fuser-project/repository/commits")
+	actions, _ := commit["actions"].([]interface{})
+	if commit["branch"] != "release" || len(actions) != 1 {
+		t.Fatalf("commit body = %v", commit)
+	}
+	action, _ := actions[0].(map[string]interface{})
+	if action["action"] != "create" || action["file_path"] != "main.go" || action["content"] != base64.StdEncoding.EncodeToString([]byte("package main\n")) {
+		t.Fatalf("action = %v", action)
+	}
+
+	routes["POST /api/v4/projects"] = reply(http.StatusBadRequest, `{"message":{"name":["has already been taken"]}}`)
+	provider, _ = newTestGitLab(t, routes)
+	if err := provider.CreateFromTemplate(testCtx, template, "release", repo, ""); !errors.Is(err, ErrExists) {
+		t.Fatalf("existing project returned %v, want ErrExists", err)
+	}
+
+	// A failed first commit leaves no empty project behind
+	routes["POST /api/v4/projects"] = reply(http.StatusCreated, map[string]int64{"id": 8})
+	routes["POST /api/v4/projects/runik%2Fuser-project/repository/commits"] = reply(http.StatusBadRequest, `{"message":"invalid"}`)
+	routes["DELETE /api/v4/projects/runik%2Fuser-project"] = reply(http.StatusAccepted, nil)
+	provider, api = newTestGitLab(t, routes)
+	if err := provider.CreateFromTemplate(testCtx, template, "release", repo, ""); err == nil {
+		t.Fatal("failed commit succeeded")
+	}
+	if !api.called("DELETE /api/v4/projects/runik%2Fuser-project") {
+		t.Fatal("project was not deleted after the commit failed")
+	}
+}
+
+func TestGitLabCommit(t *testing.T) {
+	prefix := "/api/v4/projects/runik%2Fproject"
+	provider, api := newTestGitLab(t, map[string]fakeRoute{
+		"GET " + prefix + "/repository/tree":         gitlabTree(t, "dev", map[string]string{"README.md": "r1", "old.go": "o1"}),
+		"POST " + prefix + "/repository/commits":     reply(http.StatusCreated, map[string]string{"id": "c2"}),
+		"GET " + prefix + "/repository/branches/dev": gitlabBranch("c1"),
+	})
+	repo := Repo{Owner: "runik", Name: "project"}
+	sha, err := provider.Commit(testCtx, repo, Commit{
+		Branch:      "dev",
+		Message:     "Update",
+		AuthorName:  "Tester",
+		AuthorEmail: "tester@example.com",
+		Changes: []FileChange{
+			{Path: "README.md", Content: []byte("hello")},
+			{Path: "new.go", Content: []byte("package main")},
+			{Path: "old.go", Delete: true},
+			{Path: "missing.go", Delete: true},
+		},
+	})
+	if err != nil || sha != "c2" {
+		t.Fatalf("Commit = %s, %v", sha, err)
+	}
+	body := api.body("POST " + prefix + "/repository/commits")
+	if body["branch"] != "dev" || body["author_name"] != "Tester" || body["author_email"] != "tester@example.com" {
+		t.Fatalf("commit body = %v", body)
+	}
+	var actions []string
+	for _, action := range body["actions"].([]interface{}) {
+		action := action.(map[string]interface{})
+		actions = append(actions, action["action"].(string)+" "+action["file_path"].(string))
+	}
+	if strings.Join(actions, ",") != "update README.md,create new.go,delete old.go" {
+		t.Fatalf("actions = %v", actions)
+	}
+
+	// Only deleting files that are gone commits nothing
+	sha, err = provider.Commit(testCtx, repo, Commit{Branch: "dev", Changes: []FileChange{{Path: "missing.go", Delete: true}}})
+	if err != nil || sha != "c1" {
+		t.Fatalf("empty commit = %s, %v, want the branch head", sha, err)
+	}
+}
+
+func TestGitLabMerge(t *testing.T) {
+	prefix := "/api/v4/projects/runik%2Fproject"
+	repo := Repo{Owner: "runik", Name: "project"}
+	merge := Merge{Base: "prod", Head: "dev", Message: "Promote"}
+	routes := func(mergeBase string, request map[string]interface{}) map[string]fakeRoute {
+		return map[string]fakeRoute{
+			"GET " + prefix + "/repository/branches/prod": gitlabBranch("base"),
+			"GET " + prefix + "/repository/branches/dev":  gitlabBranch("head"),
+			"GET " + prefix + "/repository/merge_base":    reply(http.StatusOK, map[string]string{"id": mergeBase}),
+			"POST " + prefix + "/merge_requests":          reply(http.StatusCreated, map[string]interface{}{"iid": 3}),
+			"GET " + prefix + "/merge_requests/3":         reply(http.StatusOK, request),
+			"PUT " + prefix + "/merge_requests/3":         reply(http.StatusOK, nil),
+			"PUT " + prefix + "/merge_requests/3/merge":   reply(http.StatusOK, request),
+		}
+	}
+
+	provider, api := newTestGitLab(t, routes("head", nil))
+	if _, err := provider.Merge(testCtx, repo, merge); !errors.Is(err, ErrUpToDate) {
+		t.Fatalf("up to date returned %v, want ErrUpToDate", err)
+	}
+	if api.called("POST " + prefix + "/merge_requests") {
+		t.Fatal("opened a merge request without changes")
+	}
+
+	provider, api = newTestGitLab(t, routes("base", map[string]interface{}{"iid": 3, "detailed_merge_status": "mergeable"}))
+	result, err := provider.Merge(testCtx, repo, merge)
+	if err != nil || !result.FastForward || result.SHA != "head" {
+		t.Fatalf("fast-forward = %+v, %v", result, err)
+	}
+	if body := api.body("PUT " + prefix + "/merge_requests/3/merge"); body["sha"] != "head" {
+		t.Fatalf("merge body = %v, want the head SHA", body)
+	}
+
+	provider, _ = newTestGitLab(t, routes("original", map[string]interface{}{"iid": 3, "detailed_merge_status": "mergeable", "merge_commit_sha": "merged"}))
+	result, err = provider.Merge(testCtx, repo, merge)
+	if err != nil || result.FastForward || result.SHA != "merged" || result.HeadSHA != "head" {
+		t.Fatalf("merge = %+v, %v", result, err)
+	}
+
+	// README.md changes the same line on both sides, main.go different ones
+	// and notes.md is removed from prod while dev changes it
+	conflict := routes("original", map[string]interface{}{"iid": 3, "detailed_merge_status": "broken_status", "has_conflicts": true})
+	conflict["GET "+prefix+"/repository/files/README.md/raw"] = gitlabRaw(t, map[string]string{"original": "title\n", "base": "prod title\n", "head": "dev title\n"})
+	conflict["GET "+prefix+"/repository/files/main.go/raw"] = gitlabRaw(t, map[string]string{"original": "a\nb\nc\n", "base": "A\nb\nc\n", "head": "a\nb\nC\n"})
+	conflict["GET "+prefix+"/repository/tree"] = func(request *http.Request, body map[string]interface{}) (int, interface{}) {
+		blobs := map[string]map[string]string{
+			"original": {"README.md": "r0", "main.go": "m0", "notes.md": "n0"},
+			"base":     {"README.md": "r1", "main.go": "m1"},
+			"head":     {"README.md": "r2", "main.go": "m2", "notes.md": "n2"},
+		}[request.URL.Query().Get("ref")]
+		var tree []map[string]string
+		for file, sha := range blobs {
+			tree = append(tree, map[string]string{"id": sha, "type": "blob", "path": file})
+		}
+		return http.StatusOK, tree
+	}
+	provider, api = newTestGitLab(t, conflict)
+	result, err = provider.Merge(testCtx, repo, merge)
+	if err != nil || result.SHA != "" || strings.Join(result.Conflicts, ",") != "README.md,notes.md" {
+		t.Fatalf("conflict = %+v, %v", result, err)
+	}
+	if body := api.body("PUT " + prefix + "/merge_requests/3"); body["state_event"] != "close" {
+		t.Fatalf("merge request was not closed: %v", body)
+	}
+	if api.called("PUT " + prefix + "/merge_requests/3/merge") {
+		t.Fatal("merged a conflicting merge request")
+	}
+
+	blocked := routes("original", map[string]interface{}{"iid": 3, "detailed_merge_status": "not_approved"})
+	blocked["PUT "+prefix+"/merge_requests/3/merge"] = reply(http.StatusMethodNotAllowed, `{"message":"405 Method Not Allowed"}`)
+	provider, api = newTestGitLab(t, blocked)
+	if result, err = provider.Merge(testCtx, repo, merge); !errors.Is(err, ErrRefused) || len(result.Conflicts) != 0 {
+		t.Fatalf("blocked merge request = %+v, %v, want ErrRefused", result, err)
+	}
+	if body := api.body("PUT " + prefix + "/merge_requests/3"); body["state_event"] != "close" {
+		t.Fatalf("blocked merge request was not closed: %v", body)
+	}
+}
+
+func TestGitLabErrors(t *testing.T) {
+	prefix := "/api/v4/projects/runik%2Fproject"
+	repo := Repo{Owner: "runik", Name: "project"}
+	provider, _ := newTestGitLab(t, map[string]fakeRoute{
+		"GET " + prefix + "/repository/branches/prod": gitlabBranch("base"),
+		"GET " + prefix + "/repository/branches/dev":  gitlabBranch("head"),
+		"GET " + prefix + "/repository/merge_base":    reply(http.StatusOK, map[string]string{"id": "base"}),
+		"POST " + prefix + "/merge_requests":          reply(http.StatusConflict, `{"message":["Another open merge request already exists for this source branch"]}`),
+	})
+	if _, err := provider.OpenPullRequest(testCtx, repo, "prod", "dev", "Promote", ""); !errors.Is(err, ErrExists) {
+		t.Fatalf("open merge request returned %v, want ErrExists", err)
+	}
+	if _, err := provider.BranchHead(testCtx, repo, "missing"); !IsNotFound(err) {
+		t.Fatalf("missing branch returned %v, want ErrNotFound", err)
+	}
+	if _, err := provider.ReadFile(testCtx, repo, "prod", "missing.go"); !IsNotFound(err) {
+		t.Fatalf("missing file returned %v, want ErrNotFound", err)
+	}
+	if _, err := provider.ReadTree(testCtx, Repo{Owner: "runik", Name: "missing"}, "prod"); !IsNotFound(err) {
+		t.Fatalf("missing project returned %v, want ErrNotFound", err)
+	}
+
+	provider, _ = newTestGitLab(t, map[string]fakeRoute{
+		"GET " + prefix + "/repository/branches/prod": gitlabBranch("base"),
+		"GET " + prefix + "/repository/branches/dev":  gitlabBranch("head"),
+		"GET " + prefix + "/repository/merge_base":    reply(http.StatusOK, map[string]string{"id": "head"}),
+	})
+	if _, err := provider.OpenPullRequest(testCtx, repo, "prod", "dev", "Promote", ""); !errors.Is(err, ErrUpToDate) {
+		t.Fatalf("empty merge request returned %v, want ErrUpToDate", err)
+	}
+}
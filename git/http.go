@@ -0,0 +1,88 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// restClient sends JSON requests to a hosting API. authorize adds the
+// credentials of the provider to every request.
+type restClient struct {
+	base      string
+	http      *http.Client
+	authorize func(request *http.Request)
+}
+
+// pollInterval spaces out the requests of providers waiting for the server
+// to finish creating a repository or checking a merge
+var pollInterval = 500 * time.Millisecond
+
+func newHttpClient(client *http.Client) *http.Client {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return client
+}
+
+// httpError is a response with an unexpected status
+type httpError struct {
+	Status int
+	Body   string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("git: responded %d: %s", e.Status, e.Body)
+}
+
+// do sends body as JSON and decodes the response into result when both are
+// not nil. 404 responses become ErrNotFound, other errors an *httpError.
+func (r *restClient) do(ctx context.Context, method string, path string, body interface{}, result interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := sonic.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, r.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set("Accept", "application/json")
+	r.authorize(request)
+
+	response, err := r.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return response, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return response, fmt.Errorf("%w: %s %s", ErrNotFound, method, path)
+	} else if response.StatusCode >= 400 {
+		return response, &httpError{Status: response.StatusCode, Body: string(data)}
+	}
+	if result != nil && len(data) > 0 {
+		if raw, ok := result.(*[]byte); ok {
+			*raw = data
+			return response, nil
+		}
+		if err := sonic.Unmarshal(data, result); err != nil {
+			return response, err
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,144 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeRoute answers a request to a fake API with a status and a body, which
+// is written as is when it is a string and as JSON otherwise
+type fakeRoute func(request *http.Request, body map[string]interface{}) (int, interface{})
+
+// fakeApi serves routes keyed by method and escaped path, anything else is a 404
+type fakeApi struct {
+	mutex  sync.Mutex
+	routes map[string]fakeRoute
+	calls  []string
+	bodies map[string]map[string]interface{}
+}
+
+func newFakeApi(t *testing.T, routes map[string]fakeRoute) (*fakeApi, *httptest.Server) {
+	t.Helper()
+	api := &fakeApi{routes: routes, bodies: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.EscapedPath()
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		api.mutex.Lock()
+		api.calls = append(api.calls, key)
+		api.bodies[key] = body
+		route, ok := api.routes[key]
+		api.mutex.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		status, result := route(r, body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		switch result := result.(type) {
+		case nil:
+		case string:
+			w.Write([]byte(result))
+		default:
+			json.NewEncoder(w).Encode(result)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func reply(status int, result interface{}) fakeRoute {
+	return func(*http.Request, map[string]interface{}) (int, interface{}) {
+		return status, result
+	}
+}
+
+// sequence answers with each route in turn and repeats the last one
+func sequence(routes ...fakeRoute) fakeRoute {
+	var mutex sync.Mutex
+	next := 0
+	return func(request *http.Request, body map[string]interface{}) (int, interface{}) {
+		mutex.Lock()
+		route := routes[next]
+		if next < len(routes)-1 {
+			next++
+		}
+		mutex.Unlock()
+		return route(request, body)
+	}
+}
+
+// called reports whether key was requested
+func (a *fakeApi) called(key string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, call := range a.calls {
+		if call == key {
+			return true
+		}
+	}
+	return false
+}
+
+// body returns the JSON body of the last request to key
+func (a *fakeApi) body(key string) map[string]interface{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.bodies[key]
+}
+
+func TestRestClient(t *testing.T) {
+	var authorization, contentType string
+	_, server := newFakeApi(t, map[string]fakeRoute{
+		"POST /json": func(request *http.Request, body map[string]interface{}) (int, interface{}) {
+			authorization, contentType = request.Header.Get("Authorization"), request.Header.Get("Content-Type")
+			return http.StatusCreated, map[string]interface{}{"echo": body["name"]}
+		},
+		"GET /raw":      reply(http.StatusOK, "plain text"),
+		"GET /conflict": reply(http.StatusConflict, `{"message":"conflict"}`),
+		"GET /empty":    reply(http.StatusNoContent, nil),
+	})
+	client := restClient{base: server.URL, http: newHttpClient(server.Client()), authorize: func(request *http.Request) {
+		request.Header.Set("Authorization", "Bearer secret")
+	}}
+
+	var result struct {
+		Echo string `json:"echo"`
+	}
+	response, err := client.do(testCtx, http.MethodPost, "/json", map[string]string{"name": "runik"}, &result)
+	if err != nil || response.StatusCode != http.StatusCreated || result.Echo != "runik" {
+		t.Fatalf("POST /json = %+v, %v", result, err)
+	}
+	if authorization != "Bearer secret" || contentType != "application/json" {
+		t.Fatalf("headers were %q and %q", authorization, contentType)
+	}
+
+	var raw []byte
+	if _, err := client.do(testCtx, http.MethodGet, "/raw", nil, &raw); err != nil || string(raw) != "plain text" {
+		t.Fatalf("GET /raw = %q, %v", raw, err)
+	}
+	if _, err := client.do(testCtx, http.MethodGet, "/empty", nil, &result); err != nil {
+		t.Fatalf("GET /empty = %v", err)
+	}
+
+	_, err = client.do(testCtx, http.MethodGet, "/missing", nil, nil)
+	if !IsNotFound(err) {
+		t.Fatalf("GET /missing = %v, want ErrNotFound", err)
+	}
+	_, err = client.do(testCtx, http.MethodGet, "/conflict", nil, nil)
+	var status *httpError
+	if !errors.As(err, &status) || status.Status != http.StatusConflict || status.Body != `{"message":"conflict"}` {
+		t.Fatalf("GET /conflict = %v, want an httpError with the body", err)
+	}
+	if IsNotFound(err) {
+		t.Fatal("a conflict counts as not found")
+	}
+}
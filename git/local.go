@@ -0,0 +1,635 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// Owner and repository names become directory names, so they are restricted
+var localNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+var shaPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// localProvider keeps bare repositories at <root>/<owner>/<name>.git and
+// works on them with go-git, so neither a git server nor the git command is needed.
+// Templates are ordinary repositories under the same root.
+type localProvider struct {
+	root  string
+	owner string
+}
+
+// localFile is a file in a flattened tree
+type localFile struct {
+	Mode filemode.FileMode
+	Hash plumbing.Hash
+}
+
+// NewLocal keeps repositories under root. Without an owner they are created under "local".
+func NewLocal(root string, owner string) (GitProvider, error) {
+	if owner == "" {
+		owner = "local"
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &localProvider{root: root, owner: owner}, nil
+}
+
+func (l *localProvider) Owner() string {
+	return l.owner
+}
+
+func (l *localProvider) dir(repo Repo) (string, error) {
+	if !localNamePattern.MatchString(repo.Owner) || !localNamePattern.MatchString(repo.Name) {
+		return "", ErrInvalid
+	}
+	return filepath.Join(l.root, repo.Owner, repo.Name+".git"), nil
+}
+
+// open opens a repository that exists
+func (l *localProvider) open(repo Repo) (*gogit.Repository, string, error) {
+	dir, err := l.dir(repo)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("%w: repository %s/%s", ErrNotFound, repo.Owner, repo.Name)
+	} else if err != nil {
+		return nil, "", err
+	}
+	repository, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return repository, dir, nil
+}
+
+func validBranch(branch string) bool {
+	return branch != "" && !strings.HasPrefix(branch, "-") && plumbing.NewBranchReferenceName(branch).Validate() == nil
+}
+
+// branchHead returns the commit branch points at, or ErrNotFound
+func branchHead(repository *gogit.Repository, branch string) (*object.Commit, error) {
+	if !validBranch(branch) {
+		return nil, ErrInvalid
+	}
+	ref, err := repository.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, fmt.Errorf("%w: branch %s", ErrNotFound, branch)
+	} else if err != nil {
+		return nil, err
+	}
+	return commitObject(repository, ref.Hash())
+}
+
+// resolve returns the commit of a branch name or a full SHA
+func resolve(repository *gogit.Repository, ref string) (*object.Commit, error) {
+	if shaPattern.MatchString(ref) {
+		return commitObject(repository, plumbing.NewHash(ref))
+	}
+	return branchHead(repository, ref)
+}
+
+func commitObject(repository *gogit.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	commit, err := repository.CommitObject(hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: commit %s", ErrNotFound, hash)
+	}
+	return commit, err
+}
+
+// moveBranch points branch at hash only if it still points at old, a zero
+// old hash only creates the branch
+func moveBranch(repository *gogit.Repository, branch string, hash plumbing.Hash, old plumbing.Hash) error {
+	name := plumbing.NewBranchReferenceName(branch)
+	err := repository.Storer.CheckAndSetReference(plumbing.NewHashReference(name, hash), plumbing.NewHashReference(name, old))
+	if errors.Is(err, storage.ErrReferenceHasChanged) {
+		if old.IsZero() {
+			return fmt.Errorf("git: branch %s already exists", branch)
+		}
+		return fmt.Errorf("git: branch %s was changed concurrently", branch)
+	}
+	return err
+}
+
+// copyTree copies a tree and everything in it from one repository to another
+func copyTree(from *gogit.Repository, to *gogit.Repository, hash plumbing.Hash) error {
+	tree, err := from.TreeObject(hash)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries {
+		switch {
+		case entry.Mode == filemode.Dir:
+			err = copyTree(from, to, entry.Hash)
+		case entry.Mode.IsFile():
+			err = copyObject(from, to, entry.Hash)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return copyObject(from, to, hash)
+}
+
+func copyObject(from *gogit.Repository, to *gogit.Repository, hash plumbing.Hash) error {
+	encoded, err := from.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return err
+	}
+	_, err = to.Storer.SetEncodedObject(encoded)
+	return err
+}
+
+func (l *localProvider) CreateFromTemplate(ctx context.Context, template Repo, branch string, repo Repo, description string) error {
+	source, _, err := l.open(template)
+	if err != nil {
+		return err
+	}
+	target, err := l.dir(repo)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(target); err == nil {
+		return ErrExists
+	}
+	commit, err := branchHead(source, branch)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	// Like a Gitea template the new repository starts with one commit holding
+	// the files of the template, without its history
+	created := func() error {
+		repository, err := gogit.PlainInitWithOptions(target, &gogit.PlainInitOptions{Bare: true, InitOptions: gogit.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName(branch)}})
+		if err != nil {
+			return err
+		}
+		if err := copyTree(source, repository, commit.TreeHash); err != nil {
+			return err
+		}
+		sha, err := storeCommit(repository, commit.TreeHash, nil, "Create from "+template.Owner+"/"+template.Name, l.owner, "")
+		if err != nil {
+			return err
+		}
+		if err := moveBranch(repository, branch, sha, plumbing.ZeroHash); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(target, "description"), []byte(description+"\n"), 0o644)
+	}
+	if err := created(); err != nil {
+		os.RemoveAll(target)
+		return err
+	}
+	return nil
+}
+
+func (l *localProvider) CreateBranch(ctx context.Context, repo Repo, branch string, from string) error {
+	repository, _, err := l.open(repo)
+	if err != nil {
+		return err
+	}
+	if !validBranch(branch) {
+		return ErrInvalid
+	}
+	commit, err := branchHead(repository, from)
+	if err != nil {
+		return err
+	}
+	return moveBranch(repository, branch, commit.Hash, plumbing.ZeroHash)
+}
+
+func (l *localProvider) BranchHead(ctx context.Context, repo Repo, branch string) (string, error) {
+	repository, _, err := l.open(repo)
+	if err != nil {
+		return "", err
+	}
+	commit, err := branchHead(repository, branch)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+func (l *localProvider) ReadTree(ctx context.Context, repo Repo, ref string) ([]TreeEntry, error) {
+	repository, _, err := l.open(repo)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := resolve(repository, ref)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TreeEntry
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case entry.Mode == filemode.Dir:
+			entries = append(entries, TreeEntry{Path: name, Type: Tree, SHA: entry.Hash.String()})
+		case entry.Mode.IsFile():
+			size, err := repository.Storer.EncodedObjectSize(entry.Hash)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, TreeEntry{Path: name, Type: Blob, SHA: entry.Hash.String(), Size: size})
+		}
+	}
+	return entries, nil
+}
+
+func (l *localProvider) ReadFile(ctx context.Context, repo Repo, ref string, file string) ([]byte, error) {
+	repository, _, err := l.open(repo)
+	if err != nil {
+		return nil, err
+	}
+	if !ValidPath(file) {
+		return nil, ErrInvalid
+	}
+	commit, err := resolve(repository, ref)
+	if err != nil {
+		return nil, err
+	}
+	found, err := commit.File(file)
+	if errors.Is(err, object.ErrFileNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, file)
+	} else if err != nil {
+		return nil, err
+	}
+	return readBlob(&found.Blob)
+}
+
+func readBlob(blob *object.Blob) ([]byte, error) {
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// files flattens a tree to its files by path
+func files(tree *object.Tree) (map[string]localFile, error) {
+	flat := map[string]localFile{}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return flat, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if entry.Mode != filemode.Dir {
+			flat[name] = localFile{Mode: entry.Mode, Hash: entry.Hash}
+		}
+	}
+}
+
+// storeTree writes the nested trees for a flat set of files and returns the
+// hash of the root. It fails with ErrInvalid when a path is a file and a directory.
+func storeTree(repository *gogit.Repository, flat map[string]localFile) (plumbing.Hash, error) {
+	entries := []object.TreeEntry{}
+	directories := map[string]map[string]localFile{}
+	for file, info := range flat {
+		name, rest, nested := strings.Cut(file, "/")
+		if !nested {
+			entries = append(entries, object.TreeEntry{Name: name, Mode: info.Mode, Hash: info.Hash})
+			continue
+		}
+		if directories[name] == nil {
+			directories[name] = map[string]localFile{}
+		}
+		directories[name][rest] = info
+	}
+	for name, contents := range directories {
+		if _, ok := flat[name]; ok {
+			return plumbing.ZeroHash, fmt.Errorf("%w: %s is a file and a directory", ErrInvalid, name)
+		}
+		hash, err := storeTree(repository, contents)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash})
+	}
+	sort.Sort(object.TreeEntrySorter(entries))
+	return storeObject(repository, &object.Tree{Entries: entries})
+}
+
+type encodable interface {
+	Encode(plumbing.EncodedObject) error
+}
+
+func storeObject(repository *gogit.Repository, value encodable) (plumbing.Hash, error) {
+	encoded := repository.Storer.NewEncodedObject()
+	if err := value.Encode(encoded); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repository.Storer.SetEncodedObject(encoded)
+}
+
+func storeBlob(repository *gogit.Repository, content []byte) (plumbing.Hash, error) {
+	encoded := repository.Storer.NewEncodedObject()
+	encoded.SetType(plumbing.BlobObject)
+	writer, err := encoded.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := writer.Write(content); err != nil {
+		writer.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err := writer.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repository.Storer.SetEncodedObject(encoded)
+}
+
+func storeCommit(repository *gogit.Repository, tree plumbing.Hash, parents []plumbing.Hash, message string, name string, email string) (plumbing.Hash, error) {
+	author := signature(name, email)
+	return storeObject(repository, &object.Commit{
+		Author:       author,
+		Committer:    author,
+		Message:      message,
+		TreeHash:     tree,
+		ParentHashes: parents,
+	})
+}
+
+// Commit builds the new tree from the parent's files without a work tree
+// and moves the branch only if nobody else did.
+func (l *localProvider) Commit(ctx context.Context, repo Repo, commit Commit) (string, error) {
+	repository, _, err := l.open(repo)
+	if err != nil {
+		return "", err
+	}
+	parent, err := branchHead(repository, commit.Branch)
+	if err != nil {
+		return "", err
+	}
+	tree, err := parent.Tree()
+	if err != nil {
+		return "", err
+	}
+	flat, err := files(tree)
+	if err != nil {
+		return "", err
+	}
+	for _, change := range commit.Changes {
+		if !ValidPath(change.Path) {
+			return "", ErrInvalid
+		}
+		if change.Delete {
+			delete(flat, change.Path)
+			continue
+		}
+		hash, err := storeBlob(repository, change.Content)
+		if err != nil {
+			return "", err
+		}
+		flat[change.Path] = localFile{Mode: filemode.Regular, Hash: hash}
+	}
+	treeHash, err := storeTree(repository, flat)
+	if err != nil {
+		return "", err
+	}
+	if treeHash == parent.TreeHash {
+		return parent.Hash.String(), nil
+	}
+
+	sha, err := storeCommit(repository, treeHash, []plumbing.Hash{parent.Hash}, commit.Message, commit.AuthorName, commit.AuthorEmail)
+	if err != nil {
+		return "", err
+	}
+	if err := moveBranch(repository, commit.Branch, sha, parent.Hash); err != nil {
+		return "", err
+	}
+	return sha.String(), nil
+}
+
+// SetDescription writes the description file git and gitweb read
+func (l *localProvider) SetDescription(ctx context.Context, repo Repo, description string) error {
+	_, dir, err := l.open(repo)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "description"), []byte(description+"\n"), 0o644)
+}
+
+// Merge merges the trees of both branches three ways against their merge base,
+// merging files both changed line by line, and moves the base branch only if
+// nobody else did
+func (l *localProvider) Merge(ctx context.Context, repo Repo, merge Merge) (MergeResult, error) {
+	repository, _, err := l.open(repo)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	base, err := branchHead(repository, merge.Base)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	head, err := branchHead(repository, merge.Head)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	result := MergeResult{HeadSHA: head.Hash.String()}
+	if merged, err := head.IsAncestor(base); err != nil {
+		return result, err
+	} else if merged || head.Hash == base.Hash {
+		return result, ErrUpToDate
+	}
+
+	if forward, err := base.IsAncestor(head); err != nil {
+		return result, err
+	} else if forward {
+		if err := moveBranch(repository, merge.Base, head.Hash, base.Hash); err != nil {
+			return result, err
+		}
+		result.SHA, result.FastForward = head.Hash.String(), true
+		return result, nil
+	}
+
+	// Unrelated histories merge as if both sides added all of their files
+	original := map[string]localFile{}
+	bases, err := base.MergeBase(head)
+	if err != nil {
+		return result, err
+	}
+	if len(bases) > 0 {
+		if original, err = commitFiles(bases[0]); err != nil {
+			return result, err
+		}
+	}
+	ours, err := commitFiles(base)
+	if err != nil {
+		return result, err
+	}
+	theirs, err := commitFiles(head)
+	if err != nil {
+		return result, err
+	}
+	merged, conflicts, err := mergeFiles(repository, original, ours, theirs)
+	if err != nil {
+		return result, err
+	}
+	if len(conflicts) > 0 {
+		result.Conflicts = conflicts
+		return result, nil
+	}
+
+	tree, err := storeTree(repository, merged)
+	if err != nil {
+		return result, err
+	}
+	sha, err := storeCommit(repository, tree, []plumbing.Hash{base.Hash, head.Hash}, merge.Message, merge.AuthorName, merge.AuthorEmail)
+	if err != nil {
+		return result, err
+	}
+	if err := moveBranch(repository, merge.Base, sha, base.Hash); err != nil {
+		return result, err
+	}
+	result.SHA = sha.String()
+	return result, nil
+}
+
+func commitFiles(commit *object.Commit) (map[string]localFile, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return files(tree)
+}
+
+// mergeFiles merges flattened trees and returns the merged files or the paths
+// that conflict
+func mergeFiles(repository *gogit.Repository, original map[string]localFile, ours map[string]localFile, theirs map[string]localFile) (map[string]localFile, []string, error) {
+	merged := map[string]localFile{}
+	conflicts := []string{}
+	seen := map[string]bool{}
+	for _, side := range []map[string]localFile{original, ours, theirs} {
+		for file := range side {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			before, hadBefore := original[file]
+			mine, haveMine := ours[file]
+			yours, haveYours := theirs[file]
+			switch {
+			case haveMine == haveYours && mine == yours:
+				if haveMine {
+					merged[file] = mine
+				}
+			case hadBefore == haveMine && before == mine:
+				if haveYours {
+					merged[file] = yours
+				}
+			case hadBefore == haveYours && before == yours:
+				if haveMine {
+					merged[file] = mine
+				}
+			case hadBefore && haveMine && haveYours && mine.Mode == yours.Mode && mine.Mode.IsFile():
+				hash, ok, err := mergeBlobs(repository, before.Hash, mine.Hash, yours.Hash)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !ok {
+					conflicts = append(conflicts, file)
+					continue
+				}
+				merged[file] = localFile{Mode: mine.Mode, Hash: hash}
+			default:
+				conflicts = append(conflicts, file)
+			}
+		}
+	}
+
+	// A file on one side can block a directory of the same name on the other
+	for file := range merged {
+		for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+			if _, ok := merged[dir]; ok {
+				conflicts = append(conflicts, dir)
+			}
+		}
+	}
+	sort.Strings(conflicts)
+	unique := conflicts[:0]
+	for i, file := range conflicts {
+		if i == 0 || conflicts[i-1] != file {
+			unique = append(unique, file)
+		}
+	}
+	return merged, unique, nil
+}
+
+func mergeBlobs(repository *gogit.Repository, base plumbing.Hash, ours plumbing.Hash, theirs plumbing.Hash) (plumbing.Hash, bool, error) {
+	contents := make([][]byte, 3)
+	for i, hash := range []plumbing.Hash{base, ours, theirs} {
+		blob, err := repository.BlobObject(hash)
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+		if contents[i], err = readBlob(blob); err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+	}
+	merged, ok := mergeText(contents[0], contents[1], contents[2])
+	if !ok {
+		return plumbing.ZeroHash, false, nil
+	}
+	hash, err := storeBlob(repository, merged)
+	return hash, err == nil, err
+}
+
+// OpenPullRequest is not supported, local repositories have no pull requests
+func (l *localProvider) OpenPullRequest(ctx context.Context, repo Repo, base string, head string, title string, body string) (PullRequest, error) {
+	return PullRequest{}, ErrUnsupported
+}
+
+func (l *localProvider) DeleteRepo(ctx context.Context, repo Repo) error {
+	_, dir, err := l.open(repo)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// signature is the author and committer of commits made here
+func signature(name string, email string) object.Signature {
+	if name == "" {
+		name = "runik"
+	}
+	if email == "" {
+		email = name + "@localhost"
+	}
+	return object.Signature{Name: name, Email: email, When: time.Now()}
+}
@@ -0,0 +1,312 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var (
+	testCtx      = context.Background()
+	testTemplate = Repo{Owner: "templates", Name: "starter"}
+	testRepo     = Repo{Owner: "local", Name: "project"}
+)
+
+// newTestLocal returns a provider with a template holding files on main and
+// a repository created from it with a dev branch
+func newTestLocal(t *testing.T, files map[string]string) GitProvider {
+	t.Helper()
+	root := t.TempDir()
+	provider, err := NewLocal(root, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	main := plumbing.NewBranchReferenceName("main")
+	template, err := gogit.PlainInitWithOptions(filepath.Join(root, "templates", "starter.git"), &gogit.PlainInitOptions{Bare: true, InitOptions: gogit.InitOptions{DefaultBranch: main}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat := map[string]localFile{}
+	for file, content := range files {
+		hash, err := storeBlob(template, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		flat[file] = localFile{Mode: 0o100644, Hash: hash}
+	}
+	tree, err := storeTree(template, flat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := storeCommit(template, tree, nil, "Initial commit", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := moveBranch(template, "main", commit, plumbing.ZeroHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.CreateFromTemplate(testCtx, testTemplate, "main", testRepo, "test project"); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.CreateBranch(testCtx, testRepo, "dev", "main"); err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func commitTo(t *testing.T, provider GitProvider, branch string, changes ...FileChange) string {
+	t.Helper()
+	sha, err := provider.Commit(testCtx, testRepo, Commit{Branch: branch, Message: "change", AuthorName: "Tester", AuthorEmail: "tester@example.com", Changes: changes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func readFile(t *testing.T, provider GitProvider, ref string, file string) string {
+	t.Helper()
+	content, err := provider.ReadFile(testCtx, testRepo, ref, file)
+	if err != nil {
+		t.Fatalf("reading %s at %s: %v", file, ref, err)
+	}
+	return string(content)
+}
+
+func TestLocalCreateFromTemplate(t *testing.T) {
+	provider := newTestLocal(t, map[string]string{"README.md": "hello\n"})
+	if got := readFile(t, provider, "main", "README.md"); got != "hello\n" {
+		t.Fatalf("README.md = %q", got)
+	}
+	err := provider.CreateFromTemplate(testCtx, testTemplate, "main", testRepo, "again")
+	if !errors.Is(err, ErrExists) {
+		t.Fatalf("creating twice returned %v, want ErrExists", err)
+	}
+	err = provider.CreateFromTemplate(testCtx, Repo{Owner: "templates", Name: "missing"}, "main", Repo{Owner: "local", Name: "other"}, "")
+	if !IsNotFound(err) {
+		t.Fatalf("missing template returned %v, want ErrNotFound", err)
+	}
+	err = provider.CreateFromTemplate(testCtx, testTemplate, "missing", Repo{Owner: "local", Name: "other"}, "")
+	if !IsNotFound(err) {
+		t.Fatalf("missing branch returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalCommit(t *testing.T) {
+	provider := newTestLocal(t, map[string]string{"README.md": "hello\n", "src/old.go": "package old\n"})
+	before, err := provider.BranchHead(testCtx, testRepo, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sha := commitTo(t, provider, "dev",
+		FileChange{Path: "README.md", Content: []byte("changed\n")},
+		FileChange{Path: "src/app/main.go", Content: []byte("package main\n")},
+		FileChange{Path: "src/old.go", Delete: true},
+	)
+	if sha == before {
+		t.Fatal("commit did not create a new commit")
+	}
+	if head, _ := provider.BranchHead(testCtx, testRepo, "dev"); head != sha {
+		t.Fatalf("dev points at %s, want %s", head, sha)
+	}
+	if got := readFile(t, provider, "dev", "README.md"); got != "changed\n" {
+		t.Fatalf("README.md = %q", got)
+	}
+	if got := readFile(t, provider, sha, "src/app/main.go"); got != "package main\n" {
+		t.Fatalf("src/app/main.go = %q", got)
+	}
+	if _, err := provider.ReadFile(testCtx, testRepo, "dev", "src/old.go"); !IsNotFound(err) {
+		t.Fatalf("deleted file returned %v, want ErrNotFound", err)
+	}
+	if got := readFile(t, provider, "main", "README.md"); got != "hello\n" {
+		t.Fatalf("main changed: README.md = %q", got)
+	}
+
+	// Writing what is already there commits nothing
+	if again := commitTo(t, provider, "dev", FileChange{Path: "README.md", Content: []byte("changed\n")}); again != sha {
+		t.Fatalf("unchanged commit returned %s, want %s", again, sha)
+	}
+
+	for _, bad := range []string{"../escape", "/absolute", "a//b", ""} {
+		_, err := provider.Commit(testCtx, testRepo, Commit{Branch: "dev", Message: "bad", Changes: []FileChange{{Path: bad, Content: []byte("x")}}})
+		if !errors.Is(err, ErrInvalid) {
+			t.Fatalf("path %q returned %v, want ErrInvalid", bad, err)
+		}
+	}
+	_, err = provider.Commit(testCtx, testRepo, Commit{Branch: "missing", Message: "x"})
+	if !IsNotFound(err) {
+		t.Fatalf("missing branch returned %v, want ErrNotFound", err)
+	}
+	// A file cannot become a directory while it still exists
+	_, err = provider.Commit(testCtx, testRepo, Commit{Branch: "dev", Message: "x", Changes: []FileChange{{Path: "README.md/nested", Content: []byte("x")}}})
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("file below a file returned %v, want ErrInvalid", err)
+	}
+}
+
+func TestLocalReadTree(t *testing.T) {
+	provider := newTestLocal(t, map[string]string{"README.md": "hello\n", "src/main.go": "package main\n", "src/lib/util.go": "package lib\n"})
+	entries, err := provider.ReadTree(testCtx, testRepo, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]TreeEntry{}
+	for _, entry := range entries {
+		got[entry.Path] = entry
+	}
+	if len(got) != 5 {
+		t.Fatalf("got %d entries, want 5: %v", len(got), entries)
+	}
+	for _, dir := range []string{"src", "src/lib"} {
+		if got[dir].Type != Tree {
+			t.Fatalf("%s has type %q, want tree", dir, got[dir].Type)
+		}
+	}
+	for file, content := range map[string]string{"README.md": "hello\n", "src/main.go": "package main\n", "src/lib/util.go": "package lib\n"} {
+		entry := got[file]
+		if entry.Type != Blob || entry.Size != int64(len(content)) || entry.SHA != BlobSHA([]byte(content)) {
+			t.Fatalf("%s = %+v", file, entry)
+		}
+	}
+
+	head, _ := provider.BranchHead(testCtx, testRepo, "main")
+	if bySha, err := provider.ReadTree(testCtx, testRepo, head); err != nil || len(bySha) != len(entries) {
+		t.Fatalf("reading by SHA returned %d entries, %v", len(bySha), err)
+	}
+	if _, err := provider.ReadTree(testCtx, testRepo, "missing"); !IsNotFound(err) {
+		t.Fatalf("missing branch returned %v, want ErrNotFound", err)
+	}
+	if _, err := provider.ReadTree(testCtx, Repo{Owner: "local", Name: "missing"}, "main"); !IsNotFound(err) {
+		t.Fatalf("missing repository returned %v, want ErrNotFound", err)
+	}
+	if _, err := provider.ReadFile(testCtx, testRepo, "main", "src"); !IsNotFound(err) {
+		t.Fatalf("reading a directory returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalCreateBranch(t *testing.T) {
+	provider := newTestLocal(t, map[string]string{"README.md": "hello\n"})
+	main, err := provider.BranchHead(testCtx, testRepo, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.CreateBranch(testCtx, testRepo, "feature/one", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if head, _ := provider.BranchHead(testCtx, testRepo, "feature/one"); head != main {
+		t.Fatalf("feature/one points at %s, want %s", head, main)
+	}
+	if err := provider.CreateBranch(testCtx, testRepo, "dev", "main"); err == nil {
+		t.Fatal("creating an existing branch succeeded")
+	}
+	for _, bad := range []string{"", "-flag", "two..dots", "with space", "ends.lock."} {
+		if err := provider.CreateBranch(testCtx, testRepo, bad, "main"); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("branch %q returned %v, want ErrInvalid", bad, err)
+		}
+	}
+	if err := provider.CreateBranch(testCtx, testRepo, "other", "missing"); !IsNotFound(err) {
+		t.Fatalf("missing source returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalMerge(t *testing.T) {
+	files := map[string]string{
+		"README.md":   "hello\n",
+		"src/main.go": "package main\n\nfunc a() {}\n\nfunc b() {}\n\nfunc c() {}\n",
+	}
+	merge := Merge{Base: "main", Head: "dev", Message: "Promote", AuthorName: "Tester", AuthorEmail: "tester@example.com"}
+
+	t.Run("up to date", func(t *testing.T) {
+		provider := newTestLocal(t, files)
+		if _, err := provider.Merge(testCtx, testRepo, merge); !errors.Is(err, ErrUpToDate) {
+			t.Fatalf("got %v, want ErrUpToDate", err)
+		}
+		commitTo(t, provider, "main", FileChange{Path: "README.md", Content: []byte("main only\n")})
+		if _, err := provider.Merge(testCtx, testRepo, merge); !errors.Is(err, ErrUpToDate) {
+			t.Fatalf("base ahead got %v, want ErrUpToDate", err)
+		}
+	})
+
+	t.Run("fast-forward", func(t *testing.T) {
+		provider := newTestLocal(t, files)
+		dev := commitTo(t, provider, "dev", FileChange{Path: "README.md", Content: []byte("from dev\n")})
+		result, err := provider.Merge(testCtx, testRepo, merge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.FastForward || result.SHA != dev || result.HeadSHA != dev || len(result.Conflicts) != 0 {
+			t.Fatalf("result = %+v, want a fast-forward to %s", result, dev)
+		}
+		if head, _ := provider.BranchHead(testCtx, testRepo, "main"); head != dev {
+			t.Fatalf("main points at %s, want %s", head, dev)
+		}
+	})
+
+	t.Run("clean merge", func(t *testing.T) {
+		provider := newTestLocal(t, files)
+		commitTo(t, provider, "main",
+			FileChange{Path: "src/main.go", Content: []byte("package main\n\nfunc a() { println(\"main\") }\n\nfunc b() {}\n\nfunc c() {}\n")},
+			FileChange{Path: "main.txt", Content: []byte("main\n")},
+		)
+		dev := commitTo(t, provider, "dev",
+			FileChange{Path: "src/main.go", Content: []byte("package main\n\nfunc a() {}\n\nfunc b() {}\n\nfunc c() { println(\"dev\") }\n")},
+			FileChange{Path: "README.md", Delete: true},
+		)
+		result, err := provider.Merge(testCtx, testRepo, merge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.FastForward || result.SHA == "" || result.HeadSHA != dev || len(result.Conflicts) != 0 {
+			t.Fatalf("result = %+v, want a merge commit", result)
+		}
+		if head, _ := provider.BranchHead(testCtx, testRepo, "main"); head != result.SHA {
+			t.Fatalf("main points at %s, want %s", head, result.SHA)
+		}
+		want := "package main\n\nfunc a() { println(\"main\") }\n\nfunc b() {}\n\nfunc c() { println(\"dev\") }\n"
+		if got := readFile(t, provider, "main", "src/main.go"); got != want {
+			t.Fatalf("merged src/main.go = %q, want %q", got, want)
+		}
+		if got := readFile(t, provider, "main", "main.txt"); got != "main\n" {
+			t.Fatalf("main.txt = %q", got)
+		}
+		if _, err := provider.ReadFile(testCtx, testRepo, "main", "README.md"); !IsNotFound(err) {
+			t.Fatalf("README.md deleted on dev returned %v, want ErrNotFound", err)
+		}
+		if _, err := provider.Merge(testCtx, testRepo, merge); !errors.Is(err, ErrUpToDate) {
+			t.Fatalf("merging again got %v, want ErrUpToDate", err)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		provider := newTestLocal(t, files)
+		main := commitTo(t, provider, "main",
+			FileChange{Path: "src/main.go", Content: []byte("package main\n\nfunc a() { println(\"main\") }\n\nfunc b() {}\n\nfunc c() {}\n")},
+			FileChange{Path: "README.md", Content: []byte("main\n")},
+		)
+		commitTo(t, provider, "dev",
+			FileChange{Path: "src/main.go", Content: []byte("package main\n\nfunc a() { println(\"dev\") }\n\nfunc b() {}\n\nfunc c() {}\n")},
+			FileChange{Path: "README.md", Delete: true},
+			FileChange{Path: "other.txt", Content: []byte("no conflict\n")},
+		)
+		result, err := provider.Merge(testCtx, testRepo, merge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(result.Conflicts, ",") != "README.md,src/main.go" {
+			t.Fatalf("conflicts = %v, want README.md and src/main.go", result.Conflicts)
+		}
+		if result.SHA != "" {
+			t.Fatalf("conflicting merge returned SHA %s", result.SHA)
+		}
+		if head, _ := provider.BranchHead(testCtx, testRepo, "main"); head != main {
+			t.Fatalf("main moved to %s after a conflict", head)
+		}
+	})
+}
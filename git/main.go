@@ -1,11 +1,273 @@
 package git
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
 	"api/structs"
+)
+
+var (
+	ErrNotFound        = errors.New("git: not found")
+	ErrExists          = errors.New("git: repository already exists")
+	ErrInvalid         = errors.New("git: invalid repository, ref or path")
+	ErrUnknownProvider = errors.New("git: provider is not configured")
+	ErrUpToDate        = errors.New("git: nothing to merge")
+	ErrUnsupported     = errors.New("git: not supported by the provider")
+	ErrNotDefault      = errors.New("git: the provider only copies the default branch of a template")
+	ErrRefused         = errors.New("git: the provider refused the merge, not for conflicts")
 
-	"code.gitea.io/sdk/gitea"
+	providers = map[string]GitProvider{}
+	// Default is the name of the provider new projects are created on
+	Default string
 )
 
-func Connect(env *structs.Environment) (*gitea.Client, error) {
-	return gitea.NewClient(env.GitUrl, gitea.SetToken(env.GitToken), gitea.SetGiteaVersion("1.19.9"))
+// Repo identifies a repository on a provider
+type Repo struct {
+	Owner string
+	Name  string
+}
+
+type EntryType string
+
+const (
+	Blob EntryType = "blob"
+	Tree EntryType = "tree"
+)
+
+// TreeEntry is a file or directory in a recursive tree listing
+type TreeEntry struct {
+	Path string
+	Type EntryType
+	// SHA is the git object ID, for blobs see BlobSHA
+	SHA  string
+	Size int64
+}
+
+// FileChange writes Content to Path, or removes Path when Delete is set
+type FileChange struct {
+	Path    string
+	Content []byte
+	Delete  bool
+}
+
+// Commit is a set of changes applied to Branch as a single commit
+type Commit struct {
+	Branch      string
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	Changes     []FileChange
+}
+
+// Merge brings the commits of Head into Base, both are branch names
+type Merge struct {
+	Base    string
+	Head    string
+	Message string
+	// Only the local provider signs merge commits with the author, the hosted
+	// ones merge through a pull request as the account of their token
+	AuthorName  string
+	AuthorEmail string
+}
+
+// MergeResult is the outcome of a merge. When Conflicts is set nothing was changed.
+type MergeResult struct {
+	// SHA Base points at afterwards
+	SHA string
+	// HeadSHA is the commit of Head that was merged
+	HeadSHA string
+	// FastForward is set when Base was moved to Head without a merge commit
+	FastForward bool
+	// Conflicts lists the files changed on both branches that do not merge line by line
+	Conflicts []string
+}
+
+// PullRequest is a request to merge one branch into another on the provider
+type PullRequest struct {
+	Number int64
+	URL    string
+}
+
+// GitProvider hosts project repositories. Missing repositories, branches and
+// files are reported as ErrNotFound.
+type GitProvider interface {
+	// Owner is the account new repositories are created under
+	Owner() string
+	// CreateFromTemplate creates repo with the files of branch of template as
+	// its first commit, on a default branch of the same name. Providers that
+	// only copy the default branch return ErrNotDefault for any other branch.
+	CreateFromTemplate(ctx context.Context, template Repo, branch string, repo Repo, description string) error
+	CreateBranch(ctx context.Context, repo Repo, branch string, from string) error
+	// BranchHead returns the SHA of the commit branch points at
+	BranchHead(ctx context.Context, repo Repo, branch string) (string, error)
+	// ReadTree lists every file and directory at ref recursively
+	ReadTree(ctx context.Context, repo Repo, ref string) ([]TreeEntry, error)
+	ReadFile(ctx context.Context, repo Repo, ref string, path string) ([]byte, error)
+	// Commit applies all changes in one commit and returns its SHA
+	Commit(ctx context.Context, repo Repo, commit Commit) (string, error)
+	SetDescription(ctx context.Context, repo Repo, description string) error
+	// Merge fast-forwards Base when it has no commits of its own and merges
+	// otherwise. It returns ErrUpToDate when Base already has every commit of Head.
+	Merge(ctx context.Context, repo Repo, merge Merge) (MergeResult, error)
+	// OpenPullRequest asks to merge head into base. It returns ErrExists when
+	// one is already open and ErrUpToDate when there is nothing to merge.
+	OpenPullRequest(ctx context.Context, repo Repo, base string, head string, title string, body string) (PullRequest, error)
+	DeleteRepo(ctx context.Context, repo Repo) error
+}
+
+// Connect creates every provider listed in GIT_PROVIDERS plus GIT_PROVIDER,
+// which new projects use unless they pick another one
+func Connect(env *structs.Environment) error {
+	names := []string{env.GitProvider}
+	for _, name := range strings.Split(env.GitProviders, ",") {
+		if name = strings.TrimSpace(name); name != "" && name != env.GitProvider {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		var provider GitProvider
+		var err error
+		switch name {
+		case "gitea":
+			provider, err = NewGitea(env.GitUrl, env.GitToken, env.GitUsername, env.GitVersion, nil)
+		case "forgejo":
+			provider, err = NewForgejo(env.ForgejoUrl, env.ForgejoToken, env.ForgejoOwner, nil)
+		case "github":
+			provider, err = NewGitHub(env.GithubUrl, env.GithubToken, env.GithubOwner, nil)
+		case "gitlab":
+			provider, err = NewGitLab(env.GitlabUrl, env.GitlabToken, env.GitlabOwner, nil)
+		case "local":
+			provider, err = NewLocal(env.GitLocalPath, env.GitUsername)
+		default:
+			err = fmt.Errorf("unknown git provider %s", name)
+		}
+		if err != nil {
+			return err
+		}
+		providers[name] = provider
+	}
+	Default = env.GitProvider
+	return nil
+}
+
+// For returns the provider configured under name, or the default provider when name is empty
+func For(name string) (GitProvider, error) {
+	if name == "" {
+		name = Default
+	}
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+// IsNotFound reports whether err means the repository, branch or file does not exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// BlobSHA is the object ID git gives a file with content, so contents can be
+// compared with a TreeEntry without downloading the file
+func BlobSHA(content []byte) string {
+	hash := sha1.New()
+	hash.Write([]byte("blob " + strconv.Itoa(len(content)) + "\x00"))
+	hash.Write(content)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// conflictingFiles lists the files ours and theirs both changed since base
+// that do not merge line by line, like files added or removed on one side
+// and changed on the other. Providers that refuse a conflicting merge without
+// naming the files report these. Should every file merge here, the provider
+// merges differently and all files changed on both sides are listed.
+func conflictingFiles(ctx context.Context, provider GitProvider, repo Repo, base string, ours string, theirs string) ([]string, error) {
+	blobs := func(ref string) (map[string]string, error) {
+		tree, err := provider.ReadTree(ctx, repo, ref)
+		if err != nil {
+			return nil, err
+		}
+		files := map[string]string{}
+		for _, entry := range tree {
+			if entry.Type == Blob {
+				files[entry.Path] = entry.SHA
+			}
+		}
+		return files, nil
+	}
+	original, err := blobs(base)
+	if err != nil {
+		return nil, err
+	}
+	left, err := blobs(ours)
+	if err != nil {
+		return nil, err
+	}
+	right, err := blobs(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	changed, conflicts := []string{}, []string{}
+	for _, files := range []map[string]string{left, right, original} {
+		for file := range files {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			before, hadBefore := original[file]
+			mine, haveMine := left[file]
+			yours, haveYours := right[file]
+			if mine == before || yours == before || mine == yours {
+				continue
+			}
+			changed = append(changed, file)
+			if !hadBefore || !haveMine || !haveYours {
+				conflicts = append(conflicts, file)
+				continue
+			}
+			clean, err := mergesCleanly(ctx, provider, repo, file, base, ours, theirs)
+			if err != nil {
+				return nil, err
+			}
+			if !clean {
+				conflicts = append(conflicts, file)
+			}
+		}
+	}
+	if len(conflicts) == 0 {
+		conflicts = changed
+	}
+	sort.Strings(conflicts)
+	return conflicts, nil
+}
+
+// mergesCleanly reports whether the changes ours and theirs made to file
+// merge line by line
+func mergesCleanly(ctx context.Context, provider GitProvider, repo Repo, file string, base string, ours string, theirs string) (bool, error) {
+	var contents [3][]byte
+	for i, ref := range []string{base, ours, theirs} {
+		content, err := provider.ReadFile(ctx, repo, ref, file)
+		if err != nil {
+			return false, err
+		}
+		contents[i] = content
+	}
+	_, clean := mergeText(contents[0], contents[1], contents[2])
+	return clean, nil
+}
+
+// ValidPath accepts clean relative file paths
+func ValidPath(file string) bool {
+	return file != "" && path.Clean(file) == file && !path.IsAbs(file) && file != ".." && !strings.HasPrefix(file, "../") && !strings.Contains(file, "\x00")
 }
@@ -0,0 +1,147 @@
+package git
+
+import (
+	"bytes"
+)
+
+// Files larger than this, or pairs of files whose differing lines would need
+// a bigger table, are not merged line by line and count as conflicts
+const (
+	mergeMaxBytes = 1 << 20
+	mergeMaxCells = 4 << 20
+)
+
+// mergeText merges the changes ours and theirs made to base line by line the
+// way diff3 does. Changes to the same or adjacent lines only merge when both
+// sides made the same change. Binary files never merge.
+func mergeText(base []byte, ours []byte, theirs []byte) ([]byte, bool) {
+	for _, content := range [][]byte{base, ours, theirs} {
+		if len(content) > mergeMaxBytes || bytes.IndexByte(content, 0) >= 0 {
+			return nil, false
+		}
+	}
+	original, left, right := splitLines(base), splitLines(ours), splitLines(theirs)
+	toLeft, ok := matchLines(original, left)
+	if !ok {
+		return nil, false
+	}
+	toRight, ok := matchLines(original, right)
+	if !ok {
+		return nil, false
+	}
+
+	var merged bytes.Buffer
+	b, o, t := 0, 0, 0
+	for b < len(original) || o < len(left) || t < len(right) {
+		if b < len(original) && toLeft[b] == o && toRight[b] == t {
+			merged.WriteString(original[b])
+			b, o, t = b+1, o+1, t+1
+			continue
+		}
+		// The chunk runs until the next base line both sides kept
+		next, leftEnd, rightEnd := len(original), len(left), len(right)
+		for i := b; i < len(original); i++ {
+			if toLeft[i] >= 0 && toRight[i] >= 0 {
+				next, leftEnd, rightEnd = i, toLeft[i], toRight[i]
+				break
+			}
+		}
+		before, mine, yours := original[b:next], left[o:leftEnd], right[t:rightEnd]
+		switch {
+		case equalLines(mine, before):
+			writeLines(&merged, yours)
+		case equalLines(yours, before), equalLines(mine, yours):
+			writeLines(&merged, mine)
+		default:
+			return nil, false
+		}
+		b, o, t = next, leftEnd, rightEnd
+	}
+	return merged.Bytes(), true
+}
+
+// splitLines splits content after every newline, the last line may have none
+func splitLines(content []byte) []string {
+	var lines []string
+	for len(content) > 0 {
+		end := bytes.IndexByte(content, '\n') + 1
+		if end == 0 {
+			end = len(content)
+		}
+		lines = append(lines, string(content[:end]))
+		content = content[end:]
+	}
+	return lines
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(buffer *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		buffer.WriteString(line)
+	}
+}
+
+// matchLines finds the longest common subsequence of two files and returns,
+// for every line of from, the index of the matching line of to or -1.
+// It fails when the lines between the common prefix and suffix are too many.
+func matchLines(from []string, to []string) ([]int, bool) {
+	matches := make([]int, len(from))
+	for i := range matches {
+		matches[i] = -1
+	}
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		matches[prefix] = prefix
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		matches[len(from)-1-suffix] = len(to) - 1 - suffix
+		suffix++
+	}
+
+	a, b := from[prefix:len(from)-suffix], to[prefix:len(to)-suffix]
+	if len(a) == 0 || len(b) == 0 {
+		return matches, true
+	}
+	if (len(a)+1)*(len(b)+1) > mergeMaxCells {
+		return nil, false
+	}
+	// lengths[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	width := len(b) + 1
+	lengths := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i*width+j] = lengths[(i+1)*width+j+1] + 1
+			} else if lengths[(i+1)*width+j] >= lengths[i*width+j+1] {
+				lengths[i*width+j] = lengths[(i+1)*width+j]
+			} else {
+				lengths[i*width+j] = lengths[i*width+j+1]
+			}
+		}
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			matches[prefix+i] = prefix + j
+			i, j = i+1, j+1
+		case lengths[(i+1)*width+j] >= lengths[i*width+j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches, true
+}
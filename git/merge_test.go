@@ -0,0 +1,32 @@
+package git
+
+import "testing"
+
+func TestMergeText(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name   string
+		ours   string
+		theirs string
+		merged string
+		ok     bool
+	}{
+		{"unchanged", base, base, base, true},
+		{"one side", "a\nB\nc\nd\ne\n", base, "a\nB\nc\nd\ne\n", true},
+		{"other side", base, "a\nb\nc\nd\nE\n", "a\nb\nc\nd\nE\n", true},
+		{"separate lines", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n", true},
+		{"insert and delete", "a\nb\nnew\nc\nd\ne\n", "a\nb\nc\ne\n", "a\nb\nnew\nc\ne\n", true},
+		{"same change", "a\nX\nc\nd\ne\n", "a\nX\nc\nd\ne\n", "a\nX\nc\nd\ne\n", true},
+		{"same line", "a\nX\nc\nd\ne\n", "a\nY\nc\nd\ne\n", "", false},
+		{"adjacent lines", "a\nX\nc\nd\ne\n", "a\nb\nY\nd\ne\n", "", false},
+		{"missing newline", "a\nb\nc\nd\ne", "A\nb\nc\nd\ne\n", "A\nb\nc\nd\ne", true},
+		{"both at the end", "a\nb\nc\nd\ne", "a\nb\nc\nd\ne\nf\n", "", false},
+		{"binary", "a\x00\n", base, "", false},
+	}
+	for _, test := range tests {
+		merged, ok := mergeText([]byte(base), []byte(test.ours), []byte(test.theirs))
+		if ok != test.ok || string(merged) != test.merged {
+			t.Errorf("%s: got %q, %v want %q, %v", test.name, merged, ok, test.merged, test.ok)
+		}
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
 	"strconv"
 	"time"
 
+	"api/captcha"
 	"api/database"
 	"api/email"
 	"api/git"
+	"api/nsfw"
+	"api/password"
 	"api/routes"
+	"api/secrets"
 	"api/storage"
 
 	"github.com/bytedance/sonic"
@@ -27,23 +33,59 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to convert RPS to integer")
 	}
+	bodyLimit, err := strconv.Atoi(env.BodyLimit)
+	if err != nil {
+		log.Fatal("Failed to convert BODY_LIMIT to integer")
+	}
 
-	storage.Connect(&env)
+	if err := secrets.Connect(&env); err != nil {
+		log.Fatal("Failed to load encryption keys ", err.Error())
+	}
 
 	db := database.Connect(&env)
 
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		rotated, err := database.RotateSecrets(db)
+		if err != nil {
+			log.Fatal("Failed to rotate secrets ", err.Error())
+		}
+		fmt.Printf("Re-encrypted %d secrets with key %s\n", rotated, secrets.CurrentKey())
+		return
+	}
+
+	if err := storage.Connect(&env); err != nil {
+		log.Fatal("Failed to connect to storage ", err.Error())
+	}
+
 	rdb := database.RedisConnect(&env)
+
+	if err := nsfw.Connect(&env, &database.BlockedHashes{DB: db, Redis: rdb}); err != nil {
+		log.Fatal("Failed to configure moderation ", err.Error())
+	}
+
+	if err := password.Connect(&env); err != nil {
+		log.Fatal("Failed to configure password hashing ", err.Error())
+	}
+
 	sender := email.NewEmailSender(env.SmtpHost, env.SmtpPort, env.SmtpUsername, env.SenderPassword, env.SenderEmail)
 
-	git, err := git.Connect(&env)
-	if err != nil {
+	if err := git.Connect(&env); err != nil {
 		log.Fatal("Failed to connect to git ", err.Error())
 	}
+	if err := database.BackfillProjects(db); err != nil {
+		log.Fatal("Failed to backfill projects ", err.Error())
+	}
+
+	verifier, err := captcha.Connect(&env)
+	if err != nil {
+		log.Fatal("Failed to configure captcha ", err.Error())
+	}
 
 	app := fiber.New(fiber.Config{
 		JSONEncoder: sonic.Marshal,
 		JSONDecoder: sonic.Unmarshal,
 		Prefork:     true,
+		BodyLimit:   bodyLimit,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			return c.Status(fiber.StatusBadRequest).JSON(routes.GlobalErrorHandlerResp{
 				Success: false,
@@ -64,7 +106,7 @@ func main() {
 	}))
 	app.Get("/monitor", monitor.New())
 	// router.Use(middleware.LeakBucket(limiter))
-	routes.DefineRoutes(app, db, rdb, &env, sender, git)
+	routes.DefineRoutes(app, db, rdb, &env, sender, verifier)
 
 	app.Listen(":" + env.Port)
 }
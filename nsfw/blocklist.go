@@ -0,0 +1,93 @@
+package nsfw
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// DHash is a 64 bit difference hash: the image is shrunk to 9x8 grayscale
+// pixels and every bit says whether a pixel is brighter than its right
+// neighbour. Resized or recompressed copies of an image hash within a few bits.
+func DHash(img image.Image) uint64 {
+	small := imaging.Resize(img, 9, 8, imaging.Box)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luminance(small, x, y) > luminance(small, x+1, y) {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return hash
+}
+
+func luminance(img *image.NRGBA, x int, y int) float64 {
+	i := img.PixOffset(x, y)
+	return 0.299*float64(img.Pix[i]) + 0.587*float64(img.Pix[i+1]) + 0.114*float64(img.Pix[i+2])
+}
+
+// Distance is the number of differing bits between two hashes
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash encodes a hash as 16 hex characters
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+func ParseHash(hash string) (uint64, error) {
+	return strconv.ParseUint(hash, 16, 64)
+}
+
+// HashSource lists the hashes of blocked images
+type HashSource interface {
+	BlockedHashes(ctx context.Context) ([]uint64, error)
+}
+
+type sourceKey struct{}
+
+// WithSource attaches the uncropped image the classified image was cut from.
+// The blocklist matches both, so cropping a blocked image does not get it through.
+func WithSource(ctx context.Context, source image.Image) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// Blocklist scores "blocked" as 1 when the image, or its source from WithSource,
+// hashes within MaxDistance bits of a blocked image. "blocked_similarity" is the
+// share of matching bits of the closest blocked hash.
+type Blocklist struct {
+	Source      HashSource
+	MaxDistance int
+}
+
+func (b *Blocklist) Name() string {
+	return "blocklist"
+}
+func (b *Blocklist) Classify(ctx context.Context, img image.Image) (Scores, error) {
+	hashes, err := b.Source.BlockedHashes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []uint64{DHash(img)}
+	if source, ok := ctx.Value(sourceKey{}).(image.Image); ok && source != nil {
+		candidates = append(candidates, DHash(source))
+	}
+	closest := 64
+	for _, blocked := range hashes {
+		for _, hash := range candidates {
+			if distance := Distance(hash, blocked); distance < closest {
+				closest = distance
+			}
+		}
+	}
+	scores := Scores{"blocked": 0, "blocked_similarity": 1 - float64(closest)/64}
+	if len(hashes) > 0 && closest <= b.MaxDistance {
+		scores["blocked"] = 1
+	}
+	return scores, nil
+}
@@ -0,0 +1,234 @@
+package nsfw
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/structs"
+)
+
+// Scores maps labels such as "nsfw" to a confidence between 0 and 1
+type Scores map[string]float64
+
+// Classifier scores an image. Classifiers only report scores, the Pipeline decides.
+type Classifier interface {
+	Name() string
+	Classify(ctx context.Context, img image.Image) (Scores, error)
+}
+
+type Outcome string
+
+const (
+	// Allowed images passed every threshold
+	Allowed Outcome = "allowed"
+	// Rejected images reached the threshold of at least one label
+	Rejected Outcome = "rejected"
+	// Unavailable means a classifier failed under the closed policy and nothing was decided
+	Unavailable Outcome = "unavailable"
+)
+
+// Result is the output of one classifier in a pipeline
+type Result struct {
+	Classifier string `json:"classifier"`
+	Scores     Scores `json:"scores,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type Decision struct {
+	Outcome Outcome
+	// Label that reached its threshold when Outcome is Rejected
+	Label   string
+	Results []Result
+}
+
+// Pipeline runs classifiers in order and stops at the first rejection.
+// A label is rejected when any classifier scores it at or above its threshold.
+type Pipeline struct {
+	Classifiers []Classifier
+	Thresholds  map[string]float64
+	// FailClosed makes a classifier error block the image instead of skipping the classifier
+	FailClosed bool
+	// Timeout limits every classifier call, 0 disables it
+	Timeout time.Duration
+
+	// workers bounds how many classifier calls run at once, nil means no limit
+	workers chan struct{}
+}
+
+// NewPipeline creates a pipeline that runs at most workers classifier calls at once
+func NewPipeline(classifiers []Classifier, thresholds map[string]float64, failClosed bool, workers int, timeout time.Duration) *Pipeline {
+	p := &Pipeline{Classifiers: classifiers, Thresholds: thresholds, FailClosed: failClosed, Timeout: timeout}
+	if workers > 0 {
+		p.workers = make(chan struct{}, workers)
+	}
+	return p
+}
+
+var pipeline = NewPipeline([]Classifier{&Heuristic{}}, map[string]float64{"nsfw": 0.5}, true, runtime.NumCPU(), 10*time.Second)
+
+// Connect builds the pipeline from MODERATION_CLASSIFIERS, MODERATION_THRESHOLDS,
+// MODERATION_FAIL_MODE, MODERATION_WORKERS and MODERATION_TIMEOUT.
+// blocked provides the hashes for the blocklist classifier.
+func Connect(env *structs.Environment, blocked HashSource) error {
+	var classifiers []Classifier
+	for _, name := range strings.Split(env.ModerationClassifiers, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "none":
+		case "blocklist":
+			distance, err := strconv.Atoi(env.ModerationBlocklistDistance)
+			if err != nil || distance < 0 || distance > 64 {
+				return fmt.Errorf("invalid MODERATION_BLOCKLIST_DISTANCE %s", env.ModerationBlocklistDistance)
+			}
+			classifiers = append(classifiers, &Blocklist{Source: blocked, MaxDistance: distance})
+		case "heuristic":
+			classifiers = append(classifiers, &Heuristic{})
+		case "remote":
+			if env.ModerationRemoteUrl == "" {
+				return fmt.Errorf("MODERATION_REMOTE_URL is required for the remote classifier")
+			}
+			classifiers = append(classifiers, NewRemote(env.ModerationRemoteUrl, env.ModerationRemoteToken))
+		default:
+			return fmt.Errorf("unknown moderation classifier %s", name)
+		}
+	}
+
+	thresholds := map[string]float64{}
+	for _, pair := range strings.Split(env.ModerationThresholds, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		label, value, found := strings.Cut(pair, ":")
+		threshold, err := strconv.ParseFloat(value, 64)
+		if !found || err != nil || threshold < 0 || threshold > 1 {
+			return fmt.Errorf("invalid moderation threshold %s", pair)
+		}
+		thresholds[strings.TrimSpace(label)] = threshold
+	}
+	// A blocklist match is always a rejection unless configured otherwise
+	if _, ok := thresholds["blocked"]; !ok {
+		thresholds["blocked"] = 1
+	}
+
+	var failClosed bool
+	switch env.ModerationFailMode {
+	case "closed":
+		failClosed = true
+	case "open":
+	default:
+		return fmt.Errorf("unknown moderation fail mode %s", env.ModerationFailMode)
+	}
+
+	workers := runtime.NumCPU()
+	if env.ModerationWorkers != "" {
+		parsed, err := strconv.Atoi(env.ModerationWorkers)
+		if err != nil || parsed < 1 {
+			return fmt.Errorf("invalid MODERATION_WORKERS %s", env.ModerationWorkers)
+		}
+		workers = parsed
+	}
+	timeout, err := time.ParseDuration(env.ModerationTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid MODERATION_TIMEOUT: %w", err)
+	}
+
+	pipeline = NewPipeline(classifiers, thresholds, failClosed, workers, timeout)
+	return nil
+}
+
+// Check runs img through the configured pipeline
+func Check(ctx context.Context, img image.Image) Decision {
+	return pipeline.Check(ctx, img)
+}
+
+func (p *Pipeline) Check(ctx context.Context, img image.Image) Decision {
+	decision := Decision{Outcome: Allowed}
+	for _, classifier := range p.Classifiers {
+		scores, err := p.classify(ctx, classifier, img)
+		if err != nil {
+			decision.Results = append(decision.Results, Result{Classifier: classifier.Name(), Error: err.Error()})
+			if p.FailClosed {
+				decision.Outcome = Unavailable
+				return decision
+			}
+			continue
+		}
+		decision.Results = append(decision.Results, Result{Classifier: classifier.Name(), Scores: scores})
+		if label, rejected := p.exceeds(scores); rejected {
+			decision.Outcome = Rejected
+			decision.Label = label
+			return decision
+		}
+	}
+	return decision
+}
+
+type classifyResult struct {
+	scores Scores
+	err    error
+}
+
+// classify runs one classifier in a worker slot. It returns when the timeout
+// passes even if the classifier ignores ctx; the slot is only freed once the
+// classifier is done so the limit still holds. Panics become errors.
+func (p *Pipeline) classify(ctx context.Context, classifier Classifier, img image.Image) (Scores, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	if p.workers != nil {
+		select {
+		case p.workers <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("nsfw: waiting for a worker: %w", ctx.Err())
+		}
+	}
+
+	done := make(chan classifyResult, 1)
+	go func() {
+		defer func() {
+			if p.workers != nil {
+				<-p.workers
+			}
+			if recovered := recover(); recovered != nil {
+				done <- classifyResult{err: fmt.Errorf("nsfw: %s panicked: %v", classifier.Name(), recovered)}
+			}
+		}()
+		scores, err := classifier.Classify(ctx, img)
+		done <- classifyResult{scores, err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.scores, result.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("nsfw: %s: %w", classifier.Name(), ctx.Err())
+	}
+}
+
+func (p *Pipeline) exceeds(scores Scores) (string, bool) {
+	for label, threshold := range p.Thresholds {
+		if score, ok := scores[label]; ok && score >= threshold {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// Fixed returns the same scores for every image, for tests and local development
+type Fixed struct {
+	Scores Scores
+	Err    error
+}
+
+func (f *Fixed) Name() string {
+	return "fixed"
+}
+func (f *Fixed) Classify(ctx context.Context, img image.Image) (Scores, error) {
+	return f.Scores, f.Err
+}
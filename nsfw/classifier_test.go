@@ -0,0 +1,144 @@
+package nsfw
+
+import (
+	"context"
+	"image"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testImage = image.NewNRGBA(image.Rect(0, 0, 4, 4))
+
+// blocking holds every Classify call until release is closed, ignoring ctx,
+// and records how many calls ran at once
+type blocking struct {
+	release chan struct{}
+
+	mutex   sync.Mutex
+	running int
+	most    int
+	started chan struct{}
+}
+
+func newBlocking() *blocking {
+	return &blocking{release: make(chan struct{}), started: make(chan struct{}, 100)}
+}
+
+func (b *blocking) Name() string {
+	return "blocking"
+}
+func (b *blocking) Classify(ctx context.Context, img image.Image) (Scores, error) {
+	b.mutex.Lock()
+	b.running++
+	if b.running > b.most {
+		b.most = b.running
+	}
+	b.mutex.Unlock()
+	b.started <- struct{}{}
+	<-b.release
+	b.mutex.Lock()
+	b.running--
+	b.mutex.Unlock()
+	return Scores{"nsfw": 0}, nil
+}
+
+type panicking struct{}
+
+func (p *panicking) Name() string {
+	return "panicking"
+}
+func (p *panicking) Classify(ctx context.Context, img image.Image) (Scores, error) {
+	panic("broken model")
+}
+
+func TestPipelineDecision(t *testing.T) {
+	thresholds := map[string]float64{"nsfw": 0.5}
+	second := &Fixed{Scores: Scores{"nsfw": 0.9}}
+
+	decision := NewPipeline([]Classifier{&Fixed{Scores: Scores{"nsfw": 0.6}}, second}, thresholds, true, 0, 0).Check(context.Background(), testImage)
+	if decision.Outcome != Rejected || decision.Label != "nsfw" || len(decision.Results) != 1 {
+		t.Fatalf("rejection = %+v, want a stop at the first classifier", decision)
+	}
+
+	failing := &Fixed{Err: context.DeadlineExceeded}
+	decision = NewPipeline([]Classifier{failing, &Fixed{Scores: Scores{"nsfw": 0.1}}}, thresholds, true, 0, 0).Check(context.Background(), testImage)
+	if decision.Outcome != Unavailable || len(decision.Results) != 1 || decision.Results[0].Error == "" {
+		t.Fatalf("failing closed = %+v", decision)
+	}
+	decision = NewPipeline([]Classifier{failing, &Fixed{Scores: Scores{"nsfw": 0.1}}}, thresholds, false, 0, 0).Check(context.Background(), testImage)
+	if decision.Outcome != Allowed || len(decision.Results) != 2 {
+		t.Fatalf("failing open = %+v", decision)
+	}
+
+	decision = NewPipeline([]Classifier{&panicking{}}, thresholds, true, 1, 0).Check(context.Background(), testImage)
+	if decision.Outcome != Unavailable || !strings.Contains(decision.Results[0].Error, "panicked") {
+		t.Fatalf("panic = %+v", decision)
+	}
+}
+
+func TestPipelineWorkers(t *testing.T) {
+	classifier := newBlocking()
+	pipeline := NewPipeline([]Classifier{classifier}, map[string]float64{"nsfw": 0.5}, true, 2, 0)
+
+	var checks sync.WaitGroup
+	decisions := make(chan Decision, 5)
+	for i := 0; i < 5; i++ {
+		checks.Add(1)
+		go func() {
+			defer checks.Done()
+			decisions <- pipeline.Check(context.Background(), testImage)
+		}()
+	}
+	<-classifier.started
+	<-classifier.started
+	select {
+	case <-classifier.started:
+		t.Fatal("a third classifier call started while two were running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(classifier.release)
+	checks.Wait()
+	close(decisions)
+	for decision := range decisions {
+		if decision.Outcome != Allowed {
+			t.Fatalf("decision = %+v", decision)
+		}
+	}
+	if classifier.most != 2 {
+		t.Fatalf("%d calls ran at once, want 2", classifier.most)
+	}
+}
+
+func TestPipelineTimeout(t *testing.T) {
+	classifier := newBlocking()
+	pipeline := NewPipeline([]Classifier{classifier}, map[string]float64{"nsfw": 0.5}, true, 1, 20*time.Millisecond)
+
+	started := time.Now()
+	decision := pipeline.Check(context.Background(), testImage)
+	if decision.Outcome != Unavailable || !strings.Contains(decision.Results[0].Error, "deadline exceeded") {
+		t.Fatalf("timed out decision = %+v", decision)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("a classifier ignoring its context held the check for %s", elapsed)
+	}
+
+	// The timed out call keeps its worker until it returns
+	decision = pipeline.Check(context.Background(), testImage)
+	if decision.Outcome != Unavailable || !strings.Contains(decision.Results[0].Error, "waiting for a worker") {
+		t.Fatalf("decision without a free worker = %+v", decision)
+	}
+
+	close(classifier.release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		decision = pipeline.Check(context.Background(), testImage)
+		if decision.Outcome == Allowed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker was not freed after the classifier returned: %+v", decision)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package nsfw
+
+import (
+	"context"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// Images are scaled down before the skin tone heuristic, which does not need detail
+const heuristicSize = 256
+
+// Heuristic is the skin tone detector. It only gives a yes or no answer,
+// reported as an nsfw score of 0 or 1, along with the fraction of skin.
+type Heuristic struct{}
+
+func (h *Heuristic) Name() string {
+	return "heuristic"
+}
+func (h *Heuristic) Classify(ctx context.Context, img image.Image) (Scores, error) {
+	report, err := Detect(ctx, imaging.Fit(img, heuristicSize, heuristicSize, imaging.Lanczos))
+	if err != nil {
+		return nil, err
+	}
+	scores := Scores{"nsfw": 0, "skin": report.Skin}
+	if report.Nude {
+		scores["nsfw"] = 1
+	}
+	return scores, nil
+}
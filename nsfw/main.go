@@ -1,60 +1,154 @@
 package nsfw
 
 import (
-	"bytes"
-	"fmt"
+	"context"
 	"image"
-	"image/jpeg"
-	"io"
-	"log"
-	"os"
-
-	"github.com/koyachi/go-nude"
+	"math"
+	"sort"
 )
 
-func IsNsfw(image *bytes.Buffer) (bool, error) {
-	tempFile, err := os.CreateTemp("", "nsfwimage-*.jpg")
-	if err != nil {
-		log.Fatalf("create temp file error " + err.Error())
-		return false, err
-	}
-	fmt.Println(tempFile.Name())
-	// defer os.Remove(tempFile.Name())
-	png, err := toJpg(image)
-	if err != nil {
-		log.Fatalf("to png error " + err.Error())
-		return false, err
-	}
-	if _, err := io.Copy(tempFile, png); err != nil {
-		log.Fatalf("copy file error " + err.Error())
-		return false, err
-	}
-	if err := tempFile.Close(); err != nil {
-		log.Fatalf("close file error " + err.Error())
-		return false, err
-	}
-	result, err := nude.IsNude(tempFile.Name())
-	if err != nil {
-		fmt.Println("there was am errpr")
-		fmt.Println(err.Error())
-	}
-	fmt.Println(result)
-	return result, err
+// Skin regions with this many pixels or fewer are treated as noise
+const minRegionPixels = 30
+
+// Report is the result of the skin tone heuristic
+type Report struct {
+	Nude bool
+	// Fraction of all pixels that belong to a skin region
+	Skin float64
+	// Number of skin regions larger than minRegionPixels
+	Regions int
 }
-func toJpg(img *bytes.Buffer) (*bytes.Buffer, error) {
-	var jpgBuffer bytes.Buffer
 
-	imgBytes := img.Bytes()
-	imgReader := bytes.NewReader(imgBytes)
-	imgDecoded, _, err := image.Decode(imgReader)
-	if err != nil {
-		return nil, err
+// Detect runs the skin region heuristic from go-nude (MIT, koyachi) on a
+// decoded image. Skin pixels are grouped into connected regions and the image
+// counts as nude when a few large regions cover enough of it. It stops with
+// ctx.Err() when ctx is cancelled.
+func Detect(ctx context.Context, img image.Image) (Report, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return Report{}, nil
+	}
+
+	// labels holds the region of every skin pixel plus one, 0 for other pixels.
+	// Regions that touch are joined in parent, a union find forest.
+	labels := make([]int32, width*height)
+	parent := []int32{0}
+	find := func(label int32) int32 {
+		for parent[label] != label {
+			parent[label] = parent[parent[label]]
+			label = parent[label]
+		}
+		return label
+	}
+
+	for y := 0; y < height; y++ {
+		if err := ctx.Err(); err != nil {
+			return Report{}, err
+		}
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			if !isSkin(float64(r>>8), float64(g>>8), float64(b>>8)) {
+				continue
+			}
+			// Join with the already visited neighbours: left, up left, up and up right
+			var label int32
+			for _, neighbour := range [][2]int{{x - 1, y}, {x - 1, y - 1}, {x, y - 1}, {x + 1, y - 1}} {
+				nx, ny := neighbour[0], neighbour[1]
+				if nx < 0 || ny < 0 || nx >= width {
+					continue
+				}
+				other := labels[nx+ny*width]
+				if other == 0 {
+					continue
+				}
+				if label == 0 {
+					label = find(other)
+				} else if root := find(other); root != label {
+					parent[root] = label
+				}
+			}
+			if label == 0 {
+				label = int32(len(parent))
+				parent = append(parent, label)
+			}
+			labels[x+y*width] = label
+		}
 	}
 
-	options := &jpeg.Options{Quality: 100}
-	if err := jpeg.Encode(&jpgBuffer, imgDecoded, options); err != nil {
-		return nil, err
+	sizes := map[int32]int{}
+	for _, label := range labels {
+		if label != 0 {
+			sizes[find(label)]++
+		}
 	}
+	var regions []int
+	skinPixels := 0
+	for _, size := range sizes {
+		if size > minRegionPixels {
+			regions = append(regions, size)
+			skinPixels += size
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(regions)))
+
+	report := Report{Skin: float64(skinPixels) / float64(width*height), Regions: len(regions)}
+	report.Nude = analyzeRegions(regions, skinPixels, report.Skin)
+	return report, nil
+}
 
-	return &jpgBuffer, nil
+// analyzeRegions applies the go-nude rules to region sizes sorted largest first
+func analyzeRegions(regions []int, skinPixels int, skin float64) bool {
+	if len(regions) < 3 || skin < 0.15 {
+		return false
+	}
+	biggest := float64(regions[0]) / float64(skinPixels)
+	second := float64(regions[1]) / float64(skinPixels)
+	third := float64(regions[2]) / float64(skinPixels)
+	if biggest < 0.35 && second < 0.30 && third < 0.30 {
+		return false
+	}
+	if biggest < 0.45 {
+		return false
+	}
+	return len(regions) <= 60
+}
+
+// isSkin combines RGB, normalized RGB and HSV rules from
+// "A Survey on Pixel-Based Skin Color Detection Techniques"
+func isSkin(r, g, b float64) bool {
+	max := math.Max(math.Max(r, g), b)
+	min := math.Min(math.Min(r, g), b)
+	sum := r + g + b
+	if sum == 0 {
+		return false
+	}
+
+	if r > 95 && g > 40 && g < 100 && b > 20 && max-min > 15 && math.Abs(r-g) > 15 && r > g && r > b {
+		return true
+	}
+
+	if g > 0 && r/g > 1.185 && r*b/(sum*sum) > 0.107 && r*g/(sum*sum) > 0.112 {
+		return true
+	}
+
+	diff := max - min
+	if diff == 0 {
+		return false
+	}
+	var h float64
+	switch max {
+	case r:
+		h = (g - b) / diff
+	case g:
+		h = 2 + (b-r)/diff
+	default:
+		h = 4 + (r-g)/diff
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	s := 1 - 3*min/sum
+	return h > 0 && h < 35 && s > 0.23 && s < 0.68
 }
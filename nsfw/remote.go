@@ -0,0 +1,72 @@
+package nsfw
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/disintegration/imaging"
+)
+
+// Images sent to a remote model are scaled down to keep requests small
+const remoteSize = 512
+
+// Remote sends images to a model server such as an ONNX runtime sidecar. It
+// POSTs the image as PNG and expects {"scores": {"<label>": <0-1>}} back.
+type Remote struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func NewRemote(url string, token string) *Remote {
+	return &Remote{URL: url, Token: token, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type remoteResponse struct {
+	Scores Scores `json:"scores"`
+}
+
+func (r *Remote) Name() string {
+	return "remote"
+}
+func (r *Remote) Classify(ctx context.Context, img image.Image) (Scores, error) {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, imaging.Fit(img, remoteSize, remoteSize, imaging.Lanczos)); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, &buffer)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nsfw: remote classifier returned %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result remoteResponse
+	if err := sonic.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if result.Scores == nil {
+		return nil, fmt.Errorf("nsfw: remote classifier returned no scores")
+	}
+	return result.Scores, nil
+}
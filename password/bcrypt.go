@@ -0,0 +1,48 @@
+package password
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt silently ignores everything after 72 bytes, so longer passwords are rejected
+type Bcrypt struct {
+	Cost int
+}
+
+func NewBcrypt() *Bcrypt {
+	return &Bcrypt{Cost: bcrypt.DefaultCost}
+}
+
+func (b *Bcrypt) Name() string {
+	return "bcrypt"
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	if len(password) > 72 {
+		return "", ErrTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *Bcrypt) Compare(encoded string, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (b *Bcrypt) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
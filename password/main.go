@@ -0,0 +1,131 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"api/structs"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatch         = errors.New("password: hash is not the hash of the given password")
+	ErrUnknownAlgorithm = errors.New("password: unknown hash algorithm")
+	ErrTooLong          = errors.New("password: password is too long for the selected algorithm")
+
+	hashers        = []Hasher{NewArgon2id(), NewBcrypt()}
+	hasher  Hasher = hashers[0]
+)
+
+// Hasher hashes passwords into a self describing encoded string
+type Hasher interface {
+	// Name of the algorithm, used in configuration
+	Name() string
+	// Hash encodes password with the current parameters
+	Hash(password string) (string, error)
+	// Matches reports whether encoded was produced by this hasher
+	Matches(encoded string) bool
+	// Compare returns nil when password matches encoded and ErrMismatch otherwise
+	Compare(encoded string, password string) error
+	// Outdated reports whether encoded was hashed with different parameters
+	Outdated(encoded string) bool
+}
+
+// Connect selects the hasher used for new passwords from the environment.
+// Every supported algorithm can still verify existing hashes.
+func Connect(env *structs.Environment) error {
+	argon := NewArgon2id()
+	if err := parseUint(env.Argon2Time, &argon.Time); err != nil {
+		return fmt.Errorf("invalid ARGON2_TIME: %w", err)
+	}
+	if err := parseUint(env.Argon2Memory, &argon.Memory); err != nil {
+		return fmt.Errorf("invalid ARGON2_MEMORY: %w", err)
+	}
+	threads := uint32(argon.Threads)
+	if err := parseUint(env.Argon2Threads, &threads); err != nil {
+		return fmt.Errorf("invalid ARGON2_THREADS: %w", err)
+	}
+	// argon2.IDKey panics on a time or parallelism of 0
+	if argon.Time < 1 {
+		return fmt.Errorf("invalid ARGON2_TIME: must be at least 1")
+	}
+	if argon.Memory < 1 {
+		return fmt.Errorf("invalid ARGON2_MEMORY: must be at least 1")
+	}
+	if threads < 1 || threads > 255 {
+		return fmt.Errorf("invalid ARGON2_THREADS: must be between 1 and 255")
+	}
+	argon.Threads = uint8(threads)
+
+	bcryptHasher := NewBcrypt()
+	if env.BcryptCost != "" {
+		cost, err := strconv.Atoi(env.BcryptCost)
+		if err != nil {
+			return fmt.Errorf("invalid BCRYPT_COST: %w", err)
+		}
+		bcryptHasher.Cost = cost
+	}
+	// bcrypt.GenerateFromPassword silently uses the default for lower costs and fails on higher ones
+	if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid BCRYPT_COST: must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	hashers = []Hasher{argon, bcryptHasher}
+	for _, h := range hashers {
+		if h.Name() == env.PasswordAlgorithm {
+			hasher = h
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownAlgorithm, env.PasswordAlgorithm)
+}
+
+// Hash encodes password with the configured hasher
+func Hash(password string) (string, error) {
+	return hasher.Hash(password)
+}
+
+// Compare checks password against an encoded hash of any supported algorithm
+func Compare(encoded string, password string) error {
+	h := find(encoded)
+	if h == nil {
+		return ErrUnknownAlgorithm
+	}
+	return h.Compare(encoded, password)
+}
+
+// NeedsRehash reports whether encoded should be replaced by a fresh Hash
+func NeedsRehash(encoded string) bool {
+	h := find(encoded)
+	if h == nil || h.Name() != hasher.Name() {
+		return true
+	}
+	return h.Outdated(encoded)
+}
+
+func find(encoded string) Hasher {
+	if hasher.Matches(encoded) {
+		return hasher
+	}
+	for _, h := range hashers {
+		if h.Matches(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+func parseUint(value string, field *uint32) error {
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return err
+	}
+	*field = uint32(parsed)
+	return nil
+}
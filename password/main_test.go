@@ -0,0 +1,114 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"api/structs"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastEnv keeps hashing cheap so the tests stay quick
+func fastEnv(algorithm string) *structs.Environment {
+	return &structs.Environment{PasswordAlgorithm: algorithm, Argon2Time: "1", Argon2Memory: "64", Argon2Threads: "1", BcryptCost: "4"}
+}
+
+func TestConnect(t *testing.T) {
+	for _, env := range []*structs.Environment{fastEnv("argon2id"), fastEnv("bcrypt")} {
+		if err := Connect(env); err != nil {
+			t.Fatalf("Connect(%s) = %v", env.PasswordAlgorithm, err)
+		}
+		if hasher.Name() != env.PasswordAlgorithm {
+			t.Fatalf("hasher is %s, want %s", hasher.Name(), env.PasswordAlgorithm)
+		}
+	}
+
+	invalid := map[string]func(env *structs.Environment){
+		"ARGON2_TIME":    func(env *structs.Environment) { env.Argon2Time = "0" },
+		"ARGON2_MEMORY":  func(env *structs.Environment) { env.Argon2Memory = "none" },
+		"ARGON2_THREADS": func(env *structs.Environment) { env.Argon2Threads = "256" },
+		"BCRYPT_COST":    func(env *structs.Environment) { env.BcryptCost = "32" },
+	}
+	for name, change := range invalid {
+		env := fastEnv("argon2id")
+		change(env)
+		if err := Connect(env); err == nil || !strings.Contains(err.Error(), name) {
+			t.Fatalf("invalid %s returned %v", name, err)
+		}
+	}
+	env := fastEnv("bcrypt")
+	env.BcryptCost = "3"
+	if err := Connect(env); err == nil {
+		t.Fatal("a bcrypt cost below the minimum was accepted")
+	}
+	if err := Connect(fastEnv("md5")); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("unknown algorithm returned %v, want ErrUnknownAlgorithm", err)
+	}
+}
+
+func TestHashAndCompare(t *testing.T) {
+	for _, algorithm := range []string{"argon2id", "bcrypt"} {
+		if err := Connect(fastEnv(algorithm)); err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%s: Hash = %v", algorithm, err)
+		}
+		if err := Compare(encoded, "correct horse"); err != nil {
+			t.Fatalf("%s: matching password returned %v", algorithm, err)
+		}
+		if err := Compare(encoded, "wrong horse"); !errors.Is(err, ErrMismatch) {
+			t.Fatalf("%s: wrong password returned %v, want ErrMismatch", algorithm, err)
+		}
+		if NeedsRehash(encoded) {
+			t.Fatalf("%s: a fresh hash needs a rehash", algorithm)
+		}
+	}
+	if err := Compare("plain", "plain"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("unknown hash returned %v, want ErrUnknownAlgorithm", err)
+	}
+	if _, err := Hash(strings.Repeat("a", 73)); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("73 byte bcrypt password returned %v, want ErrTooLong", err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	if err := Connect(fastEnv("bcrypt")); err != nil {
+		t.Fatal(err)
+	}
+	old, err := Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Switching algorithms rehashes, older hashes still verify
+	if err := Connect(fastEnv("argon2id")); err != nil {
+		t.Fatal(err)
+	}
+	if !NeedsRehash(old) {
+		t.Fatal("a bcrypt hash does not need a rehash under argon2id")
+	}
+	if err := Compare(old, "secret"); err != nil {
+		t.Fatalf("bcrypt hash no longer verifies: %v", err)
+	}
+
+	// So does changing the parameters of the same algorithm
+	current, err := Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := fastEnv("argon2id")
+	env.Argon2Time = "2"
+	if err := Connect(env); err != nil {
+		t.Fatal(err)
+	}
+	if !NeedsRehash(current) {
+		t.Fatal("an argon2id hash with another time does not need a rehash")
+	}
+	if cost, _ := bcrypt.Cost([]byte(old)); cost != 4 {
+		t.Fatalf("bcrypt cost = %d, want BCRYPT_COST", cost)
+	}
+}
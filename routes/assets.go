@@ -0,0 +1,292 @@
+package routes
+
+import (
+	goerrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"api/errors"
+	"api/storage"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Image assets are decoded for moderation, larger images are rejected
+const assetMaxDimension = 8192
+
+// How long the URL returned by getAsset stays valid
+const assetUrlExpiry = 15 * time.Minute
+
+// Bytes read to sniff the format of an asset
+const assetSniffBytes = 512
+
+var errUploadFinished = goerrors.New("upload already finished")
+
+type PostAssetUpload struct {
+	Name string `json:"name" validate:"required,max=255"`
+	PostUpload
+}
+
+func postAssetUpload(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleEditor); rtrn {
+		return err
+	}
+	var body PostAssetUpload
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	if !contentTypeAllowed(body.ContentType, assetContentTypes) {
+		return c.Status(http.StatusUnsupportedMediaType).JSON(errors.AssetContentTypeInvalid)
+	}
+	if body.Size > assetMaxBytes {
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(errors.AssetTooLarge)
+	}
+
+	id := generator.Generate().String()
+	return createUpload(c, storage.For(storage.Assets), structs.Upload{
+		ID:          id,
+		UserID:      parsed.UserID,
+		ProjectID:   &projectId,
+		Purpose:     uploadPurposeAsset,
+		Key:         storage.AssetKey(projectId, id, body.Name),
+		Name:        body.Name,
+		ContentType: body.ContentType,
+		Size:        body.Size,
+	})
+}
+
+// completeAssetUpload registers an uploaded asset. Its content type is checked
+// against the magic bytes and images are moderated before they are accepted.
+func completeAssetUpload(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	uploadId := c.Params("upload")
+	if projectId == "" || uploadId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleEditor); rtrn {
+		return err
+	}
+
+	assets := storage.For(storage.Assets)
+	upload, err, rtrn := getPendingUpload(c, assets, structs.Upload{ID: uploadId, UserID: parsed.UserID, ProjectID: &projectId, Purpose: uploadPurposeAsset})
+	if rtrn {
+		return err
+	}
+	contentType, err, rtrn := checkAsset(c, assets, upload)
+	if rtrn {
+		rejectUpload(c, assets, upload)
+		return err
+	}
+
+	asset := structs.Asset{
+		ID:          upload.ID,
+		ProjectID:   projectId,
+		UserID:      parsed.UserID,
+		Key:         upload.Key,
+		Name:        upload.Name,
+		ContentType: contentType,
+		Size:        upload.Size,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Only the request that moves the upload out of pending creates the asset
+		result := tx.Model(&structs.Upload{}).Where("id = ? AND status = ?", upload.ID, uploadPending).Update("status", uploadCompleted)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errUploadFinished
+		}
+		return tx.Create(&asset).Error
+	})
+	if err == errUploadFinished {
+		return c.Status(http.StatusConflict).JSON(errors.UploadFinished)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusCreated).JSON(structs.ApiAsset{
+		ID:          asset.ID,
+		ProjectID:   asset.ProjectID,
+		UserID:      asset.UserID,
+		Name:        asset.Name,
+		ContentType: asset.ContentType,
+		Size:        asset.Size,
+		CreatedAt:   asset.CreatedAt,
+	})
+}
+
+// checkAsset sniffs an uploaded asset and returns the content type to serve it as.
+// Whatever sniffs as an image must be declared as that image type and is decoded and
+// moderated, content declared as an image that does not sniff as one is rejected.
+func checkAsset(c *fiber.Ctx, store storage.Storage, upload structs.Upload) (string, error, bool) {
+	object, _, err := store.Get(ctx, upload.Key)
+	if err != nil {
+		fmt.Println(err.Error())
+		return "", c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError), true
+	}
+	defer object.Close()
+	head := make([]byte, assetSniffBytes)
+	n, err := io.ReadFull(object, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		fmt.Println(err.Error())
+		return "", c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError), true
+	}
+	head = head[:n]
+
+	contentType := mediaType(upload.ContentType)
+	format := storage.Sniff(head)
+	if format == "" {
+		if strings.HasPrefix(contentType, "image/") {
+			return "", c.Status(http.StatusUnsupportedMediaType).JSON(errors.ImageFormatInvalid), true
+		}
+		return contentType, nil, false
+	}
+	if contentType != "image/"+format {
+		return "", c.Status(http.StatusUnsupportedMediaType).JSON(errors.AssetContentTypeMismatch), true
+	}
+
+	rest, err := storage.ReadLimited(object, assetMaxBytes-int64(n))
+	if err == storage.ErrImageTooLarge {
+		return "", c.Status(http.StatusRequestEntityTooLarge).JSON(errors.AssetTooLarge), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return "", c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError), true
+	}
+	data := append(head, rest...)
+	img, err := storage.DecodeImage(data, assetMaxDimension)
+	switch {
+	case err == storage.ErrImageFormat:
+		return "", c.Status(http.StatusUnsupportedMediaType).JSON(errors.ImageFormatInvalid), true
+	case err == storage.ErrImageDimension:
+		return "", c.Status(http.StatusBadRequest).JSON(errors.ImageDimensionsInvalid), true
+	case err != nil:
+		fmt.Println(err.Error())
+		return "", c.Status(http.StatusBadRequest).JSON(errors.ImageInvalid), true
+	}
+	subject := moderationSubject{
+		UserID:      upload.UserID,
+		Kind:        moderationSubjectAsset,
+		SubjectID:   upload.ID,
+		ProjectID:   upload.ProjectID,
+		Name:        upload.Name,
+		Data:        data,
+		ContentType: contentType,
+	}
+	err, rtrn := moderateImage(c, subject, img)
+	return contentType, err, rtrn
+}
+
+func getAssets(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleViewer); rtrn {
+		return err
+	}
+	assets := []structs.ApiAsset{}
+	if err := db.Model(&structs.Asset{}).Where(&structs.Asset{ProjectID: projectId}).Order("created_at").Find(&assets).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(assets)
+}
+
+// getAsset responds with the asset and a presigned URL to download it
+func getAsset(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	assetId := c.Params("asset")
+	if projectId == "" || assetId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleViewer); rtrn {
+		return err
+	}
+	var asset structs.Asset
+	err = db.Where(&structs.Asset{ID: assetId, ProjectID: projectId}).First(&asset).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	url, err := storage.For(storage.Assets).PresignGet(ctx, asset.Key, asset.ContentType, assetUrlExpiry)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+	}
+	return c.JSON(fiber.Map{
+		"asset": structs.ApiAsset{
+			ID:          asset.ID,
+			ProjectID:   asset.ProjectID,
+			UserID:      asset.UserID,
+			Name:        asset.Name,
+			ContentType: asset.ContentType,
+			Size:        asset.Size,
+			CreatedAt:   asset.CreatedAt,
+		},
+		"url":        url,
+		"expires_at": time.Now().Add(assetUrlExpiry),
+	})
+}
+func deleteAsset(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	assetId := c.Params("asset")
+	if projectId == "" || assetId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleEditor); rtrn {
+		return err
+	}
+	var asset structs.Asset
+	err = db.Where(&structs.Asset{ID: assetId, ProjectID: projectId}).First(&asset).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	err = storage.For(storage.Assets).Delete(ctx, asset.Key)
+	if err != nil && !storage.IsNotFound(err) {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+	}
+	if err := db.Delete(&asset).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
@@ -0,0 +1,396 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"api/errors"
+	"api/storage"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const avatarCacheControl = "public, max-age=3600"
+
+// Generated avatars are cached for less time so a new upload shows up sooner
+const generatedAvatarCacheControl = "public, max-age=300"
+
+const (
+	avatarDefaultInitials  = "initials"
+	avatarDefaultIdenticon = "identicon"
+	avatarDefaultNone      = "404"
+)
+
+// AvatarCrop positions the square avatar inside the upload. Either all crop
+// fields or none are sent; the focal point defaults to the center.
+type AvatarCrop struct {
+	CropX      *int     `json:"crop_x" form:"crop_x" query:"crop_x" validate:"omitempty,min=0"`
+	CropY      *int     `json:"crop_y" form:"crop_y" query:"crop_y" validate:"omitempty,min=0"`
+	CropWidth  *int     `json:"crop_width" form:"crop_width" query:"crop_width" validate:"omitempty,min=1"`
+	CropHeight *int     `json:"crop_height" form:"crop_height" query:"crop_height" validate:"omitempty,min=1"`
+	FocalX     *float64 `json:"focal_x" form:"focal_x" query:"focal_x" validate:"omitempty,min=0,max=1"`
+	FocalY     *float64 `json:"focal_y" form:"focal_y" query:"focal_y" validate:"omitempty,min=0,max=1"`
+}
+
+func (a AvatarCrop) toCrop() (storage.Crop, bool) {
+	crop := storage.CenterCrop
+	if a.FocalX != nil {
+		crop.FocalX = *a.FocalX
+	}
+	if a.FocalY != nil {
+		crop.FocalY = *a.FocalY
+	}
+	set := 0
+	for _, field := range []*int{a.CropX, a.CropY, a.CropWidth, a.CropHeight} {
+		if field != nil {
+			set++
+		}
+	}
+	switch set {
+	case 0:
+		return crop, true
+	case 4:
+		rect := image.Rect(*a.CropX, *a.CropY, *a.CropX+*a.CropWidth, *a.CropY+*a.CropHeight)
+		crop.Rect = &rect
+		return crop, true
+	}
+	return crop, false
+}
+
+type PutAvatar struct {
+	Image string `json:"image" validate:"required,base64"`
+	AvatarCrop
+}
+
+// readAvatarUpload accepts a base64 JSON body, a multipart form with an image
+// file field or a raw image body and returns the undecoded image bytes.
+// Crop fields are read from the query string and, for JSON and forms, the body.
+func readAvatarUpload(c *fiber.Ctx) ([]byte, storage.Crop, error, bool) {
+	var fields AvatarCrop
+	if err := c.QueryParser(&fields); err != nil {
+		return nil, storage.Crop{}, c.Status(400).JSON(errors.MalformedBody(err)), true
+	}
+	data, err, rtrn := readAvatarBody(c, &fields)
+	if rtrn {
+		return nil, storage.Crop{}, err, true
+	}
+	errs := _validate.Validate(fields)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return nil, storage.Crop{}, err, true
+	}
+	crop, valid := fields.toCrop()
+	if !valid {
+		return nil, storage.Crop{}, c.Status(http.StatusBadRequest).JSON(errors.ImageCropInvalid), true
+	}
+	return data, crop, nil, false
+}
+func readAvatarBody(c *fiber.Ctx, fields *AvatarCrop) ([]byte, error, bool) {
+	contentType := strings.ToLower(c.Get(fiber.HeaderContentType))
+	switch {
+	case strings.HasPrefix(contentType, fiber.MIMEMultipartForm):
+		if err := c.BodyParser(fields); err != nil {
+			return nil, c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err)), true
+		}
+		file, err := c.FormFile("image")
+		if err != nil {
+			return nil, c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err)), true
+		}
+		if file.Size > avatarMaxBytes {
+			return nil, c.Status(http.StatusRequestEntityTooLarge).JSON(errors.ImageTooLarge), true
+		}
+		reader, err := file.Open()
+		if err != nil {
+			fmt.Println(err.Error())
+			return nil, c.Status(http.StatusInternalServerError).JSON(errors.ServerImageError), true
+		}
+		defer reader.Close()
+		data, err := storage.ReadLimited(reader, avatarMaxBytes)
+		if err == storage.ErrImageTooLarge {
+			return nil, c.Status(http.StatusRequestEntityTooLarge).JSON(errors.ImageTooLarge), true
+		} else if err != nil {
+			fmt.Println(err.Error())
+			return nil, c.Status(http.StatusInternalServerError).JSON(errors.ServerImageError), true
+		}
+		return data, nil, false
+
+	case strings.HasPrefix(contentType, "image/"), strings.HasPrefix(contentType, fiber.MIMEOctetStream):
+		if int64(c.Request().Header.ContentLength()) > avatarMaxBytes {
+			return nil, c.Status(http.StatusRequestEntityTooLarge).JSON(errors.ImageTooLarge), true
+		}
+		var body io.Reader = bytes.NewReader(c.Body())
+		if stream := c.Context().RequestBodyStream(); stream != nil {
+			body = stream
+		}
+		data, err := storage.ReadLimited(body, avatarMaxBytes)
+		if err == storage.ErrImageTooLarge {
+			return nil, c.Status(http.StatusRequestEntityTooLarge).JSON(errors.ImageTooLarge), true
+		} else if err != nil {
+			fmt.Println(err.Error())
+			return nil, c.Status(http.StatusInternalServerError).JSON(errors.ServerImageError), true
+		}
+		return data, nil, false
+	}
+
+	body := PutAvatar{AvatarCrop: *fields}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, c.Status(400).JSON(errors.MalformedBody(err)), true
+	}
+	*fields = body.AvatarCrop
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return nil, err, true
+	}
+	if int64(base64.StdEncoding.DecodedLen(len(body.Image))) > avatarMaxBytes+2 {
+		return nil, c.Status(http.StatusRequestEntityTooLarge).JSON(errors.ImageTooLarge), true
+	}
+	data, err := base64.StdEncoding.DecodeString(body.Image)
+	if err != nil {
+		return nil, c.Status(400).JSON(errors.MalformedBody(err)), true
+	}
+	if int64(len(data)) > avatarMaxBytes {
+		return nil, c.Status(http.StatusRequestEntityTooLarge).JSON(errors.ImageTooLarge), true
+	}
+	return data, nil, false
+}
+
+// decodeAvatar decodes the upload and crops it to a square, writing the matching
+// error response for images that fail validation. It returns the uncropped image too.
+func decodeAvatar(c *fiber.Ctx, data []byte, crop storage.Crop) (image.Image, image.Image, error, bool) {
+	source, err := storage.DecodeImage(data, avatarMaxDimension)
+	var img image.Image
+	if err == nil {
+		img, err = storage.Square(source, crop)
+	}
+	switch {
+	case err == storage.ErrCrop:
+		return nil, nil, c.Status(http.StatusBadRequest).JSON(errors.ImageCropInvalid), true
+	case err == storage.ErrImageFormat:
+		return nil, nil, c.Status(http.StatusUnsupportedMediaType).JSON(errors.ImageFormatInvalid), true
+	case err == storage.ErrImageDimension:
+		return nil, nil, c.Status(http.StatusBadRequest).JSON(errors.ImageDimensionsInvalid), true
+	case err != nil:
+		fmt.Println(err.Error())
+		return nil, nil, c.Status(http.StatusBadRequest).JSON(errors.ImageInvalid), true
+	}
+	return source, img, nil, false
+}
+
+// saveAvatar moderates img, cropped from source, and stores it at every avatar size.
+// When it fails the error response has already been written and should be returned.
+func saveAvatar(c *fiber.Ctx, userId string, source image.Image, img image.Image) (error, bool) {
+	// Encode every size up front so nothing is stored if one of them fails
+	encoded, err := encodeAvatar(img)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(500).JSON(errors.ServerImageError), true
+	}
+	largest := encoded[storage.AvatarSizes[len(storage.AvatarSizes)-1]]
+	subject := moderationSubject{
+		UserID:      userId,
+		Kind:        moderationSubjectAvatar,
+		SubjectID:   userId,
+		Data:        largest.Bytes(),
+		ContentType: "image/webp",
+		Source:      source,
+	}
+	if err, rtrn := moderateImage(c, subject, img); rtrn {
+		return err, true
+	}
+	if err := storeAvatar(userId, encoded); err != nil {
+		fmt.Println(err.Error())
+		return c.Status(500).JSON(errors.ServerImageError), true
+	}
+	return nil, false
+}
+
+// encodeAvatar encodes a square image as WebP at every avatar size
+func encodeAvatar(img image.Image) (map[int]*bytes.Buffer, error) {
+	encoded := make(map[int]*bytes.Buffer, len(storage.AvatarSizes))
+	for _, size := range storage.AvatarSizes {
+		webp, err := storage.ToWebp(storage.Resize(img, size, size))
+		if err != nil {
+			return nil, err
+		}
+		encoded[size] = webp
+	}
+	return encoded, nil
+}
+
+// storeAvatar replaces the avatar of a user with encoded sizes from encodeAvatar
+func storeAvatar(userId string, encoded map[int]*bytes.Buffer) error {
+	avatars := storage.For(storage.Avatars)
+	for size, webp := range encoded {
+		if err := avatars.Put(ctx, storage.AvatarKey(userId, size), webp, int64(webp.Len()), "image/webp"); err != nil {
+			return err
+		}
+	}
+	avatars.Delete(ctx, storage.LegacyAvatarKey(userId))
+	return nil
+}
+
+func putAvatar(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	data, crop, err, rtrn := readAvatarUpload(c)
+	if rtrn {
+		return err
+	}
+	source, img, err, rtrn := decodeAvatar(c, data, crop)
+	if rtrn {
+		return err
+	}
+	if err, rtrn := saveAvatar(c, parsed.UserID, source, img); rtrn {
+		return err
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+func deleteAvatar(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	keys := []string{storage.LegacyAvatarKey(parsed.UserID)}
+	for _, size := range storage.AvatarSizes {
+		keys = append(keys, storage.AvatarKey(parsed.UserID, size))
+	}
+	avatars := storage.For(storage.Avatars)
+	for _, key := range keys {
+		if err := avatars.Delete(ctx, key); err != nil {
+			fmt.Println(err.Error())
+			return c.Status(500).JSON(errors.ServerStorageError)
+		}
+	}
+	return c.Status(204).Send(nil)
+}
+
+// avatarSize picks the smallest stored size that is at least requested
+func avatarSize(requested int) int {
+	for _, size := range storage.AvatarSizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return storage.AvatarSizes[len(storage.AvatarSizes)-1]
+}
+
+// etagMatches reports whether an If-None-Match header matches etag
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func getAvatar(c *fiber.Ctx) error {
+	userId := c.Params("id")
+	if userId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	size := 128
+	if query := c.Query("size"); query != "" {
+		parsedSize, err := strconv.Atoi(query)
+		if err != nil || parsedSize <= 0 {
+			return c.Status(http.StatusBadRequest).JSON(errors.InvalidAvatarSize)
+		}
+		size = avatarSize(parsedSize)
+	}
+
+	avatars := storage.For(storage.Avatars)
+	key := storage.AvatarKey(userId, size)
+	info, err := avatars.Stat(ctx, key)
+	if storage.IsNotFound(err) {
+		// Fall back to an avatar uploaded before multiple sizes existed
+		key = storage.LegacyAvatarKey(userId)
+		info, err = avatars.Stat(ctx, key)
+	}
+	if storage.IsNotFound(err) {
+		return sendGeneratedAvatar(c, userId, size)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+	}
+
+	etag := `"` + strings.Trim(info.ETag, `"`) + `"`
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, avatarCacheControl)
+	c.Set(fiber.HeaderLastModified, info.LastModified.UTC().Format(http.TimeFormat))
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return c.Status(http.StatusNotModified).Send(nil)
+	}
+
+	object, _, err := avatars.Get(ctx, key)
+	if storage.IsNotFound(err) {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+	}
+	c.Set(fiber.HeaderContentType, "image/webp")
+	return c.Status(http.StatusOK).SendStream(object, int(info.Size))
+}
+
+// sendGeneratedAvatar renders the default avatar of a user without an upload.
+// The style comes from the default query parameter or AVATAR_DEFAULT.
+func sendGeneratedAvatar(c *fiber.Ctx, userId string, size int) error {
+	style := c.Query("default", env.AvatarDefault)
+	switch style {
+	case avatarDefaultInitials, avatarDefaultIdenticon:
+	case avatarDefaultNone:
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	default:
+		return c.Status(http.StatusBadRequest).JSON(errors.InvalidAvatarDefault)
+	}
+
+	var profile structs.ApiProfile
+	err := db.Model(&structs.User{}).Where(&structs.User{ID: userId}).First(&profile).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	name := profile.DisplayName
+	if name == "" && profile.Username != nil {
+		name = *profile.Username
+	}
+	if name == "" {
+		style = avatarDefaultIdenticon
+	}
+
+	hash := sha256.Sum256([]byte(style + "\x00" + name + "\x00" + userId + "\x00" + strconv.Itoa(size)))
+	etag := `"generated-` + hex.EncodeToString(hash[:8]) + `"`
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, generatedAvatarCacheControl)
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return c.Status(http.StatusNotModified).Send(nil)
+	}
+
+	var img *image.NRGBA
+	if style == avatarDefaultInitials {
+		img = storage.Initials(name, userId, size)
+	} else {
+		img = storage.Identicon(userId, size)
+	}
+	webp, err := storage.ToWebp(img)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerImageError)
+	}
+	c.Set(fiber.HeaderContentType, "image/webp")
+	return c.Status(http.StatusOK).Send(webp.Bytes())
+}
@@ -0,0 +1,168 @@
+package routes
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"api/errors"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var errHashBlocked = goerrors.New("hash is already blocked")
+
+// PostBlockedHash blocks the image of a moderation decision or a hash computed elsewhere
+type PostBlockedHash struct {
+	DecisionID string `json:"decision_id" validate:"required_without=Hash,excluded_with=Hash"`
+	Hash       string `json:"hash" validate:"omitempty,hexadecimal,len=16"`
+	Reason     string `json:"reason" validate:"max=1000"`
+}
+
+func toApiBlockedHash(blocked structs.BlockedHash) structs.ApiBlockedHash {
+	return structs.ApiBlockedHash{
+		ID:         blocked.ID,
+		Hash:       blocked.Hash,
+		DecisionID: blocked.DecisionID,
+		Reason:     blocked.Reason,
+		CreatedAt:  blocked.CreatedAt,
+	}
+}
+
+// addBlockedHash stores hash unless it is already blocked
+func addBlockedHash(hash string, decisionId *string, reason string) (structs.BlockedHash, error) {
+	blocked := structs.BlockedHash{
+		ID:         generator.Generate().String(),
+		Hash:       strings.ToLower(hash),
+		DecisionID: decisionId,
+		Reason:     reason,
+	}
+	var count int64
+	if err := db.Model(&structs.BlockedHash{}).Where("hash = ?", blocked.Hash).Count(&count).Error; err != nil {
+		return blocked, err
+	}
+	if count > 0 {
+		return blocked, errHashBlocked
+	}
+	err := db.Create(&blocked).Error
+	if err == gorm.ErrDuplicatedKey {
+		return blocked, errHashBlocked
+	} else if err != nil {
+		return blocked, err
+	}
+	blocklistChanged()
+	return blocked, nil
+}
+
+// blockDecision blocks the image a decision was made on, and the upload it was cropped from
+func blockDecision(decision structs.ModerationDecision, reason string) (structs.BlockedHash, error) {
+	blocked, err := addBlockedHash(decision.Hash, &decision.ID, reason)
+	if err != nil && err != errHashBlocked {
+		return blocked, err
+	}
+	if decision.SourceHash != "" && decision.SourceHash != decision.Hash {
+		source, sourceErr := addBlockedHash(decision.SourceHash, &decision.ID, reason)
+		if sourceErr == nil && err == errHashBlocked {
+			// Only the source was new
+			return source, nil
+		} else if sourceErr != nil && sourceErr != errHashBlocked {
+			return blocked, sourceErr
+		}
+	}
+	return blocked, err
+}
+
+// blocklistChanged makes every process reload the blocklist, see database.BlockedHashes
+func blocklistChanged() {
+	if err := rdb.Incr(ctx, "blocklist:version").Err(); err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+func getBlocklist(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	limit, err, rtrn := queryLimit(c)
+	if rtrn {
+		return err
+	}
+	query := db.Model(&structs.BlockedHash{}).Order("id desc").Limit(limit)
+	if before := c.Query("before"); before != "" {
+		query = query.Where("id < ?", before)
+	}
+	var hashes []structs.BlockedHash
+	if err := query.Find(&hashes).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiBlockedHash, 0, len(hashes))
+	for _, blocked := range hashes {
+		result = append(result, toApiBlockedHash(blocked))
+	}
+	return c.JSON(result)
+}
+
+func postBlocklist(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	var body PostBlockedHash
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+
+	var blocked structs.BlockedHash
+	var err error
+	if body.DecisionID != "" {
+		var decision structs.ModerationDecision
+		err = db.Where(&structs.ModerationDecision{ID: body.DecisionID}).First(&decision).Error
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+		} else if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		// Decisions recorded before hashing was added have nothing to block
+		if decision.Hash == "" {
+			return c.Status(http.StatusBadRequest).JSON(errors.HashMissing)
+		}
+		blocked, err = blockDecision(decision, body.Reason)
+	} else {
+		blocked, err = addBlockedHash(body.Hash, nil, body.Reason)
+	}
+	if err == errHashBlocked {
+		return c.Status(http.StatusConflict).JSON(errors.HashBlocked)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusCreated).JSON(toApiBlockedHash(blocked))
+}
+
+func deleteBlocklist(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	result := db.Where(&structs.BlockedHash{ID: id}).Delete(&structs.BlockedHash{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	blocklistChanged()
+	return c.Status(http.StatusNoContent).Send(nil)
+}
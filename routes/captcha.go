@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"api/errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Route names accepted in CAPTCHA_ROUTES
+const (
+	captchaUsers    = "users"
+	captchaSessions = "sessions"
+	captchaReset    = "reset"
+	captchaVerify   = "verify"
+)
+
+const captchaFailureWindow = 15 * time.Minute
+
+func captchaFailureKey(route string, subject string) string {
+	return "captcha:" + route + ":" + subject
+}
+
+// Emails sent to an address are counted apart from failures, repeated
+// successful requests would otherwise never require a captcha
+func captchaSendKey(route string, subject string) string {
+	return "captcha:sent:" + route + ":" + subject
+}
+
+// checkCaptcha requires a valid X-Captcha-Token when route is always enforced
+// or when subject (an ip or email) has reached the threshold of failures or
+// emails sent. ip is the client ip passed on to the provider, behind the
+// frontend the caller ip is the proxy so an empty ip leaves it out.
+func checkCaptcha(c *fiber.Ctx, route string, subject string, ip string) (error, bool) {
+	if captchaVerifier == nil {
+		return nil, false
+	}
+	required := captchaRoutes[route]
+	if !required && captchaThreshold > 0 {
+		for _, key := range []string{captchaFailureKey(route, subject), captchaSendKey(route, subject)} {
+			count, err := rdb.Get(ctx, key).Int()
+			if err == nil && count >= captchaThreshold {
+				required = true
+			}
+		}
+	}
+	if !required {
+		return nil, false
+	}
+
+	token := c.Get("X-Captcha-Token")
+	if token == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.CaptchaRequired), true
+	}
+	valid, err := captchaVerifier.Verify(ctx, token, ip)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerCaptchaError), true
+	}
+	if !valid {
+		return c.Status(http.StatusBadRequest).JSON(errors.CaptchaInvalid), true
+	}
+	return nil, false
+}
+
+// forwardedIp is the client ip a request names, or empty
+func forwardedIp(ip *string) string {
+	if ip == nil {
+		return ""
+	}
+	return *ip
+}
+
+// recordFailure counts a failed attempt towards the adaptive threshold
+func recordFailure(route string, subject string) {
+	countCaptcha(captchaFailureKey(route, subject))
+}
+
+// recordSend counts an email sent to subject towards the adaptive threshold
+func recordSend(route string, subject string) {
+	countCaptcha(captchaSendKey(route, subject))
+}
+
+func countCaptcha(key string) {
+	if captchaVerifier == nil || captchaThreshold <= 0 {
+		return
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, captchaFailureWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+func clearFailures(route string, subject string) {
+	if captchaVerifier == nil {
+		return
+	}
+	rdb.Del(ctx, captchaFailureKey(route, subject))
+}
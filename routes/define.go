@@ -5,12 +5,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"api/captcha"
 	"api/email"
+	"api/errors"
 	"api/structs"
 
-	"code.gitea.io/sdk/gitea"
 	"github.com/bwmarrin/snowflake"
+	"github.com/bytedance/sonic"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
@@ -24,7 +29,19 @@ var (
 	sender    *email.EmailSender
 	generator *snowflake.Node
 	_validate *XValidator
-	git       *gitea.Client
+
+	invitesPerUser int
+
+	captchaVerifier  captcha.Verifier
+	captchaRoutes    = map[string]bool{}
+	captchaThreshold int
+
+	avatarMaxBytes     int64
+	avatarMaxDimension int
+
+	assetMaxBytes     int64
+	assetContentTypes []string
+	uploadExpiry      time.Duration
 
 	ctx      = context.Background()
 	validate = validator.New()
@@ -48,12 +65,12 @@ type (
 	}
 )
 
-func DefineRoutes(r *fiber.App, database *gorm.DB, redisDatabase *redis.Client, environment *structs.Environment, emailSender *email.EmailSender, gitClient *gitea.Client) {
+func DefineRoutes(r *fiber.App, database *gorm.DB, redisDatabase *redis.Client, environment *structs.Environment, emailSender *email.EmailSender, verifier captcha.Verifier) {
 	db = database
 	rdb = redisDatabase
 	env = environment
 	sender = emailSender
-	git = gitClient
+	captchaVerifier = verifier
 	snowflake.Epoch = 1697015375
 	node, err := snowflake.NewNode(1)
 	if err != nil {
@@ -62,9 +79,51 @@ func DefineRoutes(r *fiber.App, database *gorm.DB, redisDatabase *redis.Client,
 	}
 	generator = node
 
+	switch env.RegistrationMode {
+	case registrationOpen, registrationInvite, registrationDomain:
+	default:
+		log.Fatal("unknown registration mode " + env.RegistrationMode)
+	}
+	invitesPerUser, err = strconv.Atoi(env.InvitesPerUser)
+	if err != nil {
+		log.Fatal("failed to convert INVITES_PER_USER to integer")
+	}
+	for _, route := range strings.Split(env.CaptchaRoutes, ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			captchaRoutes[route] = true
+		}
+	}
+	captchaThreshold, err = strconv.Atoi(env.CaptchaFailureThreshold)
+	if err != nil {
+		log.Fatal("failed to convert CAPTCHA_FAILURE_THRESHOLD to integer")
+	}
+	avatarMaxBytes, err = strconv.ParseInt(env.AvatarMaxBytes, 10, 64)
+	if err != nil {
+		log.Fatal("failed to convert AVATAR_MAX_BYTES to integer")
+	}
+	avatarMaxDimension, err = strconv.Atoi(env.AvatarMaxDimension)
+	if err != nil {
+		log.Fatal("failed to convert AVATAR_MAX_DIMENSION to integer")
+	}
+	assetMaxBytes, err = strconv.ParseInt(env.AssetMaxBytes, 10, 64)
+	if err != nil {
+		log.Fatal("failed to convert ASSET_MAX_BYTES to integer")
+	}
+	for _, contentType := range strings.Split(env.AssetContentTypes, ",") {
+		if contentType = strings.ToLower(strings.TrimSpace(contentType)); contentType != "" {
+			assetContentTypes = append(assetContentTypes, contentType)
+		}
+	}
+	uploadExpiry, err = time.ParseDuration(env.UploadExpiry)
+	if err != nil {
+		log.Fatal("failed to parse UPLOAD_EXPIRY as a duration")
+	}
+
 	_validate = &XValidator{
 		validator: validate,
 	}
+	validate.RegisterValidation("username", validateUsername)
+	validate.RegisterValidation("slug", validateSlug)
 
 	v1 := r.Group("/api/v1")
 	users := v1.Group("/users")
@@ -78,12 +137,30 @@ func DefineRoutes(r *fiber.App, database *gorm.DB, redisDatabase *redis.Client,
 	users.Delete("/sessions/:token", deleteSession)
 	users.Put("/sessions/:totp", confirm2faSignIn)
 
+	users.Get("/usernames/:username", getProfile)
+	users.Get("/usernames/:username/available", getUsernameAvailable)
+
 	users.Get("/me", getMe)
+	users.Patch("/me/profile", patchProfile)
 	users.Put("/me/email", putEmail)
 	users.Put("/me/password", putPassword)
 	users.Delete("/me", deleteMe)
 	users.Put("/me/avatar", putAvatar)
 	users.Delete("/me/avatar", deleteAvatar)
+	users.Post("/me/avatar/uploads", postAvatarUpload)
+	users.Post("/me/avatar/uploads/:id", completeAvatarUpload)
+	users.Get("/:id/avatar", getAvatar)
+	users.Get("/me/moderation", getMyModeration)
+	users.Get("/me/invitations", getMyInvitations)
+	users.Post("/me/invitations/:id/accept", acceptInvitation)
+	users.Delete("/me/invitations/:id", declineInvitation)
+	users.Get("/me/organization-invitations", getMyOrganizationInvitations)
+	users.Post("/me/organization-invitations/:id/accept", acceptOrganizationInvitation)
+	users.Delete("/me/organization-invitations/:id", declineOrganizationInvitation)
+	users.Get("/me/transfers", getMyTransfers)
+	users.Post("/me/transfers/:id/accept", acceptTransfer)
+	users.Post("/me/transfers/:id/decline", declineTransfer)
+	users.Post("/me/moderation/:id/appeal", postAppeal)
 
 	users.Post("/verify", postVerify)
 	users.Put("/verify/:token", putVerify)
@@ -91,10 +168,55 @@ func DefineRoutes(r *fiber.App, database *gorm.DB, redisDatabase *redis.Client,
 	users.Post("/reset", postReset)
 	users.Put("/reset/:token", putReset)
 
+	users.Post("/invites", postInvite)
+	users.Get("/invites", getInvites)
+	users.Delete("/invites/:code", deleteInvite)
+
 	users.Post("/totp", setUp2FA)
 	users.Put("/totp/:code", verify2fa)
 	users.Delete("/totp", remove2fa)
 
+	v1.Get("/storage/:purpose/*", getStorageObject)
+	v1.Put("/storage/:purpose/*", putStorageObject)
+
+	moderation := v1.Group("/moderation")
+	moderation.Get("/decisions", getModerationDecisions)
+	moderation.Get("/queue", getModerationQueue)
+	moderation.Get("/decisions/:id/image", getDecisionImage)
+	moderation.Post("/decisions/:id/approve", approveDecision)
+	moderation.Post("/decisions/:id/reject", rejectDecision)
+	moderation.Get("/blocklist", getBlocklist)
+	moderation.Post("/blocklist", postBlocklist)
+	moderation.Delete("/blocklist/:id", deleteBlocklist)
+
+	templates := v1.Group("/templates")
+	templates.Get("/", getTemplates)
+	templates.Post("/", postTemplate)
+	templates.Get("/:name", getTemplate)
+	templates.Patch("/:name", patchTemplate)
+	templates.Delete("/:name", deleteTemplate)
+
+	organizations := v1.Group("/organizations")
+	organizations.Get("/", getOrganizations)
+	organizations.Post("/", postOrganization)
+	organizations.Get("/:id", getOrganization)
+	organizations.Patch("/:id", patchOrganization)
+	organizations.Delete("/:id", deleteOrganization)
+	organizations.Get("/:id/projects", getOrganizationProjects)
+	organizations.Get("/:id/members", getOrganizationMembers)
+	organizations.Patch("/:id/members/:user", patchOrganizationMember)
+	organizations.Delete("/:id/members/:user", deleteOrganizationMember)
+	organizations.Get("/:id/invitations", getOrganizationInvitations)
+	organizations.Post("/:id/invitations", postOrganizationInvitation)
+	organizations.Delete("/:id/invitations/:invitation", deleteOrganizationInvitation)
+	organizations.Get("/:id/teams", getTeams)
+	organizations.Post("/:id/teams", postTeam)
+	organizations.Delete("/:id/teams/:team", deleteTeam)
+	organizations.Put("/:id/teams/:team/members/:user", putTeamMember)
+	organizations.Delete("/:id/teams/:team/members/:user", deleteTeamMember)
+	organizations.Put("/:id/teams/:team/projects/:project", putTeamProject)
+	organizations.Delete("/:id/teams/:team/projects/:project", deleteTeamProject)
+
 	projects := v1.Group("/projects")
 
 	projects.Get("/", getProjects)
@@ -103,7 +225,26 @@ func DefineRoutes(r *fiber.App, database *gorm.DB, redisDatabase *redis.Client,
 	projects.Get("/:id/file", getFile)
 	projects.Patch("/files", updateContents)
 	projects.Get("/:id/files", getContents)
+	projects.Get("/:id/assets", getAssets)
+	projects.Post("/:id/assets/uploads", postAssetUpload)
+	projects.Post("/:id/assets/uploads/:upload", completeAssetUpload)
+	projects.Get("/:id/assets/:asset", getAsset)
+	projects.Delete("/:id/assets/:asset", deleteAsset)
+	projects.Put("/:id/organization", putProjectOrganization)
+	projects.Delete("/:id/organization", deleteProjectOrganization)
+	projects.Get("/:id/transfers", getProjectTransfers)
+	projects.Post("/:id/transfers", postProjectTransfer)
+	projects.Delete("/:id/transfers/:transfer", cancelProjectTransfer)
+	projects.Post("/:id/promote", promoteProject)
+	projects.Get("/:id/promotions", getPromotions)
+	projects.Get("/:id/members", getMembers)
+	projects.Patch("/:id/members/:user", patchMember)
+	projects.Delete("/:id/members/:user", deleteMember)
+	projects.Get("/:id/invitations", getProjectInvitations)
+	projects.Post("/:id/invitations", postProjectInvitation)
+	projects.Delete("/:id/invitations/:invitation", deleteProjectInvitation)
 	projects.Get("/:id", getProject)
+	projects.Patch("/:id", patchProject)
 	projects.Delete("/:id", deleteProject)
 }
 
@@ -113,6 +254,34 @@ func emailAvailable(email string) (bool, structs.User) {
 	return found == gorm.ErrRecordNotFound, user
 }
 
+// isAdmin reports whether the request was made with the global key
+func isAdmin(c *fiber.Ctx) bool {
+	return c.Get("Authorization") == env.ApiAuthentication
+}
+
+// getSession loads the session belonging to the Authorization header.
+// When it fails the error response has already been written and should be returned.
+func getSession(c *fiber.Ctx) (structs.Session, error, bool) {
+	var parsed structs.Session
+	authorization := c.Get("Authorization")
+	if authorization == "" {
+		return parsed, c.Status(401).JSON(errors.AuthorizationMissing), true
+	}
+	session, err := rdb.Get(ctx, "session:"+authorization).Result()
+	if err == redis.Nil {
+		return parsed, c.Status(401).JSON(errors.AuthorizationInvalid), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return parsed, c.Status(500).JSON(errors.ServerRedisError), true
+	}
+	err = sonic.UnmarshalString(session, &parsed)
+	if err != nil {
+		fmt.Println(err.Error())
+		return parsed, c.Status(500).JSON(errors.ServerParseError), true
+	}
+	return parsed, nil, false
+}
+
 func handleValidateErrors(errs []ErrorResponse, c *fiber.Ctx) (error, bool) {
 	if len(errs) > 0 {
 		errMsgs := make([]string, 0)
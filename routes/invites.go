@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"api/errors"
+	"api/structs"
+	"api/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type PostInvite struct {
+	ExpiresIn int `json:"expires_in" validate:"omitempty,min=1,max=720"`
+}
+
+// postInvite creates a single use invite code. Admins use the global key and
+// are not limited, users may hold invitesPerUser unused codes at once.
+func postInvite(c *fiber.Ctx) error {
+	var createdBy *string
+	if !isAdmin(c) {
+		parsed, err, rtrn := getSession(c)
+		if rtrn {
+			return err
+		}
+		createdBy = &parsed.UserID
+	}
+
+	var body PostInvite
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(errors.MalformedBody(err))
+		}
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	if body.ExpiresIn == 0 {
+		body.ExpiresIn = 72
+	}
+
+	if createdBy != nil {
+		var active int64
+		err := db.Model(&structs.Invite{}).Where("created_by = ? AND used_by IS NULL AND expires_at > ?", *createdBy, time.Now()).Count(&active).Error
+		if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		if active >= int64(invitesPerUser) {
+			return c.Status(http.StatusBadRequest).JSON(errors.InviteLimitReached)
+		}
+	}
+
+	code, err := utils.RandString(32)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerTokenGenerate)
+	}
+	invite := structs.Invite{Code: code, CreatedBy: createdBy, ExpiresAt: time.Now().Add(time.Duration(body.ExpiresIn) * time.Hour)}
+	if err := db.Create(&invite).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusCreated).JSON(structs.ApiInvite{Code: invite.Code, ExpiresAt: invite.ExpiresAt, CreatedAt: invite.CreatedAt})
+}
+func getInvites(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var invites []structs.ApiInvite
+	err = db.Model(&structs.Invite{}).Where("created_by = ?", parsed.UserID).Order("created_at desc").Find(&invites).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(invites)
+}
+func deleteInvite(c *fiber.Ctx) error {
+	code := c.Params("code")
+	if code == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	query := db.Where("code = ? AND used_by IS NULL", code)
+	if !isAdmin(c) {
+		parsed, err, rtrn := getSession(c)
+		if rtrn {
+			return err
+		}
+		query = query.Where("created_by = ?", parsed.UserID)
+	}
+	result := query.Delete(&structs.Invite{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
@@ -0,0 +1,241 @@
+package routes
+
+import (
+	goerrors "errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"api/git"
+	"api/structs"
+
+	"github.com/bytedance/sonic"
+)
+
+// manifestPath is the file in a template repository declaring its variables.
+// It is removed from projects created from the template.
+const manifestPath = "runik.template.json"
+
+// Files larger than this are copied without substitution
+const renderMaxBytes = 1 << 20
+
+var errManifestInvalid = goerrors.New("template manifest is invalid")
+
+var (
+	variableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	// {{ name }} with optional spaces
+	placeholderPattern = regexp.MustCompile(`\{\{\s*([a-z_][a-z0-9_]*)\s*\}\}`)
+)
+
+// Variables every template can use, they cannot be declared or set
+var builtinVariables = []string{"project_name", "project_id", "author", "author_username", "year"}
+
+type templateManifest struct {
+	Variables []structs.TemplateVariable `json:"variables"`
+	// Files matching one of these globs are copied without substitution.
+	// A glob without a slash matches file names in any directory.
+	Exclude []string `json:"exclude"`
+
+	patterns map[string]*regexp.Regexp
+}
+
+// loadManifest reads the manifest of a template at ref, nil when the template has none
+func loadManifest(provider git.GitProvider, repo git.Repo, ref string) (*templateManifest, error) {
+	content, err := provider.ReadFile(ctx, repo, ref, manifestPath)
+	if git.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var manifest templateManifest
+	if err := sonic.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %s", errManifestInvalid, err.Error())
+	}
+
+	manifest.patterns = map[string]*regexp.Regexp{}
+	seen := map[string]bool{}
+	for _, variable := range manifest.Variables {
+		if !variableNamePattern.MatchString(variable.Name) || len(variable.Name) > 64 {
+			return nil, fmt.Errorf("%w: invalid variable name %q", errManifestInvalid, variable.Name)
+		}
+		if contains(builtinVariables, variable.Name) {
+			return nil, fmt.Errorf("%w: %s is a builtin variable", errManifestInvalid, variable.Name)
+		}
+		if seen[variable.Name] {
+			return nil, fmt.Errorf("%w: variable %s is declared twice", errManifestInvalid, variable.Name)
+		}
+		seen[variable.Name] = true
+		if variable.Pattern != "" {
+			pattern, err := regexp.Compile("^(?:" + variable.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%w: pattern of %s: %s", errManifestInvalid, variable.Name, err.Error())
+			}
+			manifest.patterns[variable.Name] = pattern
+		}
+	}
+	for _, glob := range manifest.Exclude {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("%w: exclude %q: %s", errManifestInvalid, glob, err.Error())
+		}
+	}
+	return &manifest, nil
+}
+
+// resolve combines the values given for a project with the defaults of the
+// manifest and the builtin variables. Problems are keyed by variable name.
+func (m *templateManifest) resolve(values map[string]string, builtins map[string]string) (map[string]string, map[string]string) {
+	resolved := map[string]string{}
+	for name, value := range builtins {
+		resolved[name] = value
+	}
+	problems := map[string]string{}
+	declared := map[string]bool{}
+	for _, variable := range m.Variables {
+		declared[variable.Name] = true
+		value, ok := values[variable.Name]
+		if !ok || value == "" {
+			if variable.Required {
+				problems[variable.Name] = "required"
+				continue
+			}
+			// Defaults may refer to builtin variables, like {{ project_name }}
+			value = substitute(variable.Default, builtins)
+		}
+		if pattern := m.patterns[variable.Name]; pattern != nil && value != "" && !pattern.MatchString(value) {
+			problems[variable.Name] = "pattern"
+			continue
+		}
+		resolved[variable.Name] = value
+	}
+	for name := range values {
+		if !declared[name] {
+			problems[name] = "unknown"
+		}
+	}
+	return resolved, problems
+}
+
+func (m *templateManifest) excluded(file string) bool {
+	for _, glob := range m.Exclude {
+		if matched, _ := path.Match(glob, file); matched {
+			return true
+		}
+		if matched, _ := path.Match(glob, path.Base(file)); matched && !strings.Contains(glob, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// render substitutes variables in the paths and text files of the repository
+// at branch and removes the manifest, all in one commit. A path that renders
+// to something invalid is reported as git.ErrInvalid.
+func (m *templateManifest) render(provider git.GitProvider, repo git.Repo, branch string, variables map[string]string, authorName string, authorEmail string) error {
+	tree, err := provider.ReadTree(ctx, repo, branch)
+	if err != nil {
+		return err
+	}
+	changes := []git.FileChange{{Path: manifestPath, Delete: true}}
+	// Two files rendering to the same path would overwrite each other
+	targets := map[string]bool{manifestPath: true}
+	for _, entry := range tree {
+		if entry.Type != git.Blob || entry.Path == manifestPath {
+			continue
+		}
+		target := substitute(entry.Path, variables)
+		if !git.ValidPath(target) || targets[target] {
+			return git.ErrInvalid
+		}
+		targets[target] = true
+	}
+	for _, entry := range tree {
+		if entry.Type != git.Blob || entry.Path == manifestPath {
+			continue
+		}
+		target := substitute(entry.Path, variables)
+		skipContent := m.excluded(entry.Path) || entry.Size > renderMaxBytes
+		if target == entry.Path && skipContent {
+			continue
+		}
+
+		content, err := provider.ReadFile(ctx, repo, branch, entry.Path)
+		if err != nil {
+			return err
+		}
+		rendered := content
+		// Binary files are copied as they are
+		if !skipContent && utf8.Valid(content) {
+			rendered = []byte(renderContent(entry.Path, string(content), variables))
+		}
+		if target != entry.Path {
+			changes = append(changes, git.FileChange{Path: entry.Path, Delete: true})
+		} else if string(rendered) == string(content) {
+			continue
+		}
+		changes = append(changes, git.FileChange{Path: target, Content: rendered})
+	}
+
+	_, err = provider.Commit(ctx, repo, git.Commit{
+		Branch:      branch,
+		Message:     "Fill in template variables",
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+		Changes:     changes,
+	})
+	return err
+}
+
+// substitute replaces {{ name }} with the value of every known variable,
+// unknown placeholders are left as they are
+func substitute(text string, variables map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		if value, ok := variables[name]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// renderContent substitutes variables in the content of file. Placeholders in
+// JSON files sit inside strings, so the values are escaped for them.
+func renderContent(file string, content string, variables map[string]string) string {
+	if !strings.EqualFold(path.Ext(file), ".json") {
+		return substitute(content, variables)
+	}
+	escaped := make(map[string]string, len(variables))
+	for name, value := range variables {
+		encoded, err := sonic.Marshal(value)
+		if err != nil {
+			continue
+		}
+		escaped[name] = string(encoded[1 : len(encoded)-1])
+	}
+	return substitute(content, escaped)
+}
+
+// projectBuiltins are the values of the builtin variables for a new project
+func projectBuiltins(projectId string, name string, author structs.User) map[string]string {
+	authorName := author.DisplayName
+	username := ""
+	if author.Username != nil {
+		username = *author.Username
+	}
+	if authorName == "" {
+		authorName = username
+	}
+	if authorName == "" {
+		authorName = author.ID
+	}
+	return map[string]string{
+		"project_name":    name,
+		"project_id":      projectId,
+		"author":          authorName,
+		"author_username": username,
+		"year":            strconv.Itoa(time.Now().Year()),
+	}
+}
@@ -0,0 +1,173 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"api/git"
+)
+
+// memoryRepo serves the files of one branch and records the commit made to it,
+// the provider methods rendering does not use are left unimplemented
+type memoryRepo struct {
+	git.GitProvider
+	files  map[string]string
+	commit *git.Commit
+}
+
+func (m *memoryRepo) ReadTree(ctx context.Context, repo git.Repo, ref string) ([]git.TreeEntry, error) {
+	var tree []git.TreeEntry
+	for file, content := range m.files {
+		tree = append(tree, git.TreeEntry{Path: file, Type: git.Blob, Size: int64(len(content))})
+	}
+	return tree, nil
+}
+
+func (m *memoryRepo) ReadFile(ctx context.Context, repo git.Repo, ref string, file string) ([]byte, error) {
+	content, ok := m.files[file]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", git.ErrNotFound, file)
+	}
+	return []byte(content), nil
+}
+
+func (m *memoryRepo) Commit(ctx context.Context, repo git.Repo, commit git.Commit) (string, error) {
+	m.commit = &commit
+	return "rendered", nil
+}
+
+// changes lists the commit as "delete path" and "write path: content"
+func (m *memoryRepo) changes() []string {
+	var changes []string
+	for _, change := range m.commit.Changes {
+		if change.Delete {
+			changes = append(changes, "delete "+change.Path)
+		} else {
+			changes = append(changes, "write "+change.Path+": "+string(change.Content))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func testManifest(t *testing.T, manifest string) *templateManifest {
+	t.Helper()
+	loaded, err := loadManifest(&memoryRepo{files: map[string]string{manifestPath: manifest}}, git.Repo{}, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestLoadManifest(t *testing.T) {
+	if manifest, err := loadManifest(&memoryRepo{files: map[string]string{}}, git.Repo{}, "prod"); manifest != nil || err != nil {
+		t.Fatalf("missing manifest = %v, %v, want none", manifest, err)
+	}
+	invalid := []string{
+		`{"variables": [`,
+		`{"variables": [{"name": "Module"}]}`,
+		`{"variables": [{"name": "project_name"}]}`,
+		`{"variables": [{"name": "module"}, {"name": "module"}]}`,
+		`{"variables": [{"name": "module", "pattern": "("}]}`,
+		`{"exclude": ["["]}`,
+	}
+	for _, manifest := range invalid {
+		_, err := loadManifest(&memoryRepo{files: map[string]string{manifestPath: manifest}}, git.Repo{}, "prod")
+		if !errors.Is(err, errManifestInvalid) {
+			t.Fatalf("manifest %s returned %v, want errManifestInvalid", manifest, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	manifest := testManifest(t, `{"variables": [
+		{"name": "module", "required": true, "pattern": "[a-z/.]+"},
+		{"name": "title", "default": "{{ project_name }} by {{ author }}"},
+		{"name": "port", "default": "8080", "pattern": "[0-9]+"}
+	]}`)
+	builtins := map[string]string{"project_name": "Shop", "author": "Ada"}
+
+	resolved, problems := manifest.resolve(map[string]string{"module": "example.com/shop"}, builtins)
+	want := map[string]string{"project_name": "Shop", "author": "Ada", "module": "example.com/shop", "title": "Shop by Ada", "port": "8080"}
+	if len(problems) != 0 || !reflect.DeepEqual(resolved, want) {
+		t.Fatalf("resolve = %v, %v", resolved, problems)
+	}
+
+	_, problems = manifest.resolve(map[string]string{"port": "http", "color": "red"}, builtins)
+	want = map[string]string{"module": "required", "port": "pattern", "color": "unknown"}
+	if !reflect.DeepEqual(problems, want) {
+		t.Fatalf("problems = %v, want %v", problems, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	manifest := testManifest(t, `{"exclude": ["*.png", "docs/raw.md"]}`)
+	repo := &memoryRepo{files: map[string]string{
+		manifestPath:                          "{}",
+		"README.md":                           "# {{ project_name }}\n",
+		"cmd/{{ module }}/main.go":            "package {{module}}\n",
+		"package.json":                        `{"name": "{{ project_name }}", "description": "{{ description }}"}`,
+		"logo.png":                            "{{ project_name }}",
+		"docs/raw.md":                         "{{ project_name }}",
+		"docs/{{ unknown }}.md":               "{{ unknown }}",
+		"unchanged.txt":                       "nothing to fill in",
+		"binary.bin":                          "\xff{{ project_name }}",
+		"{{ project_name }}/{{ module }}.txt": "{{ description }}",
+	}}
+	variables := map[string]string{"project_name": "shop", "module": "api", "description": `a "quoted" \ value`}
+	if err := manifest.render(repo, git.Repo{}, "prod", variables, "Ada", "ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"delete cmd/{{ module }}/main.go",
+		"delete " + manifestPath,
+		"delete {{ project_name }}/{{ module }}.txt",
+		"write README.md: # shop\n",
+		"write cmd/api/main.go: package api\n",
+		`write package.json: {"name": "shop", "description": "a \"quoted\" \\ value"}`,
+		`write shop/api.txt: a "quoted" \ value`,
+	}
+	if got := repo.changes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if repo.commit.Branch != "prod" || repo.commit.AuthorName != "Ada" || repo.commit.AuthorEmail != "ada@example.com" {
+		t.Fatalf("commit = %+v", repo.commit)
+	}
+}
+
+func TestRenderPathCollisions(t *testing.T) {
+	manifest := testManifest(t, `{}`)
+	collisions := []map[string]string{
+		{"{{ name }}.txt": "a", "shop.txt": "b"},
+		{"{{ name }}.txt": "a", "{{ other }}.txt": "b"},
+		{"{{ manifest }}": "a"},
+		{"{{ parent }}/escape.txt": "a"},
+	}
+	variables := map[string]string{"name": "shop", "other": "shop", "manifest": manifestPath, "parent": ".."}
+	for _, files := range collisions {
+		repo := &memoryRepo{files: files}
+		if err := manifest.render(repo, git.Repo{}, "prod", variables, "", ""); !errors.Is(err, git.ErrInvalid) {
+			t.Fatalf("rendering %v returned %v, want git.ErrInvalid", files, err)
+		}
+		if repo.commit != nil {
+			t.Fatalf("rendering %v committed", files)
+		}
+	}
+}
+
+func TestRenderContent(t *testing.T) {
+	variables := map[string]string{"value": "line\n\"quoted\"\t\\"}
+	if got := renderContent("notes.txt", "{{ value }}", variables); got != variables["value"] {
+		t.Fatalf("text file = %q", got)
+	}
+	for _, file := range []string{"config.json", "CONFIG.JSON"} {
+		if got := renderContent(file, `{"v": "{{ value }}"}`, variables); got != `{"v": "line\n\"quoted\"\t\\"}` {
+			t.Fatalf("%s = %q", file, got)
+		}
+	}
+}
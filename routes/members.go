@@ -0,0 +1,586 @@
+package routes
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"api/errors"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Roles on a project, each one can do everything the ones before it can.
+// The owner is the user the project belongs to, or an owner of its
+// organization, and is never a ProjectMember.
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleAdmin  = "admin"
+	roleOwner  = "owner"
+)
+
+var roleRanks = map[string]int{roleViewer: 1, roleEditor: 2, roleAdmin: 3, roleOwner: 4}
+
+// How long an invitation to a project can be accepted
+const projectInvitationExpiry = 7 * 24 * time.Hour
+
+var errInvitationRevoked = goerrors.New("invitation was revoked")
+
+// ownsProject reports whether a user owns a project themselves, rather than through an organization
+func ownsProject(project structs.Project, userId string) bool {
+	return project.OrganizationID == nil && project.UserID == userId
+}
+
+// canGrant reports whether a user holding role can give granted to someone else.
+// Admins grant viewer and editor, only the owner grants admin.
+func canGrant(role string, granted string) bool {
+	return roleRanks[granted] < roleRanks[role]
+}
+
+// projectRole returns the role of a user on a project, empty without access.
+// For organization projects it is the highest of the organization role, the
+// roles of the user's teams and a direct membership.
+func projectRole(project structs.Project, userId string) (string, error) {
+	if ownsProject(project, userId) {
+		return roleOwner, nil
+	}
+	role := ""
+	if project.OrganizationID != nil {
+		organization, err := organizationRole(*project.OrganizationID, userId)
+		if err != nil {
+			return "", err
+		}
+		switch organization {
+		case orgRoleOwner:
+			return roleOwner, nil
+		case orgRoleAdmin:
+			role = roleAdmin
+		case orgRoleMember:
+			if role, err = teamProjectRole(project.ID, userId); err != nil {
+				return "", err
+			}
+		}
+	}
+	var member structs.ProjectMember
+	err := db.Where(&structs.ProjectMember{ProjectID: project.ID, UserID: userId}).First(&member).Error
+	if err == gorm.ErrRecordNotFound {
+		return role, nil
+	} else if err != nil {
+		return "", err
+	}
+	if roleRanks[member.Role] > roleRanks[role] {
+		role = member.Role
+	}
+	return role, nil
+}
+
+// getProjectAccess loads a project the user holds at least role on and returns their role.
+// When it fails the error response has already been written and should be returned.
+func getProjectAccess(c *fiber.Ctx, projectId string, userId string, role string) (structs.Project, string, error, bool) {
+	var project structs.Project
+	err := db.Where(&structs.Project{ID: projectId}).First(&project).Error
+	if err == gorm.ErrRecordNotFound {
+		return project, "", c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return project, "", c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	held, err := projectRole(project, userId)
+	if err != nil {
+		fmt.Println(err.Error())
+		return project, "", c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	// Every signed in user can view public projects
+	if held == "" && project.Visibility == visibilityPublic {
+		held = roleViewer
+	}
+	if roleRanks[held] < roleRanks[role] {
+		return project, held, c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess), true
+	}
+	return project, held, nil, false
+}
+
+// projectMembers returns the IDs of every member of a project, without the owner
+func projectMembers(projectId string) ([]string, error) {
+	var members []string
+	err := db.Model(&structs.ProjectMember{}).Where("project_id = ?", projectId).Pluck("user_id", &members).Error
+	return members, err
+}
+
+// removeProjectRows deletes the members, team grants, invitations, transfers
+// and promotions of a project that is being deleted
+func removeProjectRows(tx *gorm.DB, projectId string) error {
+	for _, model := range []interface{}{&structs.ProjectMember{}, &structs.TeamProject{}, &structs.ProjectInvitation{}, &structs.ProjectTransfer{}, &structs.Promotion{}} {
+		if err := tx.Where("project_id = ?", projectId).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUserMemberships removes the project, organization and team memberships
+// and the invitations of a deleted user
+func removeUserMemberships(userId string) {
+	for _, model := range []interface{}{&structs.ProjectMember{}, &structs.ProjectInvitation{}, &structs.OrganizationMember{}, &structs.OrganizationInvitation{}, &structs.TeamMember{}} {
+		if err := db.Where("user_id = ?", userId).Delete(model).Error; err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+}
+
+func toApiProjectMember(member structs.ProjectMember) structs.ApiProjectMember {
+	return structs.ApiProjectMember{
+		ProjectID: member.ProjectID,
+		UserID:    member.UserID,
+		Role:      member.Role,
+		InvitedBy: member.InvitedBy,
+		CreatedAt: member.CreatedAt,
+		UpdatedAt: member.UpdatedAt,
+	}
+}
+func toApiProjectInvitation(invitation structs.ProjectInvitation) structs.ApiProjectInvitation {
+	return structs.ApiProjectInvitation{
+		ID:        invitation.ID,
+		ProjectID: invitation.ProjectID,
+		UserID:    invitation.UserID,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		InvitedBy: invitation.InvitedBy,
+		ExpiresAt: invitation.ExpiresAt,
+		CreatedAt: invitation.CreatedAt,
+	}
+}
+
+// getMembers lists the owner followed by the members of a project. Organization
+// projects list their direct members only.
+func getMembers(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	project, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleViewer)
+	if rtrn {
+		return err
+	}
+	var members []structs.ProjectMember
+	if err := db.Where(&structs.ProjectMember{ProjectID: projectId}).Order("created_at").Find(&members).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := []structs.ApiProjectMember{}
+	if project.OrganizationID == nil {
+		result = append(result, structs.ApiProjectMember{
+			ProjectID: project.ID,
+			UserID:    project.UserID,
+			Role:      roleOwner,
+			CreatedAt: project.CreatedAt,
+			UpdatedAt: project.CreatedAt,
+		})
+	}
+	for _, member := range members {
+		result = append(result, toApiProjectMember(member))
+	}
+	return c.JSON(result)
+}
+
+type PatchMember struct {
+	Role string `json:"role" validate:"required,oneof=viewer editor admin"`
+}
+
+// patchMember changes the role of a member. Admins manage viewers and editors,
+// only the owner manages admins.
+func patchMember(c *fiber.Ctx) error {
+	projectId, userId := c.Params("id"), c.Params("user")
+	if projectId == "" || userId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PatchMember
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	project, role, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleAdmin)
+	if rtrn {
+		return err
+	}
+	if ownsProject(project, userId) {
+		return c.Status(http.StatusBadRequest).JSON(errors.ProjectOwnerRole)
+	}
+	var member structs.ProjectMember
+	err = db.Where(&structs.ProjectMember{ProjectID: projectId, UserID: userId}).First(&member).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if roleRanks[member.Role] >= roleRanks[role] || !canGrant(role, body.Role) {
+		return c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess)
+	}
+	member.Role = body.Role
+	if err := db.Model(&member).Update("role", member.Role).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(toApiProjectMember(member))
+}
+
+// deleteMember removes a member. Members can always leave a project themselves.
+func deleteMember(c *fiber.Ctx) error {
+	projectId, userId := c.Params("id"), c.Params("user")
+	if projectId == "" || userId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	required := roleAdmin
+	if userId == parsed.UserID {
+		required = roleViewer
+	}
+	project, role, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, required)
+	if rtrn {
+		return err
+	}
+	if ownsProject(project, userId) {
+		return c.Status(http.StatusBadRequest).JSON(errors.ProjectOwnerRole)
+	}
+	var member structs.ProjectMember
+	err = db.Where(&structs.ProjectMember{ProjectID: projectId, UserID: userId}).First(&member).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if userId != parsed.UserID && roleRanks[member.Role] >= roleRanks[role] {
+		return c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess)
+	}
+	if err := db.Delete(&member).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateProjects(userId)
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+type PostProjectInvitation struct {
+	Email    string `json:"email" validate:"required_without=Username,excluded_with=Username,omitempty,email"`
+	Username string `json:"username" validate:"required_without=Email,omitempty,max=32"`
+	Role     string `json:"role" validate:"required,oneof=viewer editor admin"`
+}
+
+// postProjectInvitation invites a user by username or email address.
+// Invitations by email are addressed to the address, whether or not it has an
+// account, so the response does not tell which addresses are registered.
+// Only the owner can invite admins.
+func postProjectInvitation(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PostProjectInvitation
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	project, role, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleAdmin)
+	if rtrn {
+		return err
+	}
+	if !canGrant(role, body.Role) {
+		return c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess)
+	}
+
+	invitation := structs.ProjectInvitation{
+		ID:        generator.Generate().String(),
+		ProjectID: projectId,
+		Role:      body.Role,
+		InvitedBy: parsed.UserID,
+		ExpiresAt: time.Now().Add(projectInvitationExpiry),
+	}
+	var invitee structs.User
+	var recipient *gorm.DB
+	if body.Email != "" {
+		invitation.Email = body.Email
+		recipient = db.Where("project_id = ? AND email = ?", projectId, body.Email)
+		err := db.Model(&structs.User{}).Select("id").Where("email = ?", body.Email).First(&invitee).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+	} else {
+		invitee, err, rtrn = findUser(c, "", body.Username)
+		if rtrn {
+			return err
+		}
+		held, err := projectRole(project, invitee.ID)
+		if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		if held != "" {
+			return c.Status(http.StatusConflict).JSON(errors.ProjectMemberExists)
+		}
+		invitation.UserID = invitee.ID
+		recipient = db.Where("project_id = ? AND user_id = ?", projectId, invitee.ID)
+	}
+
+	// Expired invitations do not block a new one
+	err = recipient.Session(&gorm.Session{}).Where("expires_at <= ?", time.Now()).Delete(&structs.ProjectInvitation{}).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	var pending int64
+	if err := recipient.Session(&gorm.Session{}).Model(&structs.ProjectInvitation{}).Count(&pending).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if pending > 0 {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectInvitationExists)
+	}
+
+	if err := db.Create(&invitation).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	subject := "Invitation to " + project.Name
+	message := "You were invited to the project " + project.Name + " as " + body.Role + ". The invitation can be accepted for 7 days."
+	if invitee.ID != "" {
+		notifyUser(invitee.ID, subject, message)
+	} else {
+		go sender.SendEmail(body.Email, subject, message+" Sign up and verify this address to accept it.")
+	}
+	return c.Status(http.StatusCreated).JSON(toApiProjectInvitation(invitation))
+}
+
+// findUser looks up a user by email, or by username when email is empty.
+// When it fails the error response has already been written and should be returned.
+func findUser(c *fiber.Ctx, email string, username string) (structs.User, error, bool) {
+	var user structs.User
+	query := db.Model(&structs.User{}).Select("id")
+	if email != "" {
+		query = query.Where("email = ?", email)
+	} else {
+		query = query.Where("username_key = ?", strings.ToLower(username))
+	}
+	err := query.First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return user, c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return user, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	return user, nil, false
+}
+
+// addressedTo limits query to rows for a user: rows whose userColumn is the
+// user, and rows addressed by email to the user's verified address.
+// When it fails the error response has already been written and should be returned.
+func addressedTo(c *fiber.Ctx, query *gorm.DB, userColumn string, emailColumn string, userId string) (*gorm.DB, error, bool) {
+	var user structs.User
+	if err := db.Model(&structs.User{}).Select("email", "verified").Where(&structs.User{ID: userId}).First(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return nil, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	if !user.Verified {
+		return query.Where(userColumn+" = ?", userId), nil, false
+	}
+	return query.Where("("+userColumn+" = ? OR ("+userColumn+" = '' AND "+emailColumn+" = ?))", userId, user.Email), nil, false
+}
+
+// getProjectInvitations lists the pending invitations of a project
+func getProjectInvitations(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleAdmin); rtrn {
+		return err
+	}
+	var invitations []structs.ProjectInvitation
+	err = db.Where("project_id = ? AND expires_at > ?", projectId, time.Now()).Order("created_at").Find(&invitations).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiProjectInvitation, 0, len(invitations))
+	for _, invitation := range invitations {
+		result = append(result, toApiProjectInvitation(invitation))
+	}
+	return c.JSON(result)
+}
+func deleteProjectInvitation(c *fiber.Ctx) error {
+	projectId, invitationId := c.Params("id"), c.Params("invitation")
+	if projectId == "" || invitationId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleAdmin); rtrn {
+		return err
+	}
+	result := db.Where(&structs.ProjectInvitation{ID: invitationId, ProjectID: projectId}).Delete(&structs.ProjectInvitation{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+// getMyInvitations lists the pending invitations of the signed in user
+func getMyInvitations(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("expires_at > ?", time.Now()), "user_id", "email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	var invitations []structs.ProjectInvitation
+	err = query.Order("created_at").Find(&invitations).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiProjectInvitation, 0, len(invitations))
+	for _, invitation := range invitations {
+		result = append(result, toApiProjectInvitation(invitation))
+	}
+	return c.JSON(result)
+}
+
+// acceptInvitation turns an invitation of the signed in user into a membership
+func acceptInvitation(c *fiber.Ctx) error {
+	invitationId := c.Params("id")
+	if invitationId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("id = ?", invitationId), "user_id", "email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	var invitation structs.ProjectInvitation
+	err = query.First(&invitation).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		db.Delete(&invitation)
+		return c.Status(http.StatusGone).JSON(errors.ProjectInvitationExpired)
+	}
+	// Whether an address already had access is only checked once it is claimed
+	if invitation.UserID == "" {
+		var project structs.Project
+		err := db.Where(&structs.Project{ID: invitation.ProjectID}).First(&project).Error
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+		} else if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		held, err := projectRole(project, parsed.UserID)
+		if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		if held != "" {
+			return c.Status(http.StatusConflict).JSON(errors.ProjectMemberExists)
+		}
+	}
+
+	member := structs.ProjectMember{
+		ProjectID: invitation.ProjectID,
+		UserID:    parsed.UserID,
+		Role:      invitation.Role,
+		InvitedBy: invitation.InvitedBy,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// An invitation revoked or declined meanwhile must not become a membership
+		result := tx.Delete(&invitation)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvitationRevoked
+		}
+		return tx.Create(&member).Error
+	})
+	if err == errInvitationRevoked {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err == gorm.ErrDuplicatedKey {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectMemberExists)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateProjects(parsed.UserID)
+	return c.Status(http.StatusCreated).JSON(toApiProjectMember(member))
+}
+
+// declineInvitation deletes an invitation of the signed in user
+func declineInvitation(c *fiber.Ctx) error {
+	invitationId := c.Params("id")
+	if invitationId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("id = ?", invitationId), "user_id", "email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	result := query.Delete(&structs.ProjectInvitation{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
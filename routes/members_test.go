@@ -0,0 +1,143 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDatabase points db at an empty in-memory database holding models for one test
+func testDatabase(t *testing.T, models ...interface{}) {
+	t.Helper()
+	opened, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{TranslateError: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a database of its own
+	sqlDb, err := opened.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb.SetMaxOpenConns(1)
+	if err := opened.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = opened
+	t.Cleanup(func() {
+		db = previous
+		sqlDb.Close()
+	})
+}
+
+// testRows creates rows or fails the test
+func testRows(t *testing.T, rows ...interface{}) {
+	t.Helper()
+	for _, row := range rows {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testAccessDatabase(t *testing.T) {
+	testDatabase(t, &structs.Project{}, &structs.ProjectMember{}, &structs.OrganizationMember{}, &structs.TeamMember{}, &structs.TeamProject{})
+	organization := "20"
+	testRows(t,
+		&structs.Project{ID: "1", UserID: "100", Name: "personal"},
+		&structs.Project{ID: "2", UserID: "100", Name: "organization", OrganizationID: &organization},
+		&structs.Project{ID: "3", UserID: "100", Name: "public", Visibility: visibilityPublic},
+		&structs.ProjectMember{ProjectID: "1", UserID: "101", Role: roleEditor},
+		&structs.OrganizationMember{OrganizationID: organization, UserID: "100", Role: orgRoleMember},
+		&structs.OrganizationMember{OrganizationID: organization, UserID: "102", Role: orgRoleOwner},
+		&structs.OrganizationMember{OrganizationID: organization, UserID: "103", Role: orgRoleAdmin},
+		&structs.OrganizationMember{OrganizationID: organization, UserID: "104", Role: orgRoleMember},
+		&structs.OrganizationMember{OrganizationID: organization, UserID: "105", Role: orgRoleMember},
+		&structs.TeamMember{TeamID: "30", UserID: "104"},
+		&structs.TeamMember{TeamID: "31", UserID: "104"},
+		&structs.TeamProject{TeamID: "30", ProjectID: "2", Role: roleViewer},
+		&structs.TeamProject{TeamID: "31", ProjectID: "2", Role: roleEditor},
+		&structs.ProjectMember{ProjectID: "2", UserID: "104", Role: roleViewer},
+		&structs.ProjectMember{ProjectID: "2", UserID: "105", Role: roleAdmin},
+	)
+}
+
+func TestProjectRole(t *testing.T) {
+	testAccessDatabase(t)
+	cases := []struct {
+		project string
+		user    string
+		role    string
+	}{
+		{"1", "100", roleOwner},
+		{"1", "101", roleEditor},
+		{"1", "102", ""},
+		// The creator of an organization project holds only their organization role
+		{"2", "100", ""},
+		{"2", "102", roleOwner},
+		{"2", "103", roleAdmin},
+		// The highest of the team roles and the direct membership
+		{"2", "104", roleEditor},
+		{"2", "105", roleAdmin},
+		{"2", "101", ""},
+	}
+	for _, test := range cases {
+		var project structs.Project
+		if err := db.Where(&structs.Project{ID: test.project}).First(&project).Error; err != nil {
+			t.Fatal(err)
+		}
+		role, err := projectRole(project, test.user)
+		if err != nil || role != test.role {
+			t.Errorf("role of %s on project %s = %q, %v, want %q", test.user, test.project, role, err, test.role)
+		}
+	}
+}
+
+func TestGetProjectAccess(t *testing.T) {
+	testAccessDatabase(t)
+	app := fiber.New()
+	app.Get("/:project/:user/:role", func(c *fiber.Ctx) error {
+		_, role, err, rtrn := getProjectAccess(c, c.Params("project"), c.Params("user"), c.Params("role"))
+		if rtrn {
+			return err
+		}
+		return c.SendString(role)
+	})
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/1/100/admin", http.StatusOK},
+		{"/1/101/editor", http.StatusOK},
+		{"/1/101/admin", http.StatusForbidden},
+		{"/1/102/viewer", http.StatusForbidden},
+		{"/3/101/viewer", http.StatusOK},
+		{"/3/101/editor", http.StatusForbidden},
+		{"/9/100/viewer", http.StatusNotFound},
+	}
+	for _, test := range cases {
+		response, err := app.Test(httptest.NewRequest(http.MethodGet, test.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != test.status {
+			t.Errorf("%s answered %d, want %d", test.path, response.StatusCode, test.status)
+		}
+	}
+}
+
+func TestCanGrant(t *testing.T) {
+	if !canGrant(roleAdmin, roleEditor) || !canGrant(roleOwner, roleAdmin) {
+		t.Fatal("refused a role below the granting one")
+	}
+	if canGrant(roleAdmin, roleAdmin) || canGrant(roleEditor, roleEditor) {
+		t.Fatal("granted a role as high as the granting one")
+	}
+}
@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+
+	"api/errors"
+	"api/nsfw"
+	"api/storage"
+	"api/structs"
+
+	"github.com/bytedance/sonic"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	moderationSubjectAvatar = "avatar"
+	moderationSubjectAsset  = "asset"
+)
+
+const (
+	reviewPending  = "pending"
+	reviewApproved = "approved"
+	reviewRejected = "rejected"
+)
+
+// moderationSubject describes an image being moderated. Data is what gets
+// quarantined when the image is rejected and restored when a review approves it.
+// Source is the uncropped upload of an avatar, it is checked against the blocklist too.
+type moderationSubject struct {
+	UserID      string
+	Kind        string
+	SubjectID   string
+	ProjectID   *string
+	Name        string
+	Data        []byte
+	ContentType string
+	Source      image.Image
+}
+
+// moderateImage runs img through the moderation pipeline and records the decision.
+// Rejected images are quarantined and queued for review.
+// When the image is not allowed the error response has already been written and should be returned.
+func moderateImage(c *fiber.Ctx, subject moderationSubject, img image.Image) (error, bool) {
+	checkCtx := ctx
+	if subject.Source != nil {
+		checkCtx = nsfw.WithSource(ctx, subject.Source)
+	}
+	decision := nsfw.Check(checkCtx, img)
+	results, err := sonic.MarshalString(decision.Results)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	record := structs.ModerationDecision{
+		ID:        generator.Generate().String(),
+		UserID:    subject.UserID,
+		Subject:   subject.Kind,
+		SubjectID: subject.SubjectID,
+		ProjectID: subject.ProjectID,
+		Name:      subject.Name,
+		Outcome:   string(decision.Outcome),
+		Label:     decision.Label,
+		Results:   results,
+		Hash:      nsfw.FormatHash(nsfw.DHash(img)),
+	}
+	if subject.Source != nil {
+		record.SourceHash = nsfw.FormatHash(nsfw.DHash(subject.Source))
+	}
+	if decision.Outcome == nsfw.Rejected {
+		key := record.ID
+		err := storage.For(storage.Quarantine).Put(ctx, key, bytes.NewReader(subject.Data), int64(len(subject.Data)), subject.ContentType)
+		if err != nil {
+			// The rejection stands, there is just nothing to review
+			fmt.Println(err.Error())
+		} else {
+			record.Key = key
+			record.ContentType = subject.ContentType
+			record.Review = reviewPending
+		}
+	}
+	if err := db.Create(&record).Error; err != nil {
+		fmt.Println(err.Error())
+	} else if record.Review == reviewPending {
+		notifyModerators("Moderation review "+record.ID, "The "+subject.Kind+" upload of user "+subject.UserID+" was quarantined as "+record.Label+" and is waiting for review.")
+	}
+
+	switch decision.Outcome {
+	case nsfw.Rejected:
+		if record.Review == reviewPending {
+			return c.Status(http.StatusBadRequest).JSON(errors.ImageQuarantined(record.ID)), true
+		}
+		return c.Status(http.StatusBadRequest).JSON(errors.ImageNsfw), true
+	case nsfw.Unavailable:
+		return c.Status(http.StatusServiceUnavailable).JSON(errors.ServerModerationError), true
+	}
+	return nil, false
+}
+
+func toApiModerationDecision(decision structs.ModerationDecision) structs.ApiModerationDecision {
+	var results []nsfw.Result
+	if err := sonic.UnmarshalString(decision.Results, &results); err != nil {
+		fmt.Println(err.Error())
+	}
+	return structs.ApiModerationDecision{
+		ID:         decision.ID,
+		UserID:     decision.UserID,
+		Subject:    decision.Subject,
+		SubjectID:  decision.SubjectID,
+		ProjectID:  decision.ProjectID,
+		Name:       decision.Name,
+		Outcome:    decision.Outcome,
+		Label:      decision.Label,
+		Results:    results,
+		Hash:       decision.Hash,
+		SourceHash: decision.SourceHash,
+		Review:     decision.Review,
+		ReviewNote: decision.ReviewNote,
+		ReviewedAt: decision.ReviewedAt,
+		Appeal:     decision.Appeal,
+		AppealedAt: decision.AppealedAt,
+		CreatedAt:  decision.CreatedAt,
+	}
+}
+
+// getModerationDecisions lists recorded decisions for review, newest first
+func getModerationDecisions(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	limit, err, rtrn := queryLimit(c)
+	if rtrn {
+		return err
+	}
+	query := db.Model(&structs.ModerationDecision{}).Order("created_at desc").Limit(limit)
+	if outcome := c.Query("outcome"); outcome != "" {
+		query = query.Where("outcome = ?", outcome)
+	}
+	if userId := c.Query("user_id"); userId != "" {
+		query = query.Where("user_id = ?", userId)
+	}
+	if before := c.Query("before"); before != "" {
+		query = query.Where("id < ?", before)
+	}
+	return sendModerationDecisions(c, query)
+}
+
+// sendModerationDecisions responds with the decisions matched by query
+func sendModerationDecisions(c *fiber.Ctx, query *gorm.DB) error {
+	var decisions []structs.ModerationDecision
+	if err := query.Find(&decisions).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiModerationDecision, 0, len(decisions))
+	for _, decision := range decisions {
+		result = append(result, toApiModerationDecision(decision))
+	}
+	return c.JSON(result)
+}
+
+// queryLimit reads the limit query parameter, between 1 and 200 with a default of 50
+func queryLimit(c *fiber.Ctx) (int, error, bool) {
+	query := c.Query("limit")
+	if query == "" {
+		return 50, nil, false
+	}
+	limit, err := strconv.Atoi(query)
+	if err != nil || limit < 1 || limit > 200 {
+		return 0, c.Status(http.StatusBadRequest).JSON(errors.InvalidLimit), true
+	}
+	return limit, nil, false
+}
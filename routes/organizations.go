@@ -0,0 +1,780 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"api/errors"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Roles in an organization. Owners hold the owner role on every project of the
+// organization and admins the admin role, members get access through teams.
+const (
+	orgRoleMember = "member"
+	orgRoleAdmin  = "admin"
+	orgRoleOwner  = "owner"
+)
+
+var orgRoleRanks = map[string]int{orgRoleMember: 1, orgRoleAdmin: 2, orgRoleOwner: 3}
+
+// How long an invitation to an organization can be accepted
+const organizationInvitationExpiry = 7 * 24 * time.Hour
+
+// organizationRole returns the role of a user in an organization, empty when they are not a member
+func organizationRole(organizationId string, userId string) (string, error) {
+	var member structs.OrganizationMember
+	err := db.Where(&structs.OrganizationMember{OrganizationID: organizationId, UserID: userId}).First(&member).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", nil
+	}
+	return member.Role, err
+}
+
+// getOrganizationAccess loads an organization the user holds at least role in and returns their role.
+// When it fails the error response has already been written and should be returned.
+func getOrganizationAccess(c *fiber.Ctx, organizationId string, userId string, role string) (structs.Organization, string, error, bool) {
+	var organization structs.Organization
+	err := db.Where(&structs.Organization{ID: organizationId}).First(&organization).Error
+	if err == gorm.ErrRecordNotFound {
+		return organization, "", c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return organization, "", c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	held, err := organizationRole(organizationId, userId)
+	if err != nil {
+		fmt.Println(err.Error())
+		return organization, "", c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	// Organizations are hidden from users outside of them
+	if held == "" {
+		return organization, "", c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	}
+	if orgRoleRanks[held] < orgRoleRanks[role] {
+		return organization, held, c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess), true
+	}
+	return organization, held, nil, false
+}
+
+// teamProjectRole returns the highest role any team of the user holds on a project
+func teamProjectRole(projectId string, userId string) (string, error) {
+	var roles []string
+	err := db.Model(&structs.TeamProject{}).
+		Joins("JOIN team_members ON team_members.team_id = team_projects.team_id").
+		Where("team_projects.project_id = ? AND team_members.user_id = ?", projectId, userId).
+		Pluck("team_projects.role", &roles).Error
+	role := ""
+	for _, r := range roles {
+		if roleRanks[r] > roleRanks[role] {
+			role = r
+		}
+	}
+	return role, err
+}
+
+// organizationUsers returns the IDs of every member of an organization
+func organizationUsers(organizationId string) ([]string, error) {
+	var users []string
+	err := db.Model(&structs.OrganizationMember{}).Where("organization_id = ?", organizationId).Pluck("user_id", &users).Error
+	return users, err
+}
+
+// invalidateProjects drops the cached project lists of users
+func invalidateProjects(userIds ...string) {
+	for _, userId := range userIds {
+		rdb.Del(ctx, "projects:"+userId)
+	}
+}
+
+// invalidateOrganizationProjects drops the cached project lists of every member of an organization
+func invalidateOrganizationProjects(organizationId string) {
+	users, err := organizationUsers(organizationId)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	invalidateProjects(users...)
+}
+
+func toApiOrganizationMember(member structs.OrganizationMember) structs.ApiOrganizationMember {
+	return structs.ApiOrganizationMember{
+		OrganizationID: member.OrganizationID,
+		UserID:         member.UserID,
+		Role:           member.Role,
+		CreatedAt:      member.CreatedAt,
+		UpdatedAt:      member.UpdatedAt,
+	}
+}
+func toApiOrganization(organization structs.Organization, role string) structs.ApiOrganization {
+	return structs.ApiOrganization{
+		ID:        organization.ID,
+		Slug:      organization.Slug,
+		Name:      organization.Name,
+		CreatedBy: organization.CreatedBy,
+		Role:      role,
+		CreatedAt: organization.CreatedAt,
+		UpdatedAt: organization.UpdatedAt,
+	}
+}
+
+type PostOrganization struct {
+	Slug string `json:"slug" validate:"required,min=2,max=32,slug"`
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+// postOrganization creates an organization owned by the signed in user
+func postOrganization(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PostOrganization
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	var count int64
+	if err := db.Model(&structs.Organization{}).Where("slug = ?", body.Slug).Count(&count).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if count > 0 {
+		return c.Status(http.StatusConflict).JSON(errors.OrganizationSlugTaken)
+	}
+
+	organization := structs.Organization{
+		ID:        generator.Generate().String(),
+		Slug:      body.Slug,
+		Name:      body.Name,
+		CreatedBy: parsed.UserID,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&structs.OrganizationMember{OrganizationID: organization.ID, UserID: parsed.UserID, Role: orgRoleOwner}).Error
+	})
+	if err == gorm.ErrDuplicatedKey {
+		return c.Status(http.StatusConflict).JSON(errors.OrganizationSlugTaken)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusCreated).JSON(toApiOrganization(organization, orgRoleOwner))
+}
+
+// getOrganizations lists the organizations of the signed in user
+func getOrganizations(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var memberships []structs.OrganizationMember
+	if err := db.Where(&structs.OrganizationMember{UserID: parsed.UserID}).Find(&memberships).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	roles := map[string]string{}
+	ids := make([]string, 0, len(memberships))
+	for _, membership := range memberships {
+		roles[membership.OrganizationID] = membership.Role
+		ids = append(ids, membership.OrganizationID)
+	}
+	result := []structs.ApiOrganization{}
+	if len(ids) == 0 {
+		return c.JSON(result)
+	}
+	var organizations []structs.Organization
+	if err := db.Where("id IN ?", ids).Order("slug").Find(&organizations).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	for _, organization := range organizations {
+		result = append(result, toApiOrganization(organization, roles[organization.ID]))
+	}
+	return c.JSON(result)
+}
+func getOrganization(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, role, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleMember)
+	if rtrn {
+		return err
+	}
+	return c.JSON(toApiOrganization(organization, role))
+}
+
+type PatchOrganization struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+func patchOrganization(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PatchOrganization
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	organization, role, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	organization.Name = body.Name
+	if err := db.Model(&organization).Update("name", organization.Name).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(toApiOrganization(organization, role))
+}
+
+// deleteOrganization deletes an organization without projects along with its members, invitations and teams
+func deleteOrganization(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleOwner)
+	if rtrn {
+		return err
+	}
+	var projects int64
+	if err := db.Model(&structs.Project{}).Where("organization_id = ?", organization.ID).Count(&projects).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if projects > 0 {
+		return c.Status(http.StatusConflict).JSON(errors.OrganizationHasProjects)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		teams := tx.Model(&structs.Team{}).Select("id").Where("organization_id = ?", organization.ID)
+		if err := tx.Where("team_id IN (?)", teams).Delete(&structs.TeamMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", organization.ID).Delete(&structs.Team{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", organization.ID).Delete(&structs.OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", organization.ID).Delete(&structs.OrganizationInvitation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&organization).Error
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+// getOrganizationProjects lists every project of an organization
+func getOrganizationProjects(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleMember)
+	if rtrn {
+		return err
+	}
+	projects := []structs.ApiProject{}
+	if err := db.Model(&structs.Project{}).Where("organization_id = ?", organization.ID).Order("created_at").Find(&projects).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(projects)
+}
+
+func getOrganizationMembers(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleMember)
+	if rtrn {
+		return err
+	}
+	var members []structs.OrganizationMember
+	if err := db.Where(&structs.OrganizationMember{OrganizationID: organization.ID}).Order("created_at").Find(&members).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiOrganizationMember, 0, len(members))
+	for _, member := range members {
+		result = append(result, toApiOrganizationMember(member))
+	}
+	return c.JSON(result)
+}
+
+type PostOrganizationInvitation struct {
+	Email    string `json:"email" validate:"required_without=Username,excluded_with=Username,omitempty,email"`
+	Username string `json:"username" validate:"required_without=Email,omitempty,max=32"`
+	Role     string `json:"role" validate:"required,oneof=member admin owner"`
+}
+
+func toApiOrganizationInvitation(invitation structs.OrganizationInvitation) structs.ApiOrganizationInvitation {
+	return structs.ApiOrganizationInvitation{
+		ID:             invitation.ID,
+		OrganizationID: invitation.OrganizationID,
+		UserID:         invitation.UserID,
+		Email:          invitation.Email,
+		Role:           invitation.Role,
+		InvitedBy:      invitation.InvitedBy,
+		ExpiresAt:      invitation.ExpiresAt,
+		CreatedAt:      invitation.CreatedAt,
+	}
+}
+
+// postOrganizationInvitation invites a user by username or email address, they
+// only become a member once they accept. Invitations by email are addressed to
+// the address like project invitations, so the response does not tell which
+// addresses are registered. Admins cannot invite with a role above their own.
+func postOrganizationInvitation(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PostOrganizationInvitation
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	organization, role, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	if orgRoleRanks[body.Role] > orgRoleRanks[role] {
+		return c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess)
+	}
+
+	invitation := structs.OrganizationInvitation{
+		ID:             generator.Generate().String(),
+		OrganizationID: organization.ID,
+		Role:           body.Role,
+		InvitedBy:      parsed.UserID,
+		ExpiresAt:      time.Now().Add(organizationInvitationExpiry),
+	}
+	var invitee structs.User
+	var recipient *gorm.DB
+	if body.Email != "" {
+		invitation.Email = body.Email
+		recipient = db.Where("organization_id = ? AND email = ?", organization.ID, body.Email)
+		err := db.Model(&structs.User{}).Select("id").Where("email = ?", body.Email).First(&invitee).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+	} else {
+		invitee, err, rtrn = findUser(c, "", body.Username)
+		if rtrn {
+			return err
+		}
+		held, err := organizationRole(organization.ID, invitee.ID)
+		if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		if held != "" {
+			return c.Status(http.StatusConflict).JSON(errors.OrganizationMemberExists)
+		}
+		invitation.UserID = invitee.ID
+		recipient = db.Where("organization_id = ? AND user_id = ?", organization.ID, invitee.ID)
+	}
+
+	// Expired invitations do not block a new one
+	err = recipient.Session(&gorm.Session{}).Where("expires_at <= ?", time.Now()).Delete(&structs.OrganizationInvitation{}).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	var pending int64
+	if err := recipient.Session(&gorm.Session{}).Model(&structs.OrganizationInvitation{}).Count(&pending).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if pending > 0 {
+		return c.Status(http.StatusConflict).JSON(errors.OrganizationInvitationExists)
+	}
+
+	if err := db.Create(&invitation).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	subject := "Invitation to " + organization.Name
+	message := "You were invited to the organization " + organization.Name + " as " + body.Role + ". The invitation can be accepted for 7 days."
+	if invitee.ID != "" {
+		notifyUser(invitee.ID, subject, message)
+	} else {
+		go sender.SendEmail(body.Email, subject, message+" Sign up and verify this address to accept it.")
+	}
+	return c.Status(http.StatusCreated).JSON(toApiOrganizationInvitation(invitation))
+}
+
+// getOrganizationInvitations lists the pending invitations of an organization
+func getOrganizationInvitations(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	var invitations []structs.OrganizationInvitation
+	err = db.Where("organization_id = ? AND expires_at > ?", organization.ID, time.Now()).Order("created_at").Find(&invitations).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiOrganizationInvitation, 0, len(invitations))
+	for _, invitation := range invitations {
+		result = append(result, toApiOrganizationInvitation(invitation))
+	}
+	return c.JSON(result)
+}
+
+func deleteOrganizationInvitation(c *fiber.Ctx) error {
+	invitationId := c.Params("invitation")
+	if invitationId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	result := db.Where(&structs.OrganizationInvitation{ID: invitationId, OrganizationID: organization.ID}).Delete(&structs.OrganizationInvitation{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+// getMyOrganizationInvitations lists the pending organization invitations of the signed in user
+func getMyOrganizationInvitations(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("expires_at > ?", time.Now()), "user_id", "email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	var invitations []structs.OrganizationInvitation
+	err = query.Order("created_at").Find(&invitations).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiOrganizationInvitation, 0, len(invitations))
+	for _, invitation := range invitations {
+		result = append(result, toApiOrganizationInvitation(invitation))
+	}
+	return c.JSON(result)
+}
+
+// acceptOrganizationInvitation turns an invitation of the signed in user into a membership
+func acceptOrganizationInvitation(c *fiber.Ctx) error {
+	invitationId := c.Params("id")
+	if invitationId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("id = ?", invitationId), "user_id", "email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	var invitation structs.OrganizationInvitation
+	err = query.First(&invitation).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		db.Delete(&invitation)
+		return c.Status(http.StatusGone).JSON(errors.OrganizationInvitationExpired)
+	}
+
+	member := structs.OrganizationMember{OrganizationID: invitation.OrganizationID, UserID: parsed.UserID, Role: invitation.Role}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// An invitation revoked or declined meanwhile must not become a membership
+		result := tx.Delete(&invitation)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvitationRevoked
+		}
+		return tx.Create(&member).Error
+	})
+	if err == errInvitationRevoked {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err == gorm.ErrDuplicatedKey {
+		return c.Status(http.StatusConflict).JSON(errors.OrganizationMemberExists)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateProjects(parsed.UserID)
+	return c.Status(http.StatusCreated).JSON(toApiOrganizationMember(member))
+}
+
+// declineOrganizationInvitation deletes an organization invitation of the signed in user
+func declineOrganizationInvitation(c *fiber.Ctx) error {
+	invitationId := c.Params("id")
+	if invitationId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("id = ?", invitationId), "user_id", "email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	result := query.Delete(&structs.OrganizationInvitation{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+type PatchOrganizationMember struct {
+	Role string `json:"role" validate:"required,oneof=member admin owner"`
+}
+
+// patchOrganizationMember changes the role of a member. Admins manage members,
+// owners manage everyone and the last owner cannot step down.
+func patchOrganizationMember(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PatchOrganizationMember
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	organization, role, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	member, err, rtrn := getOrganizationMember(c, organization.ID, c.Params("user"))
+	if rtrn {
+		return err
+	}
+	if role != orgRoleOwner && (orgRoleRanks[member.Role] >= orgRoleRanks[role] || orgRoleRanks[body.Role] > orgRoleRanks[role]) {
+		return c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess)
+	}
+	if member.Role == orgRoleOwner && body.Role != orgRoleOwner {
+		if err, rtrn := checkOtherOwner(c, organization.ID); rtrn {
+			return err
+		}
+	}
+	member.Role = body.Role
+	if err := db.Model(&member).Update("role", member.Role).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateProjects(member.UserID)
+	return c.JSON(toApiOrganizationMember(member))
+}
+
+// deleteOrganizationMember removes a member and their team memberships.
+// Members can always leave, unless they are the last owner.
+func deleteOrganizationMember(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	userId := c.Params("user")
+	required := orgRoleAdmin
+	if userId == parsed.UserID {
+		required = orgRoleMember
+	}
+	organization, role, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, required)
+	if rtrn {
+		return err
+	}
+	member, err, rtrn := getOrganizationMember(c, organization.ID, userId)
+	if rtrn {
+		return err
+	}
+	if userId != parsed.UserID && role != orgRoleOwner && orgRoleRanks[member.Role] >= orgRoleRanks[role] {
+		return c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess)
+	}
+	if member.Role == orgRoleOwner {
+		if err, rtrn := checkOtherOwner(c, organization.ID); rtrn {
+			return err
+		}
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		teams := tx.Model(&structs.Team{}).Select("id").Where("organization_id = ?", organization.ID)
+		if err := tx.Where("user_id = ? AND team_id IN (?)", userId, teams).Delete(&structs.TeamMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&member).Error
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateProjects(userId)
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+// getOrganizationMember loads a member of an organization.
+// When it fails the error response has already been written and should be returned.
+func getOrganizationMember(c *fiber.Ctx, organizationId string, userId string) (structs.OrganizationMember, error, bool) {
+	var member structs.OrganizationMember
+	err := db.Where(&structs.OrganizationMember{OrganizationID: organizationId, UserID: userId}).First(&member).Error
+	if err == gorm.ErrRecordNotFound {
+		return member, c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return member, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	return member, nil, false
+}
+
+// checkOtherOwner makes sure an organization keeps an owner when one steps down.
+// When it fails the error response has already been written and should be returned.
+func checkOtherOwner(c *fiber.Ctx, organizationId string) (error, bool) {
+	var owners int64
+	if err := db.Model(&structs.OrganizationMember{}).Where("organization_id = ? AND role = ?", organizationId, orgRoleOwner).Count(&owners).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	if owners < 2 {
+		return c.Status(http.StatusBadRequest).JSON(errors.OrganizationOwnerRequired), true
+	}
+	return nil, false
+}
+
+// PutProjectOrganization moves a project into an organization
+type PutProjectOrganization struct {
+	OrganizationID string `json:"organization_id" validate:"required"`
+}
+
+// putProjectOrganization transfers a project to an organization. The caller
+// needs the owner role on the project and has to be an admin of the organization.
+func putProjectOrganization(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PutProjectOrganization
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	project, _, err, rtrn := getProjectAccess(c, c.Params("id"), parsed.UserID, roleOwner)
+	if rtrn {
+		return err
+	}
+	if project.OrganizationID != nil && *project.OrganizationID == body.OrganizationID {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectAlreadyOwned)
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, body.OrganizationID, parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+
+	previous := project.OrganizationID
+	project.OrganizationID = &organization.ID
+	err = db.Model(&project).Update("organization_id", organization.ID).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	// Teams of the previous organization lose their access
+	if previous != nil {
+		if err := db.Where("project_id = ?", project.ID).Delete(&structs.TeamProject{}).Error; err != nil {
+			fmt.Println(err.Error())
+		}
+		invalidateOrganizationProjects(*previous)
+	}
+	invalidateProjects(project.UserID)
+	invalidateOrganizationProjects(organization.ID)
+	return c.JSON(toApiProject(project))
+}
+
+// deleteProjectOrganization transfers a project out of its organization to
+// the caller, who needs the owner role on it
+func deleteProjectOrganization(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	project, _, err, rtrn := getProjectAccess(c, c.Params("id"), parsed.UserID, roleOwner)
+	if rtrn {
+		return err
+	}
+	if project.OrganizationID == nil {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectAlreadyOwned)
+	}
+
+	previous := *project.OrganizationID
+	project.OrganizationID = nil
+	project.UserID = parsed.UserID
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&project).Updates(map[string]interface{}{"organization_id": nil, "user_id": project.UserID}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", project.ID).Delete(&structs.TeamProject{}).Error; err != nil {
+			return err
+		}
+		// The new owner does not need a membership
+		return tx.Where(&structs.ProjectMember{ProjectID: project.ID, UserID: parsed.UserID}).Delete(&structs.ProjectMember{}).Error
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateOrganizationProjects(previous)
+	invalidateProjects(parsed.UserID)
+	return c.JSON(toApiProject(project))
+}
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"api/errors"
+	"api/structs"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9_.-]{1,30}[a-zA-Z0-9])$`)
+
+// validateUsername allows 3 to 32 letters, digits, '_', '.' and '-' that start and end with a letter or digit
+func validateUsername(fl validator.FieldLevel) bool {
+	return usernamePattern.MatchString(fl.Field().String())
+}
+
+// usernameTaken reports whether another user already has username, ignoring case
+func usernameTaken(username string, userId string) (bool, error) {
+	var count int64
+	err := db.Model(&structs.User{}).Where("username_key = ? AND id <> ?", strings.ToLower(username), userId).Count(&count).Error
+	return count > 0, err
+}
+
+type PatchProfile struct {
+	Username    *string `json:"username" validate:"omitempty,username"`
+	DisplayName *string `json:"display_name" validate:"omitempty,max=64"`
+	Bio         *string `json:"bio" validate:"omitempty,max=280"`
+	Locale      *string `json:"locale" validate:"omitempty,bcp47_language_tag"`
+}
+
+func patchProfile(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+
+	var body PatchProfile
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+
+	updates := map[string]interface{}{}
+	if body.Username != nil {
+		taken, err := usernameTaken(*body.Username, parsed.UserID)
+		if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		if taken {
+			return c.Status(http.StatusBadRequest).JSON(errors.UserUsernameTaken)
+		}
+		updates["username"] = *body.Username
+		updates["username_key"] = strings.ToLower(*body.Username)
+	}
+	if body.DisplayName != nil {
+		updates["display_name"] = strings.TrimSpace(*body.DisplayName)
+	}
+	if body.Bio != nil {
+		updates["bio"] = *body.Bio
+	}
+	if body.Locale != nil {
+		updates["locale"] = *body.Locale
+	}
+	if len(updates) > 0 {
+		err = db.Model(&structs.User{}).Where("ID = ?", parsed.UserID).Updates(updates).Error
+		if err == gorm.ErrDuplicatedKey {
+			return c.Status(http.StatusBadRequest).JSON(errors.UserUsernameTaken)
+		} else if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+	}
+
+	var user structs.ApiUser
+	err = db.Model(&structs.User{}).Where(&structs.User{ID: parsed.UserID}).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(user)
+}
+
+func getUsernameAvailable(c *fiber.Ctx) error {
+	username := c.Params("username")
+	if username == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	if !usernamePattern.MatchString(username) {
+		return c.JSON(fiber.Map{"available": false, "valid": false})
+	}
+	taken, err := usernameTaken(username, "")
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(fiber.Map{"available": !taken, "valid": true})
+}
+
+func getProfile(c *fiber.Ctx) error {
+	username := c.Params("username")
+	if username == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	var profile structs.ApiProfile
+	err := db.Model(&structs.User{}).Where("username_key = ?", strings.ToLower(username)).First(&profile).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(profile)
+}
@@ -1,64 +1,67 @@
 package routes
 
 import (
-	"bytes"
 	"encoding/base64"
+	goerrors "errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os/exec"
-	"sync"
+	"strings"
 	"time"
 
-	"code.gitea.io/sdk/gitea"
 	"github.com/bytedance/sonic"
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
 	"api/errors"
+	"api/git"
+	"api/password"
 	"api/structs"
 )
 
 func getProjects(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
+	projects, err := accessibleProjects(parsed.UserID)
 	if err != nil {
 		fmt.Println(err.Error())
 		return c.Status(500).JSON(errors.ServerParseError)
 	}
+	return c.JSON(projects)
+}
 
+// accessibleProjects lists the projects a user owns, is a member of, can
+// access through a team or that belong to an organization they administer.
+// The list is cached for two minutes.
+func accessibleProjects(userId string) ([]structs.ApiProject, error) {
 	var projects []structs.ApiProject
-	val, err := rdb.Get(ctx, "projects:"+parsed.UserID).Result()
-
-	if err != nil {
-		db.Model(&structs.Project{}).Where(&structs.Project{UserID: parsed.UserID}).Find(&projects)
-
-		json, err := sonic.Marshal(&projects)
-		if err == nil {
-			rdb.Set(ctx, "projects:"+parsed.UserID, json, 2*time.Minute)
-		}
-	} else {
-		err := sonic.UnmarshalString(val, &projects)
-		if err != nil {
-			return c.Status(500).JSON(errors.ServerParseError)
-		}
-	}
-
-	return c.JSON(projects)
+	val, err := rdb.Get(ctx, "projects:"+userId).Result()
+	if err == nil {
+		err = sonic.UnmarshalString(val, &projects)
+		return projects, err
+	}
+
+	memberships := db.Model(&structs.ProjectMember{}).Select("project_id").Where("user_id = ?", userId)
+	teams := db.Model(&structs.TeamProject{}).Select("team_projects.project_id").
+		Joins("JOIN team_members ON team_members.team_id = team_projects.team_id").
+		Where("team_members.user_id = ?", userId)
+	organizations := db.Model(&structs.OrganizationMember{}).Select("organization_id").
+		Where("user_id = ? AND role IN ?", userId, []string{orgRoleAdmin, orgRoleOwner})
+	db.Model(&structs.Project{}).
+		Where("(user_id = ? AND organization_id IS NULL) OR id IN (?) OR id IN (?) OR organization_id IN (?)", userId, memberships, teams, organizations).
+		Find(&projects)
+
+	json, err := sonic.Marshal(&projects)
+	if err == nil {
+		rdb.Set(ctx, "projects:"+userId, json, 2*time.Minute)
+	}
+	return projects, nil
 }
+
+// getDeployProjects lists the projects of the user a deploy token belongs to,
+// the same projects GET /projects lists for them
 func getDeployProjects(c *fiber.Ctx) error {
 	authorization := c.Get("Authorization")
 	if authorization == "" {
@@ -72,9 +75,11 @@ func getDeployProjects(c *fiber.Ctx) error {
 		return c.Status(500).JSON(errors.ServerRedisError)
 	}
 
-	var projects []structs.ApiProject
-	db.Model(&structs.Project{}).Where(&structs.Project{UserID: userId}).Find(&projects)
-
+	projects, err := accessibleProjects(userId)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(500).JSON(errors.ServerParseError)
+	}
 	return c.JSON(projects)
 }
 func getProject(c *fiber.Ctx) error {
@@ -82,40 +87,110 @@ func getProject(c *fiber.Ctx) error {
 	if projectId == "" {
 		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
 	}
-	var project structs.ApiProject
+	// Public projects can be read without signing in
+	if c.Get("Authorization") == "" {
+		var project structs.Project
+		err := db.Where(&structs.Project{ID: projectId}).First(&project).Error
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+		} else if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		if project.Visibility != visibilityPublic {
+			return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationMissing)
+		}
+		return c.JSON(toApiProject(project))
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	project, role, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleViewer)
+	if rtrn {
+		return err
+	}
+	return c.JSON(toApiProjectFor(project, role))
+}
 
-	err := db.Model(&structs.Project{}).Where(&structs.Project{ID: projectId}).First(&project).Error
-	if err == gorm.ErrRecordNotFound {
-		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+const (
+	devBranch  = "dev"
+	prodBranch = "prod"
+)
+
+const (
+	visibilityPrivate = "private"
+	visibilityPublic  = "public"
+)
+
+// projectRepo is the repository of a project
+func projectRepo(project structs.Project) git.Repo {
+	return git.Repo{Owner: project.RepoOwner, Name: project.Repo}
+}
+
+func toApiProject(project structs.Project) structs.ApiProject {
+	return structs.ApiProject{
+		ID:             project.ID,
+		UserID:         project.UserID,
+		Name:           project.Name,
+		Description:    project.Description,
+		Visibility:     project.Visibility,
+		Provider:       project.Provider,
+		OrganizationID: project.OrganizationID,
+		RepoOwner:      project.RepoOwner,
+		Repo:           project.Repo,
+		Template:       project.Template,
+		ProdBranch:     project.ProdBranch,
+		DevBranch:      project.DevBranch,
+		CreatedAt:      project.CreatedAt,
+		UpdatedAt:      project.UpdatedAt,
+	}
+}
+
+// toApiProjectFor includes the settings of the project for editors, without
+// the values of env settings
+func toApiProjectFor(project structs.Project, role string) structs.ApiProject {
+	api := toApiProject(project)
+	if roleRanks[role] >= roleRanks[roleEditor] {
+		api.Settings = hideVariables(project.Settings)
+	}
+	return api
+}
+
+// repoDescription is the description of the repository of a project
+func repoDescription(project structs.Project) string {
+	if project.Description != "" {
+		return project.Description
 	}
+	return project.Name
+}
+
+// getProjectRepo resolves the provider and repository of a project.
+// When it fails the error response has already been written and should be returned.
+func getProjectRepo(c *fiber.Ctx, project structs.Project) (git.GitProvider, git.Repo, error, bool) {
+	provider, err := git.For(project.Provider)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		fmt.Println(err.Error())
+		return nil, git.Repo{}, c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError), true
 	}
-	return c.JSON(project)
+	return provider, projectRepo(project), nil, false
 }
 
 type CreateBody struct {
 	Name     string `json:"name" validate:"required,min=4,max=64"`
-	Template string `json:"template" validate:"required,min=1"`
+	Template string `json:"template" validate:"required,max=64"`
+	// Provider hosting the repository, the default provider when empty
+	Provider string `json:"provider" validate:"omitempty,max=32"`
+	// Values of the variables declared in the template manifest
+	Variables map[string]string `json:"variables" validate:"max=50,dive,keys,max=64,endkeys,max=1000"`
+	// Organization to create the project in, the signed in user owns it when empty
+	OrganizationID string `json:"organization_id"`
 }
 
 func createProject(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 
 	var body CreateBody
@@ -127,59 +202,239 @@ func createProject(c *fiber.Ctx) error {
 		return err
 	}
 
-	if body.Template != "splitscript_javascript" && body.Template != "splitscript_typescript" {
-		return c.Status(400).JSON(errors.InvalidTemplate)
+	var memberRole string
+	if body.OrganizationID != "" {
+		_, role, err, rtrn := getOrganizationAccess(c, body.OrganizationID, parsed.UserID, orgRoleMember)
+		if rtrn {
+			return err
+		}
+		memberRole = role
+	}
+
+	template, err, rtrn := getUsableTemplate(c, body.Template, parsed.UserID)
+	if rtrn {
+		return err
+	}
+	// Templates hosted on one provider can only be instantiated there
+	if template.Provider != "" {
+		if body.Provider != "" && body.Provider != template.Provider {
+			return c.Status(http.StatusBadRequest).JSON(errors.InvalidProvider)
+		}
+		body.Provider = template.Provider
+	}
+	provider, err := git.For(body.Provider)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.InvalidProvider)
+	}
+	if body.Provider == "" {
+		body.Provider = git.Default
 	}
 
 	id := generator.Generate()
-	name := parsed.UserID + "-" + id.String()
-	_, _, err = git.CreateRepoFromTemplate(env.GitUsername, "template_"+body.Template, gitea.CreateRepoFromTemplateOption{Owner: env.GitUsername, Name: name, Private: true, GitContent: true, Description: body.Name})
+	source := templateRepo(provider, template)
+	manifest, err := loadManifest(provider, source, template.ProdBranch)
 	if err != nil {
 		fmt.Println(err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
 	}
-	_, _, err = git.CreateBranch(env.GitUsername, name, gitea.CreateBranchOption{BranchName: "dev", OldBranchName: "prod"})
+	var variables map[string]string
+	var authorName, authorEmail string
+	if manifest != nil {
+		authorName, authorEmail, err, rtrn = commitAuthor(c, parsed.UserID)
+		if rtrn {
+			return err
+		}
+		var author structs.User
+		if err := db.Where(&structs.User{ID: parsed.UserID}).First(&author).Error; err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		var problems map[string]string
+		variables, problems = manifest.resolve(body.Variables, projectBuiltins(id.String(), body.Name, author))
+		if len(problems) > 0 {
+			return c.Status(http.StatusBadRequest).JSON(errors.TemplateVariablesInvalid(problems))
+		}
+	} else if len(body.Variables) > 0 {
+		problems := map[string]string{}
+		for name := range body.Variables {
+			problems[name] = "unknown"
+		}
+		return c.Status(http.StatusBadRequest).JSON(errors.TemplateVariablesInvalid(problems))
+	}
+
+	project := structs.Project{
+		ID:         id.String(),
+		UserID:     parsed.UserID,
+		Name:       body.Name,
+		Provider:   body.Provider,
+		RepoOwner:  provider.Owner(),
+		Repo:       parsed.UserID + "-" + id.String(),
+		Template:   template.Name,
+		ProdBranch: template.ProdBranch,
+		DevBranch:  template.DevBranch,
+	}
+	if body.OrganizationID != "" {
+		project.OrganizationID = &body.OrganizationID
+	}
+	repo := projectRepo(project)
+	err = provider.CreateFromTemplate(ctx, source, template.ProdBranch, repo, body.Name)
+	if goerrors.Is(err, git.ErrNotDefault) {
+		return c.Status(http.StatusConflict).JSON(errors.TemplateBranchNotDefault)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
+	}
+	// Variables are filled in on the production branch so the development branch starts with them
+	if manifest != nil {
+		err = manifest.render(provider, repo, template.ProdBranch, variables, authorName, authorEmail)
+		if err != nil {
+			provider.DeleteRepo(ctx, repo)
+			if goerrors.Is(err, git.ErrInvalid) {
+				return c.Status(http.StatusBadRequest).JSON(errors.InvalidPath)
+			}
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
+		}
+	}
+	err = provider.CreateBranch(ctx, repo, template.DevBranch, template.ProdBranch)
 	if err != nil {
 		fmt.Println(err.Error())
-		git.DeleteRepo(env.GitUsername, name)
+		provider.DeleteRepo(ctx, repo)
 		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
 	}
-	project := structs.Project{ID: id.String(), UserID: parsed.UserID, Name: body.Name}
-	err = db.Create(&project).Error
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&project).Error; err != nil {
+			return err
+		}
+		// Organization members without a team for it still need access to what they created
+		if memberRole == orgRoleMember {
+			return tx.Create(&structs.ProjectMember{ProjectID: project.ID, UserID: parsed.UserID, Role: roleAdmin, InvitedBy: parsed.UserID}).Error
+		}
+		return nil
+	})
 	if err != nil {
 		fmt.Println(err.Error())
-		git.DeleteRepo(env.GitUsername, name)
+		provider.DeleteRepo(ctx, repo)
 		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
 	}
+	if project.OrganizationID != nil {
+		invalidateOrganizationProjects(*project.OrganizationID)
+	}
 	return c.Status(http.StatusCreated).JSON(fiber.Map{"id": id.String()})
 }
 
-type DeleteProject struct {
-	Password string `json:"password" validate:"required,min=8,max=32"`
+type PatchProject struct {
+	Name        *string `json:"name" validate:"omitempty,min=4,max=64"`
+	Description *string `json:"description" validate:"omitempty,max=500"`
+	Visibility  *string `json:"visibility" validate:"omitempty,oneof=private public"`
+	// Settings to change, null removes a setting
+	Settings map[string]interface{} `json:"settings" validate:"max=50"`
 }
 
-func deleteProject(c *fiber.Ctx) error {
+// patchProject changes the details of a project, editors can change its
+// settings and admins everything else. The repository description follows the
+// description, or the name when the project has none.
+func patchProject(c *fiber.Ctx) error {
 	projectId := c.Params("id")
 	if projectId == "" {
 		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
 	}
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
+	var body PatchProject
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	required := roleEditor
+	if body.Name != nil || body.Description != nil || body.Visibility != nil {
+		required = roleAdmin
+	}
+	project, role, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, required)
+	if rtrn {
+		return err
+	}
+
+	if body.Settings != nil {
+		var template structs.Template
+		err := db.Where(&structs.Template{Name: project.Template}).First(&template).Error
+		// Projects of removed templates keep the default settings
+		if err != nil && err != gorm.ErrRecordNotFound {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		settings, problems, err := applySettings(project.ID, templateSettings(template), project.Settings, body.Settings)
+		if err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerEncryptError)
+		}
+		if len(problems) > 0 {
+			return c.Status(http.StatusBadRequest).JSON(errors.ProjectSettingsInvalid(problems))
+		}
+		project.Settings = settings
+	}
+
+	previous := repoDescription(project)
+	if body.Name != nil {
+		project.Name = *body.Name
+	}
+	if body.Description != nil {
+		project.Description = *body.Description
+	}
+	if body.Visibility != nil {
+		project.Visibility = *body.Visibility
+	}
+	description := repoDescription(project)
+	var provider git.GitProvider
+	var repo git.Repo
+	if description != previous {
+		provider, repo, err, rtrn = getProjectRepo(c, project)
+		if rtrn {
+			return err
+		}
+		if err := provider.SetDescription(ctx, repo, description); err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
+		}
+	}
+	err = db.Model(&project).Select("Name", "Description", "Visibility", "Settings").Updates(&project).Error
+	if err != nil {
 		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
+		// Put the repository description back so it matches the project again
+		if provider != nil {
+			provider.SetDescription(ctx, repo, previous)
+		}
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
 	}
 
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
+	members, err := projectMembers(project.ID)
 	if err != nil {
 		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	}
+	invalidateProjects(append(members, project.UserID)...)
+	if project.OrganizationID != nil {
+		invalidateOrganizationProjects(*project.OrganizationID)
+	}
+	return c.JSON(toApiProjectFor(project, role))
+}
+
+type DeleteProject struct {
+	Password string `json:"password" validate:"required,min=8,max=32"`
+}
+
+func deleteProject(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 
 	var body DeleteProject
@@ -197,31 +452,49 @@ func deleteProject(c *fiber.Ctx) error {
 		return c.Status(400).JSON(errors.UserCredentialsInvalid)
 	}
 	// Check password and error if it is wrong
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	if err := password.Compare(user.Password, body.Password); err != nil {
 		return c.Status(400).JSON(errors.UserCredentialsInvalid)
 	}
 
-	var project structs.Project
-	// Get project and error if not found
-	err = db.Where(&structs.Project{ID: projectId}).First(&project).Error
-	if err == gorm.ErrRecordNotFound {
-		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
-	}
-	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	// Only the owner can delete a project
+	project, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleOwner)
+	if rtrn {
+		return err
 	}
 
-	err = db.Delete(&project).Error
+	provider, repo, err, rtrn := getProjectRepo(c, project)
+	if rtrn {
+		return err
+	}
+	members, err := projectMembers(project.ID)
 	if err != nil {
+		fmt.Println(err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
 	}
-
-	// Delete repo and recreate project if it fails
-	name := parsed.UserID + "-" + projectId
-	_, err = git.DeleteRepo("runikbot", name)
-	if err != nil {
-		db.Create(project)
+	// The repository is deleted last so the rows stay when it fails
+	errRepo := goerrors.New("repository was not deleted")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := removeProjectRows(tx, project.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&project).Error; err != nil {
+			return err
+		}
+		if err := provider.DeleteRepo(ctx, repo); err != nil && !git.IsNotFound(err) {
+			fmt.Println(err.Error())
+			return errRepo
+		}
+		return nil
+	})
+	if err == errRepo {
 		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateProjects(append(members, project.UserID)...)
+	if project.OrganizationID != nil {
+		invalidateOrganizationProjects(*project.OrganizationID)
 	}
 	return c.Status(http.StatusNoContent).Send(nil)
 }
@@ -239,22 +512,9 @@ type fileInfo struct {
 }
 
 func updateContents(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 
 	var body UpdateBody
@@ -265,83 +525,73 @@ func updateContents(c *fiber.Ctx) error {
 	if err, rtrn := handleValidateErrors(errs, c); rtrn {
 		return err
 	}
-	var project structs.Project
-	err = db.Where(&structs.Project{ID: body.ProjectId}).First(&project).Error
-	if err == gorm.ErrRecordNotFound {
+	project, _, err, rtrn := getProjectAccess(c, body.ProjectId, parsed.UserID, roleEditor)
+	if rtrn {
+		return err
+	}
+
+	provider, repo, err, rtrn := getProjectRepo(c, project)
+	if rtrn {
+		return err
+	}
+
+	tree, err := provider.ReadTree(ctx, repo, project.DevBranch)
+	if git.IsNotFound(err) {
 		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
 	} else if err != nil {
-		return c.Status(500).JSON(errors.ServerSqlError)
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
 	}
-	if project.UserID != parsed.UserID {
-		return c.Status(http.StatusForbidden).JSON(errors.ProjectNoAccess)
+	existing := map[string]string{}
+	for _, entry := range tree {
+		if entry.Type == git.Blob {
+			existing[entry.Path] = entry.SHA
+		}
 	}
 
-	name := parsed.UserID + "-" + body.ProjectId
-
-	var wg sync.WaitGroup
-	var mu sync.Mutex
+	// Unchanged files and deletes of missing files are left out of the commit
 	var result []fileInfo
+	var changes []git.FileChange
 	for path, fileContent := range body.Files {
-		wg.Add(1)
-		go func(p, c string) {
-			defer wg.Done()
-			content, _, err := git.GetContents("runikbot", name, "dev", p)
-
-			encoded := base64.StdEncoding.EncodeToString([]byte(c))
-			if err != nil {
-				mu.Lock()
-				defer mu.Unlock()
-				result = append(result, fileInfo{
-					Path:      p,
-					Content:   &encoded,
-					Operation: "create",
-				})
-			} else {
-				if *content.Content == encoded {
-					return
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				result = append(result, fileInfo{
-					Path:      p,
-					Content:   &encoded,
-					Operation: "update",
-					SHA:       &content.SHA,
-				})
-			}
-
-		}(path, fileContent)
+		content := []byte(fileContent)
+		encoded := base64.StdEncoding.EncodeToString(content)
+		sha, exists := existing[path]
+		if !exists {
+			result = append(result, fileInfo{Path: path, Content: &encoded, Operation: "create"})
+		} else if sha != git.BlobSHA(content) {
+			result = append(result, fileInfo{Path: path, Content: &encoded, Operation: "update", SHA: &sha})
+		} else {
+			continue
+		}
+		changes = append(changes, git.FileChange{Path: path, Content: content})
 	}
 	for _, path := range body.Delete {
-		wg.Add(1)
-		go func(p string) {
-			defer wg.Done()
-
-			content, _, err := git.GetContents("runikbot", name, "dev", p)
-			if err != nil {
-				return
-			}
-
-			mu.Lock()
-			defer mu.Unlock()
-			result = append(result, fileInfo{
-				Path:      p,
-				Operation: "delete",
-				SHA:       &content.SHA,
-			})
-		}(path)
-	}
-	wg.Wait()
-	if len(result) == 0 {
+		sha, exists := existing[path]
+		if !exists {
+			continue
+		}
+		result = append(result, fileInfo{Path: path, Operation: "delete", SHA: &sha})
+		changes = append(changes, git.FileChange{Path: path, Delete: true})
+	}
+	if len(changes) == 0 {
 		return c.Status(http.StatusNoContent).Send(nil)
 	}
-	reqBody, err := sonic.Marshal(fiber.Map{"author": fiber.Map{"name": parsed.UserID}, "branch": "dev", "message": "update contents", "files": result})
-	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStringifyError)
+
+	name, email, err, rtrn := commitAuthor(c, parsed.UserID)
+	if rtrn {
+		return err
 	}
-	url := env.GitUrl + "/api/v1/repos/" + env.GitUsername + "/" + name + "/contents?ref=dev&token=" + env.GitToken
-	_, err = http.Post(url, "application/json", bytes.NewReader(reqBody))
-	if err != nil {
+	_, err = provider.Commit(ctx, repo, git.Commit{
+		Branch:      project.DevBranch,
+		Message:     "update contents",
+		AuthorName:  name,
+		AuthorEmail: email,
+		Changes:     changes,
+	})
+	if goerrors.Is(err, git.ErrInvalid) {
+		return c.Status(http.StatusBadRequest).JSON(errors.InvalidPath)
+	} else if err != nil {
+		fmt.Println(err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
 	}
 
@@ -352,72 +602,68 @@ func getContents(c *fiber.Ctx) error {
 	if projectId == "" {
 		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
 	}
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
+	project, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleViewer)
+	if rtrn {
+		return err
 	}
-
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	provider, repo, err, rtrn := getProjectRepo(c, project)
+	if rtrn {
+		return err
 	}
-	name := parsed.UserID + "-" + projectId
-	branch, _, err := git.GetRepoBranch("runikbot", name, "dev")
-
-	if err != nil && err.Error() == "The target couldn't be found." {
+	tree, err := provider.ReadTree(ctx, repo, project.DevBranch)
+	if git.IsNotFound(err) {
 		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
-	}
-	if err != nil {
+	} else if err != nil {
+		fmt.Println(err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
 	}
-	sha := branch.Commit.ID
-	url := env.GitUrl + "/api/v1/repos/" + env.GitUsername + "/" + name + "/git/trees/" + sha + "?recursive=true&token=" + env.GitToken
-	req, _ := http.Get(url)
-	data, err := convertToFiles(req.Body)
-	if err != nil {
-		fmt.Println("err", err.Error())
-	}
-	return c.Status(req.StatusCode).JSON(data)
+	return c.JSON(toFileTree(tree))
 }
 
-func readToString(reader io.ReadCloser) (string, error) {
-	defer reader.Close()
-
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return "", err
-	}
-
-	return string(data), nil
+// fileNode is a file, or a directory when Files is set
+type fileNode struct {
+	Name     string      `json:"name"`
+	FullPath string      `json:"fullPath,omitempty"`
+	Files    *[]fileNode `json:"files,omitempty"`
 }
-func convertToFiles(reader io.ReadCloser) (interface{}, error) {
-	jsonString, err := readToString(reader)
-	if err != nil {
-		return nil, err
-	}
-	cmd := exec.Command("node", "./routes/convert.js", "--json", jsonString)
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Println("Error executing command:", err)
-		return nil, err
-	}
-	var json []interface{}
-	err = sonic.Unmarshal(output, &json)
-	if err != nil {
-		fmt.Println("Error executing command:", err)
-		return nil, err
+// toFileTree nests a recursive tree listing into directories
+func toFileTree(entries []git.TreeEntry) []fileNode {
+	root := []fileNode{}
+	for _, entry := range entries {
+		names := strings.Split(entry.Path, "/")
+		current := &root
+		for i, name := range names {
+			index := -1
+			for j := range *current {
+				if (*current)[j].Name == name {
+					index = j
+					break
+				}
+			}
+			if i == len(names)-1 {
+				if entry.Type == git.Blob {
+					*current = append(*current, fileNode{Name: name, FullPath: entry.Path})
+				} else if index == -1 {
+					*current = append(*current, fileNode{Name: name, Files: &[]fileNode{}})
+				}
+				break
+			}
+			if index == -1 {
+				*current = append(*current, fileNode{Name: name, Files: &[]fileNode{}})
+				index = len(*current) - 1
+			}
+			if (*current)[index].Files == nil {
+				break
+			}
+			current = (*current)[index].Files
+		}
 	}
-	return json, nil
+	return root
 }
 func getFile(c *fiber.Ctx) error {
 	path := c.Query("path")
@@ -428,28 +674,24 @@ func getFile(c *fiber.Ctx) error {
 	if projectId == "" {
 		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
 	}
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
+	project, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleViewer)
+	if rtrn {
+		return err
 	}
-
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	provider, repo, err, rtrn := getProjectRepo(c, project)
+	if rtrn {
+		return err
 	}
-	name := parsed.UserID + "-" + projectId
-	file, _, _ := git.GetFile(env.GitUsername, name, "dev", path)
-	if file == nil {
+	file, err := provider.ReadFile(ctx, repo, project.DevBranch, path)
+	if git.IsNotFound(err) || goerrors.Is(err, git.ErrInvalid) {
 		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
 	}
 	return c.Send(file)
 }
@@ -0,0 +1,173 @@
+package routes
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net/http"
+
+	"api/errors"
+	"api/git"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	promotionFastForward = "fast_forward"
+	promotionMerge       = "merge"
+	promotionPullRequest = "pull_request"
+)
+
+func toApiPromotion(promotion structs.Promotion) structs.ApiPromotion {
+	return structs.ApiPromotion{
+		ID:             promotion.ID,
+		ProjectID:      promotion.ProjectID,
+		UserID:         promotion.UserID,
+		Method:         promotion.Method,
+		DevSHA:         promotion.DevSHA,
+		ProdSHA:        promotion.ProdSHA,
+		PullRequestURL: promotion.PullRequestURL,
+		CreatedAt:      promotion.CreatedAt,
+	}
+}
+
+type PromoteProject struct {
+	// Open a pull request instead of merging right away
+	PullRequest bool   `json:"pull_request"`
+	Message     string `json:"message" validate:"max=500"`
+}
+
+// promoteProject brings the development branch into the production branch.
+// Admins merge, editors can open a pull request for someone else to merge.
+func promoteProject(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PromoteProject
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	required := roleAdmin
+	if body.PullRequest {
+		required = roleEditor
+	}
+	project, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, required)
+	if rtrn {
+		return err
+	}
+	provider, repo, err, rtrn := getProjectRepo(c, project)
+	if rtrn {
+		return err
+	}
+	if body.Message == "" {
+		body.Message = "Promote " + project.DevBranch + " to " + project.ProdBranch
+	}
+
+	promotion := structs.Promotion{
+		ID:        generator.Generate().String(),
+		ProjectID: project.ID,
+		UserID:    parsed.UserID,
+	}
+	if body.PullRequest {
+		promotion.Method = promotionPullRequest
+		promotion.DevSHA, err = provider.BranchHead(ctx, repo, project.DevBranch)
+		if err == nil {
+			var pr git.PullRequest
+			pr, err = provider.OpenPullRequest(ctx, repo, project.ProdBranch, project.DevBranch, body.Message, "")
+			promotion.PullRequestURL = pr.URL
+		}
+	} else {
+		name, email, authorErr, rtrn := commitAuthor(c, parsed.UserID)
+		if rtrn {
+			return authorErr
+		}
+		var result git.MergeResult
+		result, err = provider.Merge(ctx, repo, git.Merge{
+			Base:        project.ProdBranch,
+			Head:        project.DevBranch,
+			Message:     body.Message,
+			AuthorName:  name,
+			AuthorEmail: email,
+		})
+		if err == nil && len(result.Conflicts) > 0 {
+			return c.Status(http.StatusConflict).JSON(errors.PromotionConflicts(result.Conflicts))
+		}
+		promotion.Method = promotionMerge
+		if result.FastForward {
+			promotion.Method = promotionFastForward
+		}
+		promotion.DevSHA, promotion.ProdSHA = result.HeadSHA, result.SHA
+	}
+	switch {
+	case goerrors.Is(err, git.ErrUpToDate):
+		return c.Status(http.StatusConflict).JSON(errors.ProjectUpToDate)
+	case goerrors.Is(err, git.ErrExists):
+		return c.Status(http.StatusConflict).JSON(errors.ProjectPullRequestExists)
+	case goerrors.Is(err, git.ErrRefused):
+		fmt.Println(err.Error())
+		return c.Status(http.StatusConflict).JSON(errors.PromotionRefused)
+	case goerrors.Is(err, git.ErrUnsupported):
+		return c.Status(http.StatusBadRequest).JSON(errors.PullRequestsUnsupported)
+	case git.IsNotFound(err):
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	case err != nil:
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
+	}
+
+	// The branches already moved, a failed record is only logged
+	if err := db.Create(&promotion).Error; err != nil {
+		fmt.Println(err.Error())
+	}
+	return c.Status(http.StatusCreated).JSON(toApiPromotion(promotion))
+}
+
+// commitAuthor returns the name and email a user's commits are signed with,
+// the display name or else the username.
+// When it fails the error response has already been written and should be returned.
+func commitAuthor(c *fiber.Ctx, userId string) (string, string, error, bool) {
+	var user structs.User
+	if err := db.Model(&structs.User{}).Select("display_name", "username", "email").Where(&structs.User{ID: userId}).First(&user).Error; err != nil {
+		fmt.Println(err.Error())
+		return "", "", c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	name := user.DisplayName
+	if name == "" && user.Username != nil {
+		name = *user.Username
+	}
+	return name, user.Email, nil, false
+}
+
+// getPromotions lists the promotions of a project, newest first
+func getPromotions(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleViewer); rtrn {
+		return err
+	}
+	var promotions []structs.Promotion
+	if err := db.Where(&structs.Promotion{ProjectID: projectId}).Order("created_at desc").Find(&promotions).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiPromotion, 0, len(promotions))
+	for _, promotion := range promotions {
+		result = append(result, toApiPromotion(promotion))
+	}
+	return c.JSON(result)
+}
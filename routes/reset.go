@@ -5,12 +5,12 @@ import (
 	"time"
 
 	"api/errors"
+	"api/password"
 	"api/structs"
 	"api/utils"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func sendResetEmail(c *fiber.Ctx, email string, url string, token string) error {
@@ -41,8 +41,12 @@ func postReset(c *fiber.Ctx) error {
 
 		return err
 	}
+	if err, rtrn := checkCaptcha(c, captchaReset, body.Email, ""); rtrn {
+		return err
+	}
 	available, user := emailAvailable(body.Email)
 	if available {
+		recordFailure(captchaReset, body.Email)
 		return c.Status(400).JSON(errors.NotFound)
 	}
 	token, tokenErr := utils.RandString(32)
@@ -52,6 +56,7 @@ func postReset(c *fiber.Ctx) error {
 	if err := sendResetEmail(c, body.Email, body.Url, token); err != nil {
 		return err
 	}
+	recordSend(captchaReset, body.Email)
 
 	_, redErr := rdb.Set(ctx, "reset:"+token, user.ID, 30*time.Minute).Result()
 	if redErr != nil {
@@ -84,11 +89,13 @@ func putReset(c *fiber.Ctx) error {
 
 		return err
 	}
-	rdb.Del(ctx, "reset:"+token)
-	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
-	if err != nil {
+	hash, err := password.Hash(body.Password)
+	if err == password.ErrTooLong {
+		return c.Status(http.StatusBadRequest).JSON(errors.PasswordTooLong)
+	} else if err != nil {
 		return c.Status(500).JSON(errors.ServerHash)
 	}
+	rdb.Del(ctx, "reset:"+token)
 	db.Model(&structs.User{}).Where("ID = ?", id).Update("password", hash)
 	return c.Status(http.StatusNoContent).Send(nil)
 }
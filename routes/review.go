@@ -0,0 +1,311 @@
+package routes
+
+import (
+	"bytes"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"api/errors"
+	"api/storage"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var (
+	errProjectGone      = goerrors.New("project of the quarantined asset no longer exists")
+	errAvatarSuperseded = goerrors.New("a newer avatar was submitted after the quarantined one")
+)
+
+type ReviewBody struct {
+	Note string `json:"note" validate:"max=1000"`
+	// Block adds the image to the blocklist when the review rejects it
+	Block bool `json:"block"`
+}
+type AppealBody struct {
+	Message string `json:"message" validate:"required,max=1000"`
+}
+
+// getModerationQueue lists quarantined images waiting for review, appeals first
+func getModerationQueue(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	limit, err, rtrn := queryLimit(c)
+	if rtrn {
+		return err
+	}
+	query := db.Model(&structs.ModerationDecision{}).Where("review = ?", reviewPending).Order("appealed_at IS NULL, created_at").Limit(limit)
+	if c.Query("appealed") == "true" {
+		query = query.Where("appealed_at IS NOT NULL")
+	}
+	return sendModerationDecisions(c, query)
+}
+
+// getPendingReview loads a decision that is still waiting in the review queue
+func getPendingReview(c *fiber.Ctx, where structs.ModerationDecision) (structs.ModerationDecision, error, bool) {
+	var decision structs.ModerationDecision
+	err := db.Where(&where).First(&decision).Error
+	if err == gorm.ErrRecordNotFound {
+		return decision, c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return decision, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	if decision.Review != reviewPending {
+		return decision, c.Status(http.StatusConflict).JSON(errors.ReviewFinished), true
+	}
+	return decision, nil, false
+}
+
+func getDecisionImage(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	decision, err, rtrn := getPendingReview(c, structs.ModerationDecision{ID: c.Params("id")})
+	if rtrn {
+		return err
+	}
+	object, info, err := storage.For(storage.Quarantine).Get(ctx, decision.Key)
+	if storage.IsNotFound(err) {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+	}
+	c.Set(fiber.HeaderContentType, decision.ContentType)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusOK).SendStream(object, int(info.Size))
+}
+
+func approveDecision(c *fiber.Ctx) error {
+	return reviewDecision(c, reviewApproved)
+}
+func rejectDecision(c *fiber.Ctx) error {
+	return reviewDecision(c, reviewRejected)
+}
+
+// reviewDecision finishes a review. Approved images are published as if they
+// had passed moderation, then the quarantined copy is removed either way.
+func reviewDecision(c *fiber.Ctx, review string) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	var body ReviewBody
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+		}
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	decision, err, rtrn := getPendingReview(c, structs.ModerationDecision{ID: c.Params("id")})
+	if rtrn {
+		return err
+	}
+
+	// Claiming the decision first keeps concurrent or repeated reviews from
+	// restoring or deleting the upload twice
+	now := time.Now()
+	claim := db.Model(&structs.ModerationDecision{}).Where("id = ? AND review = ?", decision.ID, reviewPending).Updates(map[string]interface{}{
+		"review":      review,
+		"review_note": body.Note,
+		"reviewed_at": &now,
+	})
+	if claim.Error != nil {
+		fmt.Println(claim.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if claim.RowsAffected == 0 {
+		return c.Status(http.StatusConflict).JSON(errors.ReviewFinished)
+	}
+	unclaim := func() {
+		err := db.Model(&structs.ModerationDecision{}).Where("id = ?", decision.ID).Updates(map[string]interface{}{
+			"review":      reviewPending,
+			"review_note": "",
+			"reviewed_at": nil,
+		}).Error
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+
+	quarantine := storage.For(storage.Quarantine)
+	superseded := false
+	if review == reviewApproved {
+		err := restoreQuarantined(decision)
+		if err == errAvatarSuperseded {
+			// Approving still clears the queue, the newer avatar stays
+			superseded = true
+		} else if err == errProjectGone || storage.IsNotFound(err) {
+			unclaim()
+			return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+		} else if err != nil {
+			unclaim()
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+		}
+	}
+	if review == reviewRejected && body.Block && decision.Hash != "" {
+		if _, err := blockDecision(decision, body.Note); err != nil && err != errHashBlocked {
+			unclaim()
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+	}
+	if err := quarantine.Delete(ctx, decision.Key); err != nil && !storage.IsNotFound(err) {
+		fmt.Println(err.Error())
+	}
+	decision.Review = review
+	decision.ReviewNote = body.Note
+	decision.ReviewedAt = &now
+
+	message := "After a review your " + decision.Subject + " upload was " + review + "."
+	if superseded {
+		message += " It was not applied since you uploaded a newer avatar in the meantime."
+	}
+	if body.Note != "" {
+		message += "\r\n\r\n" + body.Note
+	}
+	notifyUser(decision.UserID, "Your upload was "+review, message)
+	return c.JSON(toApiModerationDecision(decision))
+}
+
+// restoreQuarantined publishes an approved image where it would have gone without moderation.
+// An avatar is only published while it is still the latest avatar the user submitted.
+func restoreQuarantined(decision structs.ModerationDecision) error {
+	if decision.Subject == moderationSubjectAvatar {
+		var newer int64
+		err := db.Model(&structs.ModerationDecision{}).
+			Where("user_id = ? AND subject = ? AND id > ?", decision.UserID, moderationSubjectAvatar, decision.ID).
+			Count(&newer).Error
+		if err != nil {
+			return err
+		}
+		if newer > 0 {
+			return errAvatarSuperseded
+		}
+	}
+	object, _, err := storage.For(storage.Quarantine).Get(ctx, decision.Key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(object)
+	object.Close()
+	if err != nil {
+		return err
+	}
+
+	switch decision.Subject {
+	case moderationSubjectAvatar:
+		img, err := storage.DecodeImage(data, avatarMaxDimension)
+		if err != nil {
+			return err
+		}
+		encoded, err := encodeAvatar(img)
+		if err != nil {
+			return err
+		}
+		return storeAvatar(decision.UserID, encoded)
+
+	case moderationSubjectAsset:
+		if decision.ProjectID == nil {
+			return errProjectGone
+		}
+		var project structs.Project
+		err := db.Where(&structs.Project{ID: *decision.ProjectID}).First(&project).Error
+		if err == gorm.ErrRecordNotFound {
+			return errProjectGone
+		} else if err != nil {
+			return err
+		}
+		asset := structs.Asset{
+			ID:          decision.SubjectID,
+			ProjectID:   project.ID,
+			UserID:      decision.UserID,
+			Key:         storage.AssetKey(project.ID, decision.SubjectID, decision.Name),
+			Name:        decision.Name,
+			ContentType: decision.ContentType,
+			Size:        int64(len(data)),
+		}
+		err = storage.For(storage.Assets).Put(ctx, asset.Key, bytes.NewReader(data), asset.Size, asset.ContentType)
+		if err != nil {
+			return err
+		}
+		return db.Create(&asset).Error
+	}
+	return fmt.Errorf("unknown moderation subject %s", decision.Subject)
+}
+
+// getMyModeration lists the uploads of the user that were quarantined
+func getMyModeration(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query := db.Model(&structs.ModerationDecision{}).Where("user_id = ? AND review <> ''", parsed.UserID).Order("created_at desc")
+	return sendModerationDecisions(c, query)
+}
+func postAppeal(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body AppealBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	decision, err, rtrn := getPendingReview(c, structs.ModerationDecision{ID: c.Params("id"), UserID: parsed.UserID})
+	if rtrn {
+		return err
+	}
+	if decision.AppealedAt != nil {
+		return c.Status(http.StatusConflict).JSON(errors.AppealExists)
+	}
+
+	now := time.Now()
+	decision.Appeal = body.Message
+	decision.AppealedAt = &now
+	err = db.Model(&structs.ModerationDecision{}).Where("id = ? AND appealed_at IS NULL", decision.ID).Updates(map[string]interface{}{
+		"appeal":      decision.Appeal,
+		"appealed_at": decision.AppealedAt,
+	}).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	notifyModerators("Moderation appeal "+decision.ID, "User "+decision.UserID+" appealed the rejection of their "+decision.Subject+" upload:\r\n\r\n"+body.Message)
+	return c.JSON(toApiModerationDecision(decision))
+}
+
+// notifyUser emails a user in the background. Notifications are best effort and failures are only logged.
+func notifyUser(userId string, subject string, message string) {
+	go func() {
+		var user structs.User
+		if err := db.Model(&structs.User{}).Select("email").Where(&structs.User{ID: userId}).First(&user).Error; err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		sender.SendEmail(user.Email, subject, message)
+	}()
+}
+
+// notifyModerators emails every address in MODERATION_ADMIN_EMAILS in the background
+func notifyModerators(subject string, message string) {
+	for _, address := range strings.Split(env.ModerationAdminEmails, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			go sender.SendEmail(address, subject, message)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"api/errors"
+	"api/password"
+	"api/secrets"
 	"api/structs"
 	"api/utils"
 
@@ -14,7 +16,6 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pquerna/otp/totp"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type PostSessions struct {
@@ -31,11 +32,11 @@ func getExpiration(expires bool) time.Duration {
 		return time.Hour * 24 * 30 * 6
 	}
 }
-func getIp(body PostSessions, c *fiber.Ctx) string {
-	if body.IP == nil {
+func getIp(ip *string, c *fiber.Ctx) string {
+	if ip == nil {
 		return c.IP()
 	} else {
-		return *body.IP
+		return *ip
 	}
 }
 func postSessions(c *fiber.Ctx) error {
@@ -53,21 +54,36 @@ func postSessions(c *fiber.Ctx) error {
 		return err
 	}
 
+	ip := getIp(body.IP, c)
+	if err, rtrn := checkCaptcha(c, captchaSessions, ip, forwardedIp(body.IP)); rtrn {
+		return err
+	}
+
 	var user structs.User
 	if err := db.Where(&structs.User{Email: body.Email}).First(&user).Error; err != nil {
 		fmt.Println("User not found")
+		recordFailure(captchaSessions, ip)
 		return c.Status(http.StatusNotFound).JSON(errors.UserCredentialsInvalid)
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	if err := password.Compare(user.Password, body.Password); err != nil {
 		fmt.Println("password wrong")
+		recordFailure(captchaSessions, ip)
 		return c.Status(http.StatusUnauthorized).JSON(errors.UserCredentialsInvalid)
 	}
+	clearFailures(captchaSessions, ip)
+	// Upgrade hashes made with an old algorithm or parameters while the plaintext is known
+	if password.NeedsRehash(user.Password) {
+		if hash, err := password.Hash(body.Password); err == nil {
+			db.Model(&structs.User{}).Where("ID = ?", user.ID).Update("password", hash)
+		} else {
+			fmt.Println(err.Error())
+		}
+	}
 	if user.TotpVerified {
 		id := generator.Generate()
 		rdb.Set(ctx, "totp:"+id.String(), user.ID, time.Minute*15)
 		return c.Status(http.StatusOK).JSON(fiber.Map{"action_name": "totp", "totp_id": id.String()})
 	}
-	ip := getIp(body, c)
 	expiration := getExpiration(body.Expire)
 	token, err := utils.RandString(32)
 	if err != nil {
@@ -114,7 +130,12 @@ func confirm2faSignIn(c *fiber.Ctx) error {
 		fmt.Println("User not found")
 		return c.Status(http.StatusNotFound).JSON(errors.UserCredentialsInvalid)
 	}
-	valid := totp.Validate(code, user.TotpSecret)
+	secret, err := secrets.Decrypt(user.TotpSecret, user.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerEncryptError)
+	}
+	valid := totp.Validate(code, secret)
 	if !valid {
 		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"code": "invalid_totp"})
 	}
@@ -161,22 +182,9 @@ func getSessionIps(c *fiber.Ctx, userId string) ([]string, error) {
 	return sessions, nil
 }
 func getSessions(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(errors.ServerParseError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 	sessions, err := getSessionIps(c, parsed.UserID)
 	if err != nil {
@@ -221,9 +229,9 @@ func deleteSessionEntries(parsed structs.Session, c *fiber.Ctx) ([]string, error
 	return keys, nil
 }
 func deleteSessions(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationMissing)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 	var body DeleteSessions
 	if err := c.BodyParser(&body); err != nil {
@@ -234,25 +242,11 @@ func deleteSessions(c *fiber.Ctx) error {
 
 		return err
 	}
-
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(errors.ServerParseError)
-	}
 	var user structs.User
 	if err := db.Model(&structs.User{}).Where(&structs.User{ID: parsed.UserID}).Select("password").First(&user).Error; err != nil {
 		return c.Status(http.StatusBadRequest).JSON(errors.UserCredentialsInvalid)
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	if err := password.Compare(user.Password, body.Password); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(errors.UserCredentialsInvalid)
 	}
 	keys, err := deleteSessionEntries(parsed, c)
@@ -0,0 +1,223 @@
+package routes
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"api/database"
+	"api/git"
+	"api/secrets"
+	"api/structs"
+)
+
+const (
+	settingString = "string"
+	settingPath   = "path"
+	settingEnv    = "env"
+)
+
+// Limits of setting values when the template does not set Max
+var settingMaxDefaults = map[string]int{settingString: 256, settingPath: 500, settingEnv: 100}
+
+const (
+	envNameMax  = 128
+	envValueMax = 4096
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Compiled patterns of template settings by pattern
+var settingPatterns sync.Map
+
+// settingPattern compiles the pattern of a setting once, anchored to the whole value
+func settingPattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, ok := settingPatterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	settingPatterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+// Settings of projects whose template declares none
+var defaultSettings = []structs.TemplateSetting{
+	{Name: "runtime_version", Description: "version of the runtime", Type: settingString, Max: 32},
+	{Name: "entry_file", Description: "file the project starts from", Type: settingPath},
+	{Name: "environment", Description: "environment variables", Type: settingEnv},
+}
+
+// templateSettings returns the settings projects from a template have
+func templateSettings(template structs.Template) []structs.TemplateSetting {
+	if len(template.Settings) == 0 {
+		return defaultSettings
+	}
+	return template.Settings
+}
+
+// checkSettings validates the settings a template declares
+func checkSettings(settings []structs.TemplateSetting) error {
+	seen := map[string]bool{}
+	for _, setting := range settings {
+		if !variableNamePattern.MatchString(setting.Name) || len(setting.Name) > 64 {
+			return fmt.Errorf("invalid setting name %q", setting.Name)
+		}
+		if seen[setting.Name] {
+			return fmt.Errorf("setting %s is declared twice", setting.Name)
+		}
+		seen[setting.Name] = true
+		if _, ok := settingMaxDefaults[setting.Type]; !ok {
+			return fmt.Errorf("setting %s has unknown type %q", setting.Name, setting.Type)
+		}
+		if setting.Max < 0 {
+			return fmt.Errorf("setting %s has a negative max", setting.Name)
+		}
+		if setting.Pattern != "" {
+			if setting.Type == settingEnv {
+				return fmt.Errorf("setting %s of type env cannot have a pattern", setting.Name)
+			}
+			if _, err := settingPattern(setting.Pattern); err != nil {
+				return fmt.Errorf("pattern of %s: %s", setting.Name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// applySettings writes values into the settings of a project, null removes a
+// setting. Variables of env settings are encrypted, null keeps the value a
+// variable has. Problems are keyed by setting name: unknown, type, max, pattern or invalid.
+func applySettings(projectId string, schema []structs.TemplateSetting, settings map[string]interface{}, values map[string]interface{}) (map[string]interface{}, map[string]string, error) {
+	declared := map[string]structs.TemplateSetting{}
+	for _, setting := range schema {
+		declared[setting.Name] = setting
+	}
+	result := map[string]interface{}{}
+	for name, value := range settings {
+		result[name] = value
+	}
+	problems := map[string]string{}
+	for name, value := range values {
+		setting, ok := declared[name]
+		if !ok {
+			problems[name] = "unknown"
+			continue
+		}
+		if value == nil {
+			delete(result, name)
+			continue
+		}
+		if problem := checkSetting(setting, value); problem != "" {
+			problems[name] = problem
+			continue
+		}
+		if setting.Type == settingEnv {
+			sealed, problem, err := sealVariables(projectId, name, value.(map[string]interface{}), result[name])
+			if err != nil {
+				return nil, nil, err
+			}
+			if problem != "" {
+				problems[name] = problem
+				continue
+			}
+			value = sealed
+		}
+		result[name] = value
+	}
+	return result, problems, nil
+}
+
+// sealVariables encrypts the variables of an env setting, bound to the
+// project, setting and variable. Variables set to null keep their stored value.
+func sealVariables(projectId string, name string, variables map[string]interface{}, previous interface{}) (map[string]interface{}, string, error) {
+	stored, _ := previous.(map[string]interface{})
+	sealed := map[string]interface{}{}
+	for variable, value := range variables {
+		if value == nil {
+			kept, ok := stored[variable]
+			if !ok {
+				return nil, "invalid", nil
+			}
+			sealed[variable] = kept
+			continue
+		}
+		encoded, _, err := secrets.Encrypt(value.(string), database.EnvironmentData(projectId, name, variable))
+		if err != nil {
+			return nil, "", err
+		}
+		sealed[variable] = encoded
+	}
+	return sealed, "", nil
+}
+
+// hideVariables replaces the values of env settings with null, they are
+// write-only through the API
+func hideVariables(settings map[string]interface{}) map[string]interface{} {
+	hidden := map[string]interface{}{}
+	for name, value := range settings {
+		if variables, ok := value.(map[string]interface{}); ok {
+			names := map[string]interface{}{}
+			for variable := range variables {
+				names[variable] = nil
+			}
+			value = names
+		}
+		hidden[name] = value
+	}
+	return hidden
+}
+
+// checkSetting returns why value is not valid for setting, empty when it is
+func checkSetting(setting structs.TemplateSetting, value interface{}) string {
+	max := setting.Max
+	if max == 0 {
+		max = settingMaxDefaults[setting.Type]
+	}
+	if setting.Type == settingEnv {
+		variables, ok := value.(map[string]interface{})
+		if !ok {
+			return "type"
+		}
+		if len(variables) > max {
+			return "max"
+		}
+		for name, variable := range variables {
+			if len(name) > envNameMax || !envNamePattern.MatchString(name) {
+				return "invalid"
+			}
+			// null keeps the stored value
+			if variable == nil {
+				continue
+			}
+			text, ok := variable.(string)
+			if !ok {
+				return "type"
+			}
+			if len(text) > envValueMax {
+				return "invalid"
+			}
+		}
+		return ""
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return "type"
+	}
+	if len(text) > max {
+		return "max"
+	}
+	if setting.Type == settingPath && !git.ValidPath(text) {
+		return "invalid"
+	}
+	if setting.Pattern != "" {
+		pattern, err := settingPattern(setting.Pattern)
+		if err != nil || !pattern.MatchString(text) {
+			return "pattern"
+		}
+	}
+	return ""
+}
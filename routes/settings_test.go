@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"api/database"
+	"api/secrets"
+	"api/structs"
+)
+
+// testSecrets gives secrets a key for one test
+func testSecrets(t *testing.T) {
+	t.Helper()
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	if err := secrets.Connect(&structs.Environment{EncryptionKeys: "test:" + key}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { secrets.Connect(&structs.Environment{}) })
+}
+
+var testSettings = []structs.TemplateSetting{
+	{Name: "version", Type: settingString, Max: 8, Pattern: `[0-9.]+`},
+	{Name: "entry", Type: settingPath},
+	{Name: "environment", Type: settingEnv, Max: 2},
+}
+
+func TestApplySettings(t *testing.T) {
+	testSecrets(t)
+	stored := map[string]interface{}{"version": "1.0", "entry": "main.go"}
+
+	result, problems, err := applySettings("1", testSettings, stored, map[string]interface{}{"version": "1.2", "entry": nil})
+	if err != nil || len(problems) != 0 || !reflect.DeepEqual(result, map[string]interface{}{"version": "1.2"}) {
+		t.Fatalf("applySettings = %v, %v, %v", result, problems, err)
+	}
+	if stored["version"] != "1.0" || stored["entry"] != "main.go" {
+		t.Fatalf("stored settings were changed: %v", stored)
+	}
+
+	_, problems, err = applySettings("1", testSettings, stored, map[string]interface{}{
+		"color":       "red",
+		"version":     "latest",
+		"entry":       "../main.go",
+		"environment": map[string]interface{}{"A": "1", "B": "2", "C": "3"},
+	})
+	want := map[string]string{"color": "unknown", "version": "pattern", "entry": "invalid", "environment": "max"}
+	if err != nil || !reflect.DeepEqual(problems, want) {
+		t.Fatalf("problems = %v, %v, want %v", problems, err, want)
+	}
+
+	_, problems, _ = applySettings("1", testSettings, stored, map[string]interface{}{"version": 1.2, "environment": map[string]interface{}{"1A": "x"}})
+	if want := map[string]string{"version": "type", "environment": "invalid"}; !reflect.DeepEqual(problems, want) {
+		t.Fatalf("problems = %v, want %v", problems, want)
+	}
+}
+
+func TestSealVariables(t *testing.T) {
+	testSecrets(t)
+	result, problems, err := applySettings("1", testSettings, nil, map[string]interface{}{
+		"environment": map[string]interface{}{"TOKEN": "secret", "PORT": "8080"},
+	})
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("applySettings = %v, %v", problems, err)
+	}
+	sealed := result["environment"].(map[string]interface{})
+	for variable, plaintext := range map[string]string{"TOKEN": "secret", "PORT": "8080"} {
+		encoded := sealed[variable].(string)
+		if !secrets.IsEncrypted(encoded) {
+			t.Fatalf("%s was stored as %q", variable, encoded)
+		}
+		if decrypted, err := secrets.Decrypt(encoded, database.EnvironmentData("1", "environment", variable)); err != nil || decrypted != plaintext {
+			t.Fatalf("%s decrypted to %q, %v", variable, decrypted, err)
+		}
+	}
+	// Values are bound to their project, setting and variable
+	if _, err := secrets.Decrypt(sealed["TOKEN"].(string), database.EnvironmentData("2", "environment", "TOKEN")); err == nil {
+		t.Fatal("a value decrypted for another project")
+	}
+	if _, err := secrets.Decrypt(sealed["TOKEN"].(string), database.EnvironmentData("1", "environment", "PORT")); err == nil {
+		t.Fatal("a value decrypted as another variable")
+	}
+
+	// null keeps a stored value and drops the ones left out
+	updated, problem, err := sealVariables("1", "environment", map[string]interface{}{"TOKEN": nil}, sealed)
+	if err != nil || problem != "" || !reflect.DeepEqual(updated, map[string]interface{}{"TOKEN": sealed["TOKEN"]}) {
+		t.Fatalf("keeping TOKEN = %v, %q, %v", updated, problem, err)
+	}
+	if _, problem, _ := sealVariables("1", "environment", map[string]interface{}{"MISSING": nil}, sealed); problem != "invalid" {
+		t.Fatalf("keeping a variable that was never set = %q, want invalid", problem)
+	}
+
+	hidden := hideVariables(result)
+	if !reflect.DeepEqual(hidden["environment"], map[string]interface{}{"TOKEN": nil, "PORT": nil}) {
+		t.Fatalf("hidden = %v", hidden)
+	}
+}
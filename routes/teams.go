@@ -0,0 +1,263 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"api/errors"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// getTeam loads a team of an organization.
+// When it fails the error response has already been written and should be returned.
+func getTeam(c *fiber.Ctx, organizationId string, teamId string) (structs.Team, error, bool) {
+	var team structs.Team
+	err := db.Where(&structs.Team{ID: teamId, OrganizationID: organizationId}).First(&team).Error
+	if err == gorm.ErrRecordNotFound {
+		return team, c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return team, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	return team, nil, false
+}
+
+// teamUsers returns the IDs of the members of a team
+func teamUsers(teamId string) ([]string, error) {
+	var users []string
+	err := db.Model(&structs.TeamMember{}).Where("team_id = ?", teamId).Pluck("user_id", &users).Error
+	return users, err
+}
+
+// invalidateTeamProjects drops the cached project lists of every member of a team
+func invalidateTeamProjects(teamId string) {
+	users, err := teamUsers(teamId)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	invalidateProjects(users...)
+}
+
+// getTeams lists the teams of an organization with their members and projects
+func getTeams(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleMember)
+	if rtrn {
+		return err
+	}
+	var teams []structs.Team
+	if err := db.Where(&structs.Team{OrganizationID: organization.ID}).Order("name").Find(&teams).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiTeam, 0, len(teams))
+	for _, team := range teams {
+		api := structs.ApiTeam{ID: team.ID, OrganizationID: team.OrganizationID, Name: team.Name, Members: []string{}, Projects: []structs.ApiTeamProject{}, CreatedAt: team.CreatedAt}
+		if err := db.Model(&structs.TeamMember{}).Where("team_id = ?", team.ID).Pluck("user_id", &api.Members).Error; err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		if err := db.Model(&structs.TeamProject{}).Where("team_id = ?", team.ID).Find(&api.Projects).Error; err != nil {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+		result = append(result, api)
+	}
+	return c.JSON(result)
+}
+
+type PostTeam struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+func postTeam(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PostTeam
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	team := structs.Team{ID: generator.Generate().String(), OrganizationID: organization.ID, Name: body.Name}
+	if err := db.Create(&team).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusCreated).JSON(structs.ApiTeam{
+		ID:             team.ID,
+		OrganizationID: team.OrganizationID,
+		Name:           team.Name,
+		Members:        []string{},
+		Projects:       []structs.ApiTeamProject{},
+		CreatedAt:      team.CreatedAt,
+	})
+}
+func deleteTeam(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	team, err, rtrn := getTeam(c, organization.ID, c.Params("team"))
+	if rtrn {
+		return err
+	}
+	invalidateTeamProjects(team.ID)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", team.ID).Delete(&structs.TeamMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id = ?", team.ID).Delete(&structs.TeamProject{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&team).Error
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+// putTeamMember adds a member of the organization to a team
+func putTeamMember(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	team, err, rtrn := getTeam(c, organization.ID, c.Params("team"))
+	if rtrn {
+		return err
+	}
+	userId := c.Params("user")
+	role, err := organizationRole(organization.ID, userId)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if role == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.OrganizationMemberMissing)
+	}
+	member := structs.TeamMember{TeamID: team.ID, UserID: userId}
+	if err := db.Where(&member).FirstOrCreate(&member).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateProjects(userId)
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+func deleteTeamMember(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	team, err, rtrn := getTeam(c, organization.ID, c.Params("team"))
+	if rtrn {
+		return err
+	}
+	userId := c.Params("user")
+	result := db.Where(&structs.TeamMember{TeamID: team.ID, UserID: userId}).Delete(&structs.TeamMember{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	invalidateProjects(userId)
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+type PutTeamProject struct {
+	Role string `json:"role" validate:"required,oneof=viewer editor admin"`
+}
+
+// putTeamProject gives a team a role on a project of the organization
+func putTeamProject(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PutTeamProject
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	team, err, rtrn := getTeam(c, organization.ID, c.Params("team"))
+	if rtrn {
+		return err
+	}
+	var project structs.Project
+	err = db.Where("id = ? AND organization_id = ?", c.Params("project"), organization.ID).First(&project).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	access := structs.TeamProject{TeamID: team.ID, ProjectID: project.ID}
+	err = db.Where(&access).Assign(structs.TeamProject{Role: body.Role}).FirstOrCreate(&access).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	invalidateTeamProjects(team.ID)
+	return c.JSON(structs.ApiTeamProject{ProjectID: access.ProjectID, Role: access.Role})
+}
+func deleteTeamProject(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	organization, _, err, rtrn := getOrganizationAccess(c, c.Params("id"), parsed.UserID, orgRoleAdmin)
+	if rtrn {
+		return err
+	}
+	team, err, rtrn := getTeam(c, organization.ID, c.Params("team"))
+	if rtrn {
+		return err
+	}
+	result := db.Where(&structs.TeamProject{TeamID: team.ID, ProjectID: c.Params("project")}).Delete(&structs.TeamProject{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	invalidateTeamProjects(team.ID)
+	return c.Status(http.StatusNoContent).Send(nil)
+}
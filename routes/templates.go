@@ -0,0 +1,376 @@
+package routes
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"api/errors"
+	"api/git"
+	"api/structs"
+
+	"github.com/bytedance/sonic"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	templatePublic   = "public"
+	templateUnlisted = "unlisted"
+	templatePrivate  = "private"
+)
+
+// Template names and organization slugs become part of repository names and URLs
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func validateSlug(fl validator.FieldLevel) bool {
+	return slugPattern.MatchString(fl.Field().String())
+}
+
+type PostTemplate struct {
+	Name        string   `json:"name" validate:"required,max=64,slug"`
+	Title       string   `json:"title" validate:"required,max=64"`
+	Description string   `json:"description" validate:"max=500"`
+	Language    string   `json:"language" validate:"max=32"`
+	Icon        string   `json:"icon" validate:"omitempty,url,max=500"`
+	Tags        []string `json:"tags" validate:"max=10,dive,min=1,max=32"`
+	Visibility  string   `json:"visibility" validate:"omitempty,oneof=public unlisted private"`
+	// Organizations whose members can use the template when it is private
+	Organizations []string `json:"organizations" validate:"max=50,dive,min=1,max=64"`
+	Provider      string   `json:"provider" validate:"max=32"`
+	Owner         string   `json:"owner" validate:"max=100"`
+	Repo          string   `json:"repo" validate:"max=100"`
+	ProdBranch    string   `json:"prod_branch" validate:"max=100"`
+	DevBranch     string   `json:"dev_branch" validate:"max=100"`
+	// Settings of projects created from the template, the default settings when empty
+	Settings []structs.TemplateSetting `json:"settings" validate:"max=50"`
+}
+type PatchTemplate struct {
+	Title       *string   `json:"title" validate:"omitempty,min=1,max=64"`
+	Description *string   `json:"description" validate:"omitempty,max=500"`
+	Language    *string   `json:"language" validate:"omitempty,max=32"`
+	Icon        *string   `json:"icon" validate:"omitempty,url,max=500"`
+	Tags        *[]string `json:"tags" validate:"omitempty,max=10,dive,min=1,max=32"`
+	Visibility  *string   `json:"visibility" validate:"omitempty,oneof=public unlisted private"`
+	// Replaces the organizations whose members can use a private template
+	Organizations *[]string `json:"organizations" validate:"omitempty,max=50,dive,min=1,max=64"`
+	Provider      *string   `json:"provider" validate:"omitempty,max=32"`
+	Owner         *string   `json:"owner" validate:"omitempty,max=100"`
+	Repo          *string   `json:"repo" validate:"omitempty,max=100"`
+	ProdBranch    *string   `json:"prod_branch" validate:"omitempty,min=1,max=100"`
+	DevBranch     *string   `json:"dev_branch" validate:"omitempty,min=1,max=100"`
+	// Replaces the settings, an empty list restores the default settings
+	Settings *[]structs.TemplateSetting `json:"settings" validate:"omitempty,max=50"`
+}
+
+func toApiTemplate(template structs.Template) structs.ApiTemplate {
+	tags := []string{}
+	if template.Tags != "" {
+		if err := sonic.UnmarshalString(template.Tags, &tags); err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+	return structs.ApiTemplate{
+		Name:          template.Name,
+		Title:         template.Title,
+		Description:   template.Description,
+		Language:      template.Language,
+		Icon:          template.Icon,
+		Tags:          tags,
+		Visibility:    template.Visibility,
+		Organizations: template.Organizations,
+		Provider:      template.Provider,
+		ProdBranch:    template.ProdBranch,
+		DevBranch:     template.DevBranch,
+		Settings:      templateSettings(template),
+		CreatedAt:     template.CreatedAt,
+		UpdatedAt:     template.UpdatedAt,
+	}
+}
+
+// templateRepo resolves the repository of a template on provider
+func templateRepo(provider git.GitProvider, template structs.Template) git.Repo {
+	repo := git.Repo{Owner: template.Owner, Name: template.Repo}
+	if repo.Owner == "" {
+		repo.Owner = provider.Owner()
+	}
+	if repo.Name == "" {
+		repo.Name = "template_" + template.Name
+	}
+	return repo
+}
+
+// checkTemplateRepo makes sure the production branch of a template exists and
+// its manifest is valid before it is registered.
+// When it fails the error response has already been written and should be returned.
+func checkTemplateRepo(c *fiber.Ctx, template structs.Template) (error, bool) {
+	provider, err := git.For(template.Provider)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.InvalidProvider), true
+	}
+	_, err = provider.BranchHead(ctx, templateRepo(provider, template), template.ProdBranch)
+	if git.IsNotFound(err) || goerrors.Is(err, git.ErrInvalid) {
+		return c.Status(http.StatusBadRequest).JSON(errors.TemplateRepoMissing), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError), true
+	}
+	_, err = loadManifest(provider, templateRepo(provider, template), template.ProdBranch)
+	if goerrors.Is(err, errManifestInvalid) {
+		return c.Status(http.StatusBadRequest).JSON(errors.TemplateManifestInvalid(err)), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError), true
+	}
+	return nil, false
+}
+
+// getUsableTemplate loads a template the user can create projects from,
+// private templates only for members of the organizations they list.
+// When it fails the error response has already been written and should be returned.
+func getUsableTemplate(c *fiber.Ctx, name string, userId string) (structs.Template, error, bool) {
+	var template structs.Template
+	err := db.Where(&structs.Template{Name: name}).First(&template).Error
+	if err == gorm.ErrRecordNotFound {
+		return template, c.Status(http.StatusBadRequest).JSON(errors.InvalidTemplate), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return template, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	if template.Visibility != templatePrivate {
+		return template, nil, false
+	}
+	var count int64
+	if len(template.Organizations) > 0 {
+		err = db.Model(&structs.OrganizationMember{}).Where("organization_id IN ? AND user_id = ?", template.Organizations, userId).Count(&count).Error
+		if err != nil {
+			fmt.Println(err.Error())
+			return template, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+		}
+	}
+	if count == 0 {
+		return template, c.Status(http.StatusBadRequest).JSON(errors.InvalidTemplate), true
+	}
+	return template, nil, false
+}
+
+// getTemplates lists public templates, admins see every template
+func getTemplates(c *fiber.Ctx) error {
+	query := db.Model(&structs.Template{}).Order("name")
+	if !isAdmin(c) {
+		query = query.Where("visibility = ?", templatePublic)
+	}
+	if language := c.Query("language"); language != "" {
+		query = query.Where("language = ?", language)
+	}
+	var templates []structs.Template
+	if err := query.Find(&templates).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	tag := c.Query("tag")
+	result := make([]structs.ApiTemplate, 0, len(templates))
+	for _, template := range templates {
+		api := toApiTemplate(template)
+		if tag != "" && !contains(api.Tags, tag) {
+			continue
+		}
+		result = append(result, api)
+	}
+	return c.JSON(result)
+}
+
+// getTemplate returns a template with the variables of its manifest
+func getTemplate(c *fiber.Ctx) error {
+	var template structs.Template
+	err := db.Where(&structs.Template{Name: c.Params("name")}).First(&template).Error
+	if err == gorm.ErrRecordNotFound || (err == nil && template.Visibility == templatePrivate && !isAdmin(c)) {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := toApiTemplate(template)
+	provider, err := git.For(template.Provider)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
+	}
+	manifest, err := loadManifest(provider, templateRepo(provider, template), template.ProdBranch)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerGitError)
+	}
+	if manifest != nil {
+		result.Variables = manifest.Variables
+	}
+	return c.JSON(result)
+}
+
+func postTemplate(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	var body PostTemplate
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+
+	if err := checkSettings(body.Settings); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.TemplateSettingsInvalid(err))
+	}
+
+	template := structs.Template{
+		Name:          body.Name,
+		Title:         body.Title,
+		Description:   body.Description,
+		Language:      body.Language,
+		Icon:          body.Icon,
+		Visibility:    body.Visibility,
+		Organizations: body.Organizations,
+		Provider:      body.Provider,
+		Owner:         body.Owner,
+		Repo:          body.Repo,
+		ProdBranch:    body.ProdBranch,
+		DevBranch:     body.DevBranch,
+		Settings:      body.Settings,
+	}
+	if template.Visibility == "" {
+		template.Visibility = templatePublic
+	}
+	if template.ProdBranch == "" {
+		template.ProdBranch = prodBranch
+	}
+	if template.DevBranch == "" {
+		template.DevBranch = devBranch
+	}
+	if body.Tags != nil {
+		tags, err := sonic.MarshalString(body.Tags)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerStringifyError)
+		}
+		template.Tags = tags
+	}
+
+	var count int64
+	if err := db.Model(&structs.Template{}).Where("name = ?", template.Name).Count(&count).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if count > 0 {
+		return c.Status(http.StatusConflict).JSON(errors.TemplateExists)
+	}
+	if err, rtrn := checkTemplateRepo(c, template); rtrn {
+		return err
+	}
+	err := db.Create(&template).Error
+	if err == gorm.ErrDuplicatedKey {
+		return c.Status(http.StatusConflict).JSON(errors.TemplateExists)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusCreated).JSON(toApiTemplate(template))
+}
+
+func patchTemplate(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	var body PatchTemplate
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	var template structs.Template
+	err := db.Where(&structs.Template{Name: c.Params("name")}).First(&template).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+
+	fields := []struct {
+		value  *string
+		target *string
+	}{
+		{body.Title, &template.Title},
+		{body.Description, &template.Description},
+		{body.Language, &template.Language},
+		{body.Icon, &template.Icon},
+		{body.Visibility, &template.Visibility},
+		{body.Provider, &template.Provider},
+		{body.Owner, &template.Owner},
+		{body.Repo, &template.Repo},
+		{body.ProdBranch, &template.ProdBranch},
+		{body.DevBranch, &template.DevBranch},
+	}
+	for _, field := range fields {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+	if body.Tags != nil {
+		tags, err := sonic.MarshalString(*body.Tags)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerStringifyError)
+		}
+		template.Tags = tags
+	}
+	if body.Organizations != nil {
+		template.Organizations = *body.Organizations
+	}
+	if body.Settings != nil {
+		if err := checkSettings(*body.Settings); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(errors.TemplateSettingsInvalid(err))
+		}
+		template.Settings = *body.Settings
+	}
+	// Moving the template to another repository needs the new one to exist
+	if body.Provider != nil || body.Owner != nil || body.Repo != nil || body.ProdBranch != nil {
+		if err, rtrn := checkTemplateRepo(c, template); rtrn {
+			return err
+		}
+	}
+	if err := db.Save(&template).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(toApiTemplate(template))
+}
+
+// deleteTemplate removes a template from the registry. Its repository and
+// the projects created from it are left alone.
+func deleteTemplate(c *fiber.Ctx) error {
+	if !isAdmin(c) {
+		return c.Status(http.StatusUnauthorized).JSON(errors.AuthorizationInvalid)
+	}
+	result := db.Where(&structs.Template{Name: c.Params("name")}).Delete(&structs.Template{})
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
+	return c.Status(http.StatusNoContent).Send(nil)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,337 @@
+package routes
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"api/errors"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	transferPending   = "pending"
+	transferAccepted  = "accepted"
+	transferDeclined  = "declined"
+	transferCancelled = "cancelled"
+)
+
+// How long the recipient has to accept a transfer
+const projectTransferExpiry = 7 * 24 * time.Hour
+
+var errTransferAnswered = goerrors.New("transfer was already answered")
+
+func toApiProjectTransfer(transfer structs.ProjectTransfer) structs.ApiProjectTransfer {
+	return structs.ApiProjectTransfer{
+		ID:          transfer.ID,
+		ProjectID:   transfer.ProjectID,
+		FromUserID:  transfer.FromUserID,
+		ToUserID:    transfer.ToUserID,
+		ToEmail:     transfer.ToEmail,
+		Status:      transfer.Status,
+		ExpiresAt:   transfer.ExpiresAt,
+		RespondedAt: transfer.RespondedAt,
+		CreatedAt:   transfer.CreatedAt,
+	}
+}
+
+type PostProjectTransfer struct {
+	Email    string `json:"email" validate:"required_without=Username,excluded_with=Username,omitempty,email"`
+	Username string `json:"username" validate:"required_without=Email,omitempty,max=32"`
+}
+
+// postProjectTransfer asks another user to take over a project. Transfers by
+// email are addressed to the address, like invitations, so the response does
+// not tell which addresses are registered. Projects of organizations are
+// moved out with DELETE /projects/:id/organization first.
+func postProjectTransfer(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PostProjectTransfer
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	project, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleOwner)
+	if rtrn {
+		return err
+	}
+	if project.OrganizationID != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.ProjectInOrganization)
+	}
+	var recipient structs.User
+	if body.Email != "" {
+		err := db.Model(&structs.User{}).Select("id").Where("email = ?", body.Email).First(&recipient).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			fmt.Println(err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+		}
+	} else {
+		recipient, err, rtrn = findUser(c, "", body.Username)
+		if rtrn {
+			return err
+		}
+		if recipient.ID == project.UserID {
+			return c.Status(http.StatusConflict).JSON(errors.ProjectAlreadyOwned)
+		}
+	}
+
+	var pending int64
+	err = db.Model(&structs.ProjectTransfer{}).Where("project_id = ? AND status = ? AND expires_at > ?", projectId, transferPending, time.Now()).Count(&pending).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	if pending > 0 {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectTransferPending)
+	}
+
+	transfer := structs.ProjectTransfer{
+		ID:         generator.Generate().String(),
+		ProjectID:  projectId,
+		FromUserID: project.UserID,
+		ToEmail:    body.Email,
+		Status:     transferPending,
+		ExpiresAt:  time.Now().Add(projectTransferExpiry),
+	}
+	if body.Email == "" {
+		transfer.ToUserID = recipient.ID
+	}
+	if err := db.Create(&transfer).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	subject := "Transfer of " + project.Name
+	message := "You were asked to take over the project " + project.Name + ". The transfer can be accepted for 7 days."
+	if recipient.ID != "" {
+		notifyUser(recipient.ID, subject, message)
+	} else {
+		go sender.SendEmail(body.Email, subject, message+" Sign up and verify this address to accept it.")
+	}
+	return c.Status(http.StatusCreated).JSON(toApiProjectTransfer(transfer))
+}
+
+// getProjectTransfers lists every transfer of a project, newest first
+func getProjectTransfers(c *fiber.Ctx) error {
+	projectId := c.Params("id")
+	if projectId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleAdmin); rtrn {
+		return err
+	}
+	var transfers []structs.ProjectTransfer
+	if err := db.Where(&structs.ProjectTransfer{ProjectID: projectId}).Order("created_at desc").Find(&transfers).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiProjectTransfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		result = append(result, toApiProjectTransfer(transfer))
+	}
+	return c.JSON(result)
+}
+
+// cancelProjectTransfer withdraws a pending transfer of a project
+func cancelProjectTransfer(c *fiber.Ctx) error {
+	projectId, transferId := c.Params("id"), c.Params("transfer")
+	if projectId == "" || transferId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	if _, _, err, rtrn := getProjectAccess(c, projectId, parsed.UserID, roleOwner); rtrn {
+		return err
+	}
+	transfer, err, rtrn := getPendingTransfer(c, db.Where(&structs.ProjectTransfer{ID: transferId, ProjectID: projectId}))
+	if rtrn {
+		return err
+	}
+	err = closeTransfer(db, &transfer, transferCancelled)
+	if err == errTransferAnswered {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectTransferStale)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(toApiProjectTransfer(transfer))
+}
+
+// getMyTransfers lists the pending transfers to the signed in user
+func getMyTransfers(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("status = ? AND expires_at > ?", transferPending, time.Now()), "to_user_id", "to_email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	var transfers []structs.ProjectTransfer
+	err = query.Order("created_at").Find(&transfers).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	result := make([]structs.ApiProjectTransfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		result = append(result, toApiProjectTransfer(transfer))
+	}
+	return c.JSON(result)
+}
+
+// acceptTransfer makes the signed in user the owner of the project. The
+// repository keeps its name, the previous owner loses access and a membership
+// of the new owner is dropped.
+func acceptTransfer(c *fiber.Ctx) error {
+	transferId := c.Params("id")
+	if transferId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("id = ?", transferId), "to_user_id", "to_email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	transfer, err, rtrn := getPendingTransfer(c, query)
+	if rtrn {
+		return err
+	}
+	// Transfers by email are answered by whoever claims the address
+	transfer.ToUserID = parsed.UserID
+	if transfer.FromUserID == parsed.UserID {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectAlreadyOwned)
+	}
+	var project structs.Project
+	err = db.Where(&structs.Project{ID: transfer.ProjectID}).First(&project).Error
+	if err == gorm.ErrRecordNotFound {
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	// The project changed hands since the transfer was requested
+	if !ownsProject(project, transfer.FromUserID) {
+		if err := closeTransfer(db, &transfer, transferCancelled); err != nil && err != errTransferAnswered {
+			fmt.Println(err.Error())
+		}
+		return c.Status(http.StatusConflict).JSON(errors.ProjectTransferStale)
+	}
+
+	project.UserID = parsed.UserID
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Only move the project if it is still owned by the sender
+		result := tx.Model(&structs.Project{}).Where("id = ? AND user_id = ? AND organization_id IS NULL", project.ID, transfer.FromUserID).
+			Update("user_id", project.UserID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Where(&structs.ProjectMember{ProjectID: project.ID, UserID: parsed.UserID}).Delete(&structs.ProjectMember{}).Error; err != nil {
+			return err
+		}
+		// Rolls the project back when the transfer was cancelled or declined meanwhile
+		return closeTransfer(tx, &transfer, transferAccepted)
+	})
+	if err == gorm.ErrRecordNotFound || err == errTransferAnswered {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectTransferStale)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+
+	members, err := projectMembers(project.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	invalidateProjects(append(members, transfer.FromUserID, transfer.ToUserID)...)
+	notifyUser(transfer.FromUserID, "Transfer of "+project.Name, "The project "+project.Name+" was transferred.")
+	return c.JSON(toApiProjectTransfer(transfer))
+}
+
+// declineTransfer rejects a transfer to the signed in user
+func declineTransfer(c *fiber.Ctx) error {
+	transferId := c.Params("id")
+	if transferId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	query, err, rtrn := addressedTo(c, db.Where("id = ?", transferId), "to_user_id", "to_email", parsed.UserID)
+	if rtrn {
+		return err
+	}
+	transfer, err, rtrn := getPendingTransfer(c, query)
+	if rtrn {
+		return err
+	}
+	// Transfers by email are answered by whoever claims the address
+	transfer.ToUserID = parsed.UserID
+	err = closeTransfer(db, &transfer, transferDeclined)
+	if err == errTransferAnswered {
+		return c.Status(http.StatusConflict).JSON(errors.ProjectTransferStale)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.JSON(toApiProjectTransfer(transfer))
+}
+
+// getPendingTransfer loads the transfer query matches if it can still be answered.
+// When it fails the error response has already been written and should be returned.
+func getPendingTransfer(c *fiber.Ctx, query *gorm.DB) (structs.ProjectTransfer, error, bool) {
+	var transfer structs.ProjectTransfer
+	err := query.Where("status = ?", transferPending).First(&transfer).Error
+	if err == gorm.ErrRecordNotFound {
+		return transfer, c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return transfer, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	if time.Now().After(transfer.ExpiresAt) {
+		return transfer, c.Status(http.StatusGone).JSON(errors.ProjectTransferExpired), true
+	}
+	return transfer, nil, false
+}
+
+// closeTransfer records the outcome of a pending transfer and who answered it.
+// It returns errTransferAnswered when the transfer was answered meanwhile.
+func closeTransfer(tx *gorm.DB, transfer *structs.ProjectTransfer, status string) error {
+	now := time.Now()
+	result := tx.Model(transfer).Where("status = ?", transferPending).Updates(map[string]interface{}{"status": status, "responded_at": now, "to_user_id": transfer.ToUserID})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errTransferAnswered
+	}
+	transfer.Status = status
+	transfer.RespondedAt = &now
+	return nil
+}
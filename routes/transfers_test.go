@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"api/structs"
+
+	"gorm.io/gorm"
+)
+
+func TestCloseTransfer(t *testing.T) {
+	testDatabase(t, &structs.Project{}, &structs.ProjectTransfer{})
+	testRows(t,
+		&structs.Project{ID: "1", UserID: "100", Name: "project"},
+		&structs.ProjectTransfer{ID: "5", ProjectID: "1", FromUserID: "100", ToEmail: "new@example.com", Status: transferPending, ExpiresAt: time.Now().Add(time.Hour)},
+	)
+	var declined, accepted structs.ProjectTransfer
+	db.First(&declined, "id = ?", "5")
+	db.First(&accepted, "id = ?", "5")
+
+	declined.ToUserID = "101"
+	if err := closeTransfer(db, &declined, transferDeclined); err != nil || declined.Status != transferDeclined || declined.RespondedAt == nil {
+		t.Fatalf("declining = %v, %+v", err, declined)
+	}
+
+	// Accepting the same transfer afterwards rolls the project back
+	accepted.ToUserID = "102"
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&structs.Project{}).Where("id = ?", "1").Update("user_id", "102").Error; err != nil {
+			return err
+		}
+		return closeTransfer(tx, &accepted, transferAccepted)
+	})
+	if err != errTransferAnswered {
+		t.Fatalf("accepting a declined transfer returned %v, want errTransferAnswered", err)
+	}
+	var project structs.Project
+	db.First(&project, "id = ?", "1")
+	var stored structs.ProjectTransfer
+	db.First(&stored, "id = ?", "5")
+	if project.UserID != "100" || stored.Status != transferDeclined || stored.ToUserID != "101" {
+		t.Fatalf("project owner %s, transfer %+v", project.UserID, stored)
+	}
+}
@@ -0,0 +1,221 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"api/errors"
+	"api/storage"
+	"api/structs"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	uploadPending   = "pending"
+	uploadCompleted = "completed"
+	uploadRejected  = "rejected"
+)
+
+const (
+	uploadPurposeAvatar = "avatar"
+	uploadPurposeAsset  = "asset"
+)
+
+type PostUpload struct {
+	ContentType string `json:"content_type" validate:"required,max=255"`
+	Size        int64  `json:"size" validate:"required,min=1"`
+}
+
+// createUpload records a pending upload and responds with a presigned PUT URL for it.
+// The URL only accepts a body of the announced size and content type.
+func createUpload(c *fiber.Ctx, store storage.Storage, upload structs.Upload) error {
+	upload.Status = uploadPending
+	upload.ExpiresAt = time.Now().Add(uploadExpiry)
+	url, err := store.PresignPut(ctx, upload.Key, upload.ContentType, upload.Size, uploadExpiry)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+	}
+	if err := db.Create(&upload).Error; err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
+	}
+	return c.Status(http.StatusCreated).JSON(structs.ApiUpload{
+		ID:        upload.ID,
+		URL:       url,
+		Method:    http.MethodPut,
+		Headers:   map[string]string{fiber.HeaderContentType: upload.ContentType},
+		ExpiresAt: upload.ExpiresAt,
+	})
+}
+
+// getPendingUpload loads an upload the user has not completed yet and checks
+// that its object was stored with the announced size
+func getPendingUpload(c *fiber.Ctx, store storage.Storage, where structs.Upload) (structs.Upload, error, bool) {
+	var upload structs.Upload
+	err := db.Where(&where).First(&upload).Error
+	if err == gorm.ErrRecordNotFound {
+		return upload, c.Status(http.StatusNotFound).JSON(errors.NotFound), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return upload, c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError), true
+	}
+	if upload.Status != uploadPending {
+		return upload, c.Status(http.StatusConflict).JSON(errors.UploadFinished), true
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		finishUpload(store, upload, uploadRejected, false)
+		return upload, c.Status(http.StatusGone).JSON(errors.UploadExpired), true
+	}
+	info, err := store.Stat(ctx, upload.Key)
+	if storage.IsNotFound(err) {
+		return upload, c.Status(http.StatusConflict).JSON(errors.UploadIncomplete), true
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return upload, c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError), true
+	}
+	if info.Size != upload.Size {
+		return upload, c.Status(http.StatusConflict).JSON(errors.UploadIncomplete), true
+	}
+	return upload, nil, false
+}
+
+// finishUpload records the outcome of a pending upload and removes its object unless it is kept.
+// Nothing happens when another request finished the upload first.
+func finishUpload(store storage.Storage, upload structs.Upload, status string, keep bool) {
+	result := db.Model(&structs.Upload{}).Where("id = ? AND status = ?", upload.ID, uploadPending).Update("status", status)
+	if result.Error != nil {
+		fmt.Println(result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 || keep {
+		return
+	}
+	if err := store.Delete(ctx, upload.Key); err != nil && !storage.IsNotFound(err) {
+		fmt.Println(err.Error())
+	}
+}
+
+// rejectUpload finishes an upload after a completion handler wrote an error response.
+// Server errors keep the upload pending so the client can retry the completion.
+func rejectUpload(c *fiber.Ctx, store storage.Storage, upload structs.Upload) {
+	if c.Response().StatusCode() >= http.StatusInternalServerError {
+		return
+	}
+	finishUpload(store, upload, uploadRejected, false)
+}
+
+// mediaType lowercases contentType and strips its parameters
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+// contentTypeAllowed matches contentType against a list of types where type/* matches a whole group
+func contentTypeAllowed(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	contentType = mediaType(contentType)
+	for _, candidate := range allowed {
+		if candidate == contentType || (strings.HasSuffix(candidate, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(candidate, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// postAvatarUpload issues a presigned URL to upload an avatar straight to storage.
+// completeAvatarUpload then processes it like an avatar sent to putAvatar.
+func postAvatarUpload(c *fiber.Ctx) error {
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var body PostUpload
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	errs := _validate.Validate(body)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	if !strings.HasPrefix(body.ContentType, "image/") {
+		return c.Status(http.StatusUnsupportedMediaType).JSON(errors.ImageFormatInvalid)
+	}
+	if body.Size > avatarMaxBytes {
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(errors.ImageTooLarge)
+	}
+
+	id := generator.Generate().String()
+	return createUpload(c, storage.For(storage.Avatars), structs.Upload{
+		ID:          id,
+		UserID:      parsed.UserID,
+		Purpose:     uploadPurposeAvatar,
+		Key:         storage.AvatarUploadKey(parsed.UserID, id),
+		ContentType: body.ContentType,
+		Size:        body.Size,
+	})
+}
+func completeAvatarUpload(c *fiber.Ctx) error {
+	uploadId := c.Params("id")
+	if uploadId == "" {
+		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
+	}
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
+	}
+	var fields AvatarCrop
+	if err := c.QueryParser(&fields); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&fields); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(errors.MalformedBody(err))
+		}
+	}
+	errs := _validate.Validate(fields)
+	if err, rtrn := handleValidateErrors(errs, c); rtrn {
+		return err
+	}
+	crop, valid := fields.toCrop()
+	if !valid {
+		return c.Status(http.StatusBadRequest).JSON(errors.ImageCropInvalid)
+	}
+
+	avatars := storage.For(storage.Avatars)
+	upload, err, rtrn := getPendingUpload(c, avatars, structs.Upload{ID: uploadId, UserID: parsed.UserID, Purpose: uploadPurposeAvatar})
+	if rtrn {
+		return err
+	}
+	object, _, err := avatars.Get(ctx, upload.Key)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+	}
+	data, err := storage.ReadLimited(object, avatarMaxBytes)
+	object.Close()
+	if err == storage.ErrImageTooLarge {
+		finishUpload(avatars, upload, uploadRejected, false)
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(errors.ImageTooLarge)
+	} else if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(errors.ServerStorageError)
+	}
+
+	source, img, err, rtrn := decodeAvatar(c, data, crop)
+	if rtrn {
+		rejectUpload(c, avatars, upload)
+		return err
+	}
+	if err, rtrn := saveAvatar(c, parsed.UserID, source, img); rtrn {
+		rejectUpload(c, avatars, upload)
+		return err
+	}
+	finishUpload(avatars, upload, uploadCompleted, false)
+	return c.Status(http.StatusNoContent).Send(nil)
+}
@@ -6,16 +6,13 @@ import (
 	"time"
 
 	"api/errors"
-	"api/nsfw"
-	"api/storage"
+	"api/password"
+	"api/secrets"
 	"api/structs"
 	"api/utils"
 
-	"github.com/bytedance/sonic"
-	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pquerna/otp/totp"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -32,18 +29,10 @@ type SetUpBody struct {
 }
 
 func setUp2FA(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
-
 	var body SetUpBody
 	if err := c.BodyParser(&body); err != nil {
 		return c.Status(400).JSON(errors.MalformedBody(err))
@@ -53,13 +42,6 @@ func setUp2FA(c *fiber.Ctx) error {
 		return err
 	}
 
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
-	}
-
 	var user structs.User
 	err = db.Where(&structs.User{ID: parsed.UserID}).First(&user).Error
 	if err == gorm.ErrRecordNotFound {
@@ -69,7 +51,7 @@ func setUp2FA(c *fiber.Ctx) error {
 		return c.Status(500).JSON(errors.ServerSqlError)
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	if err := password.Compare(user.Password, body.Password); err != nil {
 		return c.Status(http.StatusUnauthorized).JSON(errors.UserCredentialsInvalid)
 	}
 	if err != nil {
@@ -82,7 +64,12 @@ func setUp2FA(c *fiber.Ctx) error {
 		return c.Status(500).JSON(errors.ServerTotpError)
 	}
 
-	err = db.Model(&structs.User{}).Where(&structs.User{ID: parsed.UserID}).Update("totp_secret", secret).Error
+	encrypted, keyId, err := secrets.Encrypt(secret, parsed.UserID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(500).JSON(errors.ServerEncryptError)
+	}
+	err = db.Model(&structs.User{}).Where(&structs.User{ID: parsed.UserID}).Updates(map[string]interface{}{"totp_secret": encrypted, "totp_key_id": keyId}).Error
 	if err != nil {
 		fmt.Println(err.Error())
 		return c.Status(500).JSON(errors.ServerSqlError)
@@ -90,27 +77,14 @@ func setUp2FA(c *fiber.Ctx) error {
 	return c.Status(200).JSON(fiber.Map{"secret": secret, "url": url})
 }
 func verify2fa(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 	code := c.Params("code")
 	if code == "" {
 		return c.Status(http.StatusBadRequest).JSON(errors.MissingParameter)
 	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
-	}
 	var user structs.User
 	err = db.Model(&structs.User{}).Select("TotpSecret", "TotpVerified").Where(&structs.User{ID: parsed.UserID}).First(&user).Error
 	if err == gorm.ErrRecordNotFound {
@@ -119,7 +93,12 @@ func verify2fa(c *fiber.Ctx) error {
 		return c.Status(500).JSON(errors.ServerSqlError)
 	}
 
-	valid := totp.Validate(code, user.TotpSecret)
+	secret, err := secrets.Decrypt(user.TotpSecret, parsed.UserID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(500).JSON(errors.ServerEncryptError)
+	}
+	valid := totp.Validate(code, secret)
 	if valid && user.TotpVerified {
 		return c.Status(http.StatusOK).JSON(fiber.Map{"valid": true})
 	} else if valid {
@@ -131,18 +110,10 @@ func verify2fa(c *fiber.Ctx) error {
 }
 
 func remove2fa(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
-
 	var body SetUpBody
 	if err := c.BodyParser(&body); err != nil {
 		return c.Status(400).JSON(errors.MalformedBody(err))
@@ -152,13 +123,6 @@ func remove2fa(c *fiber.Ctx) error {
 		return err
 	}
 
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
-	}
-
 	var user structs.User
 	err = db.Where(&structs.User{ID: parsed.UserID}).First(&user).Error
 	if err == gorm.ErrRecordNotFound {
@@ -168,10 +132,10 @@ func remove2fa(c *fiber.Ctx) error {
 		return c.Status(500).JSON(errors.ServerSqlError)
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	if err := password.Compare(user.Password, body.Password); err != nil {
 		return c.Status(http.StatusUnauthorized).JSON(errors.UserCredentialsInvalid)
 	}
-	err = db.Model(&structs.User{}).Where(&structs.User{ID: parsed.UserID}).Updates(map[string]interface{}{"TotpSecret": "", "TotpVerified": false}).Error
+	err = db.Model(&structs.User{}).Where(&structs.User{ID: parsed.UserID}).Updates(map[string]interface{}{"TotpSecret": "", "TotpKeyID": "", "TotpVerified": false}).Error
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(errors.ServerSqlError)
 	}
@@ -179,22 +143,9 @@ func remove2fa(c *fiber.Ctx) error {
 }
 
 func getMe(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 	var user structs.ApiUser
 	err = db.Model(&structs.User{}).Where(&structs.User{ID: parsed.UserID}).First(&user).Error
@@ -213,22 +164,9 @@ type PutEmail struct {
 }
 
 func putEmail(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 
 	var body PutEmail
@@ -240,6 +178,9 @@ func putEmail(c *fiber.Ctx) error {
 
 		return err
 	}
+	if err, rtrn := checkEmailDomain(c, body.Email); rtrn {
+		return err
+	}
 	avaiable, _ := emailAvailable(body.Email)
 	if !avaiable {
 		return c.Status(400).JSON(errors.UserEmailTaken)
@@ -265,22 +206,9 @@ type PutPassword struct {
 }
 
 func putPassword(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 
 	var body PutPassword
@@ -296,11 +224,13 @@ func putPassword(c *fiber.Ctx) error {
 	if err := db.Model(&structs.User{}).Where(&structs.User{ID: parsed.UserID}).Select("password").First(&user).Error; err != nil {
 		return c.Status(400).JSON(errors.UserCredentialsInvalid)
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.OldPassword)); err != nil {
+	if err := password.Compare(user.Password, body.OldPassword); err != nil {
 		return c.Status(400).JSON(errors.UserCredentialsInvalid)
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
-	if err != nil {
+	hash, err := password.Hash(body.NewPassword)
+	if err == password.ErrTooLong {
+		return c.Status(http.StatusBadRequest).JSON(errors.PasswordTooLong)
+	} else if err != nil {
 		return c.Status(500).JSON(errors.ServerHash)
 	}
 	db.Model(&structs.User{}).Where("ID = ?", parsed.UserID).Update("password", hash)
@@ -312,22 +242,9 @@ type DeleteUser struct {
 }
 
 func deleteMe(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
+	parsed, err, rtrn := getSession(c)
+	if rtrn {
+		return err
 	}
 
 	var body DeleteUser
@@ -344,94 +261,13 @@ func deleteMe(c *fiber.Ctx) error {
 	if err := db.Where(&structs.User{ID: parsed.UserID}).First(&user).Error; err != nil {
 		return c.Status(400).JSON(errors.UserCredentialsInvalid)
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)); err != nil {
+	if err := password.Compare(user.Password, body.Password); err != nil {
 		return c.Status(400).JSON(errors.UserCredentialsInvalid)
 	}
 	err = db.Delete(&user).Error
 	if err != nil {
 		return c.Status(500).JSON(errors.ServerSqlError)
 	}
+	removeUserMemberships(user.ID)
 	return c.Status(http.StatusNoContent).Send(nil)
 }
-
-type PutAvatar struct {
-	Image string `json:"image" validate:"required,base64"`
-}
-
-func putAvatar(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-
-	var body PutAvatar
-	if err := c.BodyParser(&body); err != nil {
-		return c.Status(400).JSON(errors.MalformedBody(err))
-	}
-	errs := _validate.Validate(body)
-	if err, rtrn := handleValidateErrors(errs, c); rtrn {
-		return err
-	}
-
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
-	}
-	resized, err := storage.Resize(body.Image, 128, 128)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerImageError)
-	}
-	webp, err := storage.ToWebp(resized)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerImageError)
-	}
-	isNsfw, err := nsfw.IsNsfw(webp)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	if isNsfw {
-		return c.Status(400).JSON(errors.ImageNsfw)
-	}
-	fmt.Println("not nsfw")
-	err = storage.Upload(parsed.UserID, *webp)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerImageError)
-	}
-	return c.Status(http.StatusNoContent).Send(nil)
-}
-func deleteAvatar(c *fiber.Ctx) error {
-	authorization := c.Get("Authorization")
-	if authorization == "" {
-		return c.Status(401).JSON(errors.AuthorizationMissing)
-	}
-	session, err := rdb.Get(ctx, "session:"+authorization).Result()
-	if err == redis.Nil {
-		return c.Status(401).JSON(errors.AuthorizationInvalid)
-	} else if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	var parsed structs.Session
-	err = sonic.UnmarshalString(session, &parsed)
-	if err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerParseError)
-	}
-	if err := storage.Remove(parsed.UserID); err != nil {
-		fmt.Println(err.Error())
-		return c.Status(500).JSON(errors.ServerStorageError)
-	}
-	return c.Status(204).Send(nil)
-}
@@ -1,23 +1,67 @@
 package routes
 
 import (
+	goerrors "errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"api/email"
 	"api/errors"
+	"api/password"
 	"api/structs"
 	"api/utils"
 
 	"github.com/bytedance/sonic"
 	"github.com/gofiber/fiber/v2"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+var errInviteClaimed = goerrors.New("invite already claimed")
+
+const (
+	registrationOpen   = "open"
+	registrationInvite = "invite"
+	registrationDomain = "domain"
+)
+
 type PostBody struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8,max=32"`
-	Url      string `json:"url" validate:"required,url"`
+	Email    string  `json:"email" validate:"required,email"`
+	Password string  `json:"password" validate:"required,min=8,max=32"`
+	Url      string  `json:"url" validate:"required,url"`
+	Invite   string  `json:"invite" validate:"omitempty,max=64"`
+	IP       *string `json:"ip" validate:"omitempty,ip"`
+}
+
+// checkEmailDomain rejects disposable providers and, in domain mode, any domain not on the allow list
+func checkEmailDomain(c *fiber.Ctx, address string) (error, bool) {
+	if email.IsDisposable(address) {
+		return c.Status(400).JSON(errors.UserEmailDisposable), true
+	}
+	if env.RegistrationMode == registrationDomain && !email.DomainAllowed(address, strings.Split(env.RegistrationDomains, ",")) {
+		return c.Status(400).JSON(errors.UserEmailDomainInvalid), true
+	}
+	return nil, false
+}
+
+// checkInvite makes sure an unused, unexpired invite was sent when registration is invite only
+func checkInvite(c *fiber.Ctx, code string) (error, bool) {
+	if env.RegistrationMode != registrationInvite {
+		return nil, false
+	}
+	if code == "" {
+		return c.Status(403).JSON(errors.InviteRequired), true
+	}
+	var count int64
+	err := db.Model(&structs.Invite{}).Where("code = ? AND used_by IS NULL AND expires_at > ?", code, time.Now()).Count(&count).Error
+	if err != nil {
+		fmt.Println(err.Error())
+		return c.Status(500).JSON(errors.ServerSqlError), true
+	}
+	if count == 0 {
+		return c.Status(403).JSON(errors.InviteInvalid), true
+	}
+	return nil, false
 }
 
 func postUsers(c *fiber.Ctx) error {
@@ -35,34 +79,67 @@ func postUsers(c *fiber.Ctx) error {
 
 		return err
 	}
+	ip := getIp(body.IP, c)
+	if err, rtrn := checkCaptcha(c, captchaUsers, ip, forwardedIp(body.IP)); rtrn {
+		return err
+	}
+	if err, rtrn := checkEmailDomain(c, body.Email); rtrn {
+		recordFailure(captchaUsers, ip)
+		return err
+	}
+	if err, rtrn := checkInvite(c, body.Invite); rtrn {
+		recordFailure(captchaUsers, ip)
+		return err
+	}
 	available, _ := emailAvailable(body.Email)
 	if !available {
+		recordFailure(captchaUsers, ip)
 		return c.Status(400).JSON(errors.UserEmailTaken)
 	}
 	token, tokenErr := utils.RandString(32)
 	if tokenErr != nil {
 		return c.Status(500).JSON(errors.ServerTokenGenerate)
 	}
-	if err := sendVerifyEmail(c, body.Email, body.Url, token); err != nil {
-		return err
-	}
 	id := generator.Generate()
-	_, redErr := rdb.Set(ctx, "verification:"+token, id.String(), 30*time.Minute).Result()
-	if redErr != nil {
-		return c.Status(500).JSON(errors.ServerRedisError)
-	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
-	if err != nil {
+	hash, err := password.Hash(body.Password)
+	if err == password.ErrTooLong {
+		return c.Status(400).JSON(errors.PasswordTooLong)
+	} else if err != nil {
 		return c.Status(500).JSON(errors.ServerHash)
 	}
-	user := structs.User{ID: id.String(), Email: body.Email, Password: string(hash)}
-	err = db.Create(&user).Error
-	if err == gorm.ErrDuplicatedKey {
+	user := structs.User{ID: id.String(), Email: body.Email, Password: hash}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Claim the invite in the same transaction so it can only be used once
+		if env.RegistrationMode == registrationInvite {
+			now := time.Now()
+			result := tx.Model(&structs.Invite{}).Where("code = ? AND used_by IS NULL AND expires_at > ?", body.Invite, now).Updates(map[string]interface{}{"used_by": user.ID, "used_at": now})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errInviteClaimed
+			}
+		}
+		return tx.Create(&user).Error
+	})
+	if err == errInviteClaimed {
+		recordFailure(captchaUsers, ip)
+		return c.Status(403).JSON(errors.InviteInvalid)
+	} else if err == gorm.ErrDuplicatedKey {
+		recordFailure(captchaUsers, ip)
 		return c.Status(400).JSON(errors.UserEmailTaken)
 	} else if err != nil {
 		fmt.Println(err)
 		return c.Status(500).JSON(errors.ServerSqlError)
 	}
+	// Only send the email once the user exists, a failure here can be retried through POST /verify
+	_, redErr := rdb.Set(ctx, "verification:"+token, id.String(), 30*time.Minute).Result()
+	if redErr != nil {
+		return c.Status(500).JSON(errors.ServerRedisError)
+	}
+	if err := sendVerifyEmail(c, body.Email, body.Url, token); err != nil {
+		return err
+	}
 	return c.Status(200).JSON(fiber.Map{"id": id.String()})
 }
 func getUsers(c *fiber.Ctx) error {
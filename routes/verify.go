@@ -40,9 +40,16 @@ func postVerify(c *fiber.Ctx) error {
 
 		return err
 	}
-	_, user := emailAvailable(body.Email)
-
+	if err, rtrn := checkCaptcha(c, captchaVerify, body.Email, ""); rtrn {
+		return err
+	}
+	available, user := emailAvailable(body.Email)
+	if available {
+		recordFailure(captchaVerify, body.Email)
+		return c.Status(http.StatusNotFound).JSON(errors.NotFound)
+	}
 	if user.Verified {
+		recordFailure(captchaVerify, body.Email)
 		return c.Status(http.StatusBadRequest).JSON(errors.UserAlreadyVerified)
 	}
 	token, tokenErr := utils.RandString(32)
@@ -52,6 +59,7 @@ func postVerify(c *fiber.Ctx) error {
 	if err := sendVerifyEmail(c, body.Email, body.Url, token); err != nil {
 		return err
 	}
+	recordSend(captchaVerify, body.Email)
 	_, redErr := rdb.Set(ctx, "verification:"+token, user.ID, 30*time.Minute).Result()
 	if redErr != nil {
 		return c.Status(500).JSON(errors.ServerRedisError)
@@ -0,0 +1,181 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"api/structs"
+)
+
+// Values are sealed with a random data key which is itself sealed with a
+// key encryption key from the environment (envelope encryption):
+// enc:v2:<key id>:<base64 wrapped data key>:<base64 ciphertext>
+// The ciphertext is bound to the data given to Encrypt, like the ID of the row
+// it belongs to.
+const (
+	prefix   = "enc:"
+	prefixV2 = "enc:v2:"
+)
+
+var (
+	ErrUnknownKey = errors.New("secrets: unknown key id")
+	ErrMalformed  = errors.New("secrets: malformed encrypted value")
+	ErrNoKeys     = errors.New("secrets: ENCRYPTION_KEYS is not set")
+
+	keys    = map[string][]byte{}
+	current string
+)
+
+// Connect loads the key encryption keys from ENCRYPTION_KEYS, formatted as
+// comma separated id:base64key pairs. ENCRYPTION_KEY_ID selects the key used
+// for new values and defaults to the first one listed. Without keys nothing
+// can be encrypted, Encrypt then returns ErrNoKeys.
+func Connect(env *structs.Environment) error {
+	keys = map[string][]byte{}
+	current = ""
+	for _, pair := range strings.Split(env.EncryptionKeys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, found := strings.Cut(pair, ":")
+		if !found || id == "" {
+			return fmt.Errorf("secrets: key %q must be formatted as id:base64key", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("secrets: key %s is not valid base64: %w", id, err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("secrets: key %s must be 32 bytes, got %d", id, len(key))
+		}
+		keys[id] = key
+		if current == "" {
+			current = id
+		}
+	}
+	if env.EncryptionKeyId != "" {
+		current = env.EncryptionKeyId
+	}
+	if current == "" {
+		return nil
+	}
+	if _, exists := keys[current]; !exists {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, current)
+	}
+	return nil
+}
+
+// CurrentKey returns the id of the key new values are encrypted with
+func CurrentKey() string {
+	return current
+}
+
+// Encrypt seals plaintext with the current key and returns the encoded value
+// and key id. Decrypt needs the same data to open the value.
+func Encrypt(plaintext string, data string) (string, string, error) {
+	if current == "" {
+		return "", "", ErrNoKeys
+	}
+	kek, exists := keys[current]
+	if !exists {
+		return "", "", ErrUnknownKey
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", "", err
+	}
+	wrapped, err := seal(kek, dek, []byte(current))
+	if err != nil {
+		return "", "", err
+	}
+	ciphertext, err := seal(dek, []byte(plaintext), []byte(data))
+	if err != nil {
+		return "", "", err
+	}
+	encoded := prefixV2 + current + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext)
+	return encoded, current, nil
+}
+
+// Decrypt opens a value produced by Encrypt with the same data. Values stored
+// before encryption was introduced have no prefix and are returned unchanged.
+func Decrypt(encoded string, data string) (string, error) {
+	if !IsEncrypted(encoded) {
+		return encoded, nil
+	}
+	if !strings.HasPrefix(encoded, prefixV2) {
+		return "", ErrMalformed
+	}
+	parts := strings.Split(strings.TrimPrefix(encoded, prefixV2), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	kek, exists := keys[parts[0]]
+	if !exists {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, parts[0])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	dek, err := open(kek, wrapped, []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dek, ciphertext, []byte(data))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// IsCurrent reports whether value is encrypted the way Encrypt does now,
+// with the current key and bound to its data
+func IsCurrent(value string) bool {
+	return current != "" && strings.HasPrefix(value, prefixV2+current+":")
+}
+
+func seal(key []byte, plaintext []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, data), nil
+}
+func open(key []byte, sealed []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], data)
+}
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"api/structs"
+)
+
+func testKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(fill), 32)))
+}
+
+func TestConnect(t *testing.T) {
+	if err := Connect(&structs.Environment{}); err != nil {
+		t.Fatalf("no keys returned %v", err)
+	}
+	if _, _, err := Encrypt("secret", "user"); !errors.Is(err, ErrNoKeys) {
+		t.Fatalf("Encrypt without keys returned %v, want ErrNoKeys", err)
+	}
+
+	if err := Connect(&structs.Environment{EncryptionKeys: "a:" + testKey('a') + ", b:" + testKey('b')}); err != nil {
+		t.Fatal(err)
+	}
+	if CurrentKey() != "a" {
+		t.Fatalf("current key = %s, want the first one listed", CurrentKey())
+	}
+	if err := Connect(&structs.Environment{EncryptionKeys: "a:" + testKey('a') + ",b:" + testKey('b'), EncryptionKeyId: "b"}); err != nil || CurrentKey() != "b" {
+		t.Fatalf("ENCRYPTION_KEY_ID = %s, %v", CurrentKey(), err)
+	}
+
+	invalid := []*structs.Environment{
+		{EncryptionKeys: testKey('a')},
+		{EncryptionKeys: "a:not base64"},
+		{EncryptionKeys: "a:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{EncryptionKeys: "a:" + testKey('a'), EncryptionKeyId: "missing"},
+	}
+	for _, env := range invalid {
+		if err := Connect(env); err == nil {
+			t.Fatalf("Connect(%q, %q) succeeded", env.EncryptionKeys, env.EncryptionKeyId)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	if err := Connect(&structs.Environment{EncryptionKeys: "a:" + testKey('a')}); err != nil {
+		t.Fatal(err)
+	}
+	encoded, id, err := Encrypt("secret", "user-1")
+	if err != nil || id != "a" {
+		t.Fatalf("Encrypt = %s, %v", id, err)
+	}
+	if !IsEncrypted(encoded) || !IsCurrent(encoded) || strings.Contains(encoded, "secret") {
+		t.Fatalf("encoded value %q", encoded)
+	}
+	if plaintext, err := Decrypt(encoded, "user-1"); err != nil || plaintext != "secret" {
+		t.Fatalf("Decrypt = %q, %v", plaintext, err)
+	}
+	if _, err := Decrypt(encoded, "user-2"); err == nil {
+		t.Fatal("a value opened with the data of another row")
+	}
+	if plaintext, err := Decrypt("legacy", "user-1"); err != nil || plaintext != "legacy" {
+		t.Fatalf("unencrypted value = %q, %v", plaintext, err)
+	}
+	for _, malformed := range []string{"enc:v1:a:x:y", "enc:v2:a:x", "enc:v2:a:!:!"} {
+		if _, err := Decrypt(malformed, "user-1"); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("Decrypt(%q) returned %v, want ErrMalformed", malformed, err)
+		}
+	}
+
+	// Values sealed with a retired key open as long as it is listed
+	if err := Connect(&structs.Environment{EncryptionKeys: "a:" + testKey('a') + ",b:" + testKey('b'), EncryptionKeyId: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if IsCurrent(encoded) {
+		t.Fatal("a value of the previous key is current")
+	}
+	if plaintext, err := Decrypt(encoded, "user-1"); err != nil || plaintext != "secret" {
+		t.Fatalf("Decrypt with a retired key = %q, %v", plaintext, err)
+	}
+	if err := Connect(&structs.Environment{EncryptionKeys: "b:" + testKey('b')}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(encoded, "user-1"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Decrypt with a removed key returned %v, want ErrUnknownKey", err)
+	}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+var (
+	ErrImageTooLarge  = errors.New("storage: image exceeds the maximum byte size")
+	ErrImageDimension = errors.New("storage: image exceeds the maximum dimensions")
+	ErrImageFormat    = errors.New("storage: unsupported image format")
+	ErrCrop           = errors.New("storage: crop rectangle is outside the image")
+)
+
+// Sniff detects the image format from its magic bytes and returns "" when it is not supported
+func Sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// ReadLimited reads r fully, failing with ErrImageTooLarge once more than max bytes were read
+func ReadLimited(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
+
+// DecodeImage validates the format by magic bytes and the dimensions from the
+// header before decoding. Animated GIF and WebP images decode to their first frame
+// and JPEG images are rotated according to their EXIF orientation.
+func DecodeImage(data []byte, maxDimension int) (image.Image, error) {
+	format := Sniff(data)
+	if format == "" {
+		return nil, ErrImageFormat
+	}
+	if format == "webp" {
+		var err error
+		if data, err = firstWebpFrame(data); err != nil {
+			return nil, err
+		}
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxDimension || config.Height > maxDimension {
+		return nil, ErrImageDimension
+	}
+
+	// Apply the EXIF orientation from phone cameras. Metadata is dropped since only pixels are kept.
+	return imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+}
+
+type riffChunk struct {
+	id      string
+	payload []byte
+}
+
+func readChunks(data []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(data) >= 8 {
+		size := binary.LittleEndian.Uint32(data[4:8])
+		if uint64(size) > uint64(len(data)-8) {
+			return nil, ErrImageFormat
+		}
+		chunks = append(chunks, riffChunk{id: string(data[0:4]), payload: data[8 : 8+size]})
+		// Chunks are padded to an even length
+		next := 8 + int(size) + int(size&1)
+		if next > len(data) {
+			break
+		}
+		data = data[next:]
+	}
+	return chunks, nil
+}
+
+func writeChunk(buffer *bytes.Buffer, id string, payload []byte) {
+	buffer.WriteString(id)
+	binary.Write(buffer, binary.LittleEndian, uint32(len(payload)))
+	buffer.Write(payload)
+	if len(payload)%2 == 1 {
+		buffer.WriteByte(0)
+	}
+}
+
+// firstWebpFrame turns an animated WebP into a still image of its first frame,
+// other WebP images are returned unchanged
+func firstWebpFrame(data []byte) ([]byte, error) {
+	chunks, err := readChunks(data[12:])
+	if err != nil {
+		return nil, err
+	}
+	const animationFlag = 0x02
+	if len(chunks) == 0 || chunks[0].id != "VP8X" || len(chunks[0].payload) < 10 || chunks[0].payload[0]&animationFlag == 0 {
+		return data, nil
+	}
+
+	for _, chunk := range chunks {
+		// ANMF: x, y, width-1, height-1 and duration as 24 bit integers, one flag byte, then the frame chunks
+		if chunk.id != "ANMF" || len(chunk.payload) < 16 {
+			continue
+		}
+		frame, err := readChunks(chunk.payload[16:])
+		if err != nil {
+			return nil, err
+		}
+
+		var body bytes.Buffer
+		alpha := false
+		for _, c := range frame {
+			switch c.id {
+			case "ALPH":
+				alpha = true
+				writeChunk(&body, c.id, c.payload)
+			case "VP8 ", "VP8L":
+				writeChunk(&body, c.id, c.payload)
+			}
+		}
+		if alpha {
+			const alphaFlag = 0x10
+			header := make([]byte, 10)
+			header[0] = alphaFlag
+			copy(header[4:10], chunk.payload[6:12])
+			var withHeader bytes.Buffer
+			writeChunk(&withHeader, "VP8X", header)
+			withHeader.Write(body.Bytes())
+			body = withHeader
+		}
+
+		var still bytes.Buffer
+		still.WriteString("RIFF")
+		binary.Write(&still, binary.LittleEndian, uint32(4+body.Len()))
+		still.WriteString("WEBP")
+		still.Write(body.Bytes())
+		return still.Bytes(), nil
+	}
+	return nil, ErrImageFormat
+}
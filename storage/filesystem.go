@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// filesystemStorage keeps objects as files under <base>/<bucket>/<prefix>.
+// Presigned URLs point at the API, which checks them with VerifySignature.
+type filesystemStorage struct {
+	root      string
+	purpose   Purpose
+	publicUrl string
+}
+
+func newFilesystemStorage(base string, bucket string, prefix string, publicUrl string, purpose Purpose) (*filesystemStorage, error) {
+	root := filepath.Join(base, bucket, filepath.FromSlash(prefix))
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &filesystemStorage{root: root, purpose: purpose, publicUrl: strings.TrimSuffix(publicUrl, "/")}, nil
+}
+
+func (f *filesystemStorage) path(key string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if cleaned == "" || cleaned != key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(f.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (f *filesystemStorage) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	target, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see a partial object
+	temp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := io.Copy(temp, reader); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), target)
+}
+func (f *filesystemStorage) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := f.Stat(ctx, key)
+	if err != nil {
+		return nil, info, err
+	}
+	target, _ := f.path(key)
+	file, err := os.Open(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, info, ErrNotFound
+	}
+	return file, info, err
+}
+func (f *filesystemStorage) Delete(ctx context.Context, key string) error {
+	target, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+func (f *filesystemStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(f.root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		relative, err := filepath.Rel(f.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, fileInfo(key, stat))
+		return nil
+	})
+	return objects, err
+}
+func (f *filesystemStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	target, err := f.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, ErrNotFound
+	} else if err != nil {
+		return ObjectInfo{}, err
+	}
+	if stat.IsDir() {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return fileInfo(key, stat), nil
+}
+func (f *filesystemStorage) PresignPut(ctx context.Context, key string, contentType string, size int64, expiry time.Duration) (string, error) {
+	if _, err := f.path(key); err != nil {
+		return "", err
+	}
+	return signedURL(f.publicUrl, "PUT", f.purpose, key, contentType, size, time.Now().Add(expiry)), nil
+}
+func (f *filesystemStorage) PresignGet(ctx context.Context, key string, contentType string, expiry time.Duration) (string, error) {
+	if _, err := f.path(key); err != nil {
+		return "", err
+	}
+	return signedURL(f.publicUrl, "GET", f.purpose, key, contentType, 0, time.Now().Add(expiry)), nil
+}
+
+// fileInfo derives an ETag from the size and modification time since no checksum is stored.
+// The content type is not stored either, callers that validated it pass it to PresignGet.
+func fileInfo(key string, stat fs.FileInfo) ObjectInfo {
+	sum := sha1.Sum([]byte(strconv.FormatInt(stat.Size(), 10) + ":" + strconv.FormatInt(stat.ModTime().UnixNano(), 10)))
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ETag:         hex.EncodeToString(sum[:10]),
+		ContentType:  "application/octet-stream",
+		LastModified: stat.ModTime(),
+	}
+}
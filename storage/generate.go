@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var initialsFont, _ = opentype.Parse(gomedium.TTF)
+
+// Identicon draws a horizontally symmetric 5x5 pattern derived from seed
+func Identicon(seed string, size int) *image.NRGBA {
+	hash := sha256.Sum256([]byte(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.NRGBA{240, 240, 240, 255}}, image.Point{}, draw.Src)
+
+	foreground := &image.Uniform{seedColor(hash)}
+	// Half a cell of padding on each side
+	cell := float64(size) / 6
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 3; col++ {
+			if hash[row*3+col]%2 == 0 {
+				continue
+			}
+			for _, x := range []int{col, 4 - col} {
+				rect := image.Rect(
+					int(math.Round(cell/2+float64(x)*cell)),
+					int(math.Round(cell/2+float64(row)*cell)),
+					int(math.Round(cell/2+float64(x+1)*cell)),
+					int(math.Round(cell/2+float64(row+1)*cell)),
+				)
+				draw.Draw(img, rect, foreground, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
+
+// Initials draws the first letters of up to two words of name on a background derived from seed
+func Initials(name string, seed string, size int) *image.NRGBA {
+	hash := sha256.Sum256([]byte(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{seedColor(hash)}, image.Point{}, draw.Src)
+
+	text := initials(name)
+	if text == "" || initialsFont == nil {
+		return img
+	}
+	face, err := opentype.NewFace(initialsFont, &opentype.FaceOptions{Size: float64(size) * 0.42, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return img
+	}
+	defer face.Close()
+
+	drawer := &font.Drawer{Dst: img, Src: image.White, Face: face}
+	bounds, _ := drawer.BoundString(text)
+	width := bounds.Max.X - bounds.Min.X
+	height := bounds.Max.Y - bounds.Min.Y
+	half := fixed.I(size) / 2
+	drawer.Dot = fixed.Point26_6{
+		X: half - width/2 - bounds.Min.X,
+		Y: half - height/2 - bounds.Min.Y,
+	}
+	drawer.DrawString(text)
+	return img
+}
+
+func initials(name string) string {
+	var letters []rune
+	for _, word := range strings.Fields(name) {
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				letters = append(letters, unicode.ToUpper(r))
+				break
+			}
+		}
+		if len(letters) == 2 {
+			break
+		}
+	}
+	return string(letters)
+}
+
+// seedColor picks a saturated, medium lightness color so white text stays readable
+func seedColor(hash [32]byte) color.NRGBA {
+	hue := float64(uint16(hash[30])<<8|uint16(hash[31])) / 65536 * 360
+	saturation, lightness := 0.55, 0.45
+
+	chroma := (1 - math.Abs(2*lightness-1)) * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := lightness - chroma/2
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g, b = chroma, x, 0
+	case hue < 120:
+		r, g, b = x, chroma, 0
+	case hue < 180:
+		r, g, b = 0, chroma, x
+	case hue < 240:
+		r, g, b = 0, x, chroma
+	case hue < 300:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	return color.NRGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
+}
@@ -1,40 +1,143 @@
 package storage
 
 import (
-	"bytes"
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"api/structs"
-
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 var (
-	ctx    = context.Background()
-	client *minio.Client
-	env    *structs.Environment
+	ErrNotFound = errors.New("storage: object not found")
+
+	buckets = map[Purpose]Storage{}
+
+	// AvatarSizes are the square sizes every uploaded avatar is stored in, smallest first
+	AvatarSizes = []int{32, 64, 128, 256}
 )
 
-func Connect(_env *structs.Environment) *minio.Client {
-	env = _env
-	var err error
-	client, err = minio.New(env.MinioEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(env.MinioAccessKeyId, env.MinioAccessKey, ""),
-		Secure: true,
-	})
-	if err != nil {
-		log.Fatalf("Failed to create storage client: %v", err)
+// Purpose groups objects that share a bucket and prefix
+type Purpose string
+
+const (
+	Avatars Purpose = "avatars"
+	Exports Purpose = "exports"
+	Assets  Purpose = "assets"
+	// Quarantine holds rejected uploads until they are reviewed
+	Quarantine Purpose = "quarantine"
+)
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+}
+
+// Storage is an object store scoped to one bucket and prefix. Keys never include the prefix.
+type Storage interface {
+	Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
+	// Get opens an object for reading along with its metadata. The reader must be closed.
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// PresignPut returns a URL that accepts a PUT of exactly size bytes of contentType to key until expiry
+	PresignPut(ctx context.Context, key string, contentType string, size int64, expiry time.Duration) (string, error)
+	// PresignGet returns a URL to download key until expiry, served as contentType unless it is empty
+	// and always as an attachment
+	PresignGet(ctx context.Context, key string, contentType string, expiry time.Duration) (string, error)
+}
+
+// Connect creates a store for every purpose using STORAGE_BACKEND, either
+// minio (any S3 compatible service) or filesystem
+func Connect(env *structs.Environment) error {
+	locations := map[Purpose][2]string{
+		Avatars:    {env.StorageAvatarsBucket, env.StorageAvatarsPrefix},
+		Exports:    {env.StorageExportsBucket, env.StorageExportsPrefix},
+		Assets:     {env.StorageAssetsBucket, env.StorageAssetsPrefix},
+		Quarantine: {env.StorageQuarantineBucket, env.StorageQuarantinePrefix},
 	}
 
-	return client
+	switch env.StorageBackend {
+	case "minio", "s3":
+		secure, err := strconv.ParseBool(env.MinioSecure)
+		if err != nil {
+			return fmt.Errorf("invalid MINIO_SECURE: %w", err)
+		}
+		client, err := newMinioClient(env.MinioEndpoint, env.MinioAccessKeyId, env.MinioAccessKey, secure)
+		if err != nil {
+			return err
+		}
+		for purpose, location := range locations {
+			buckets[purpose] = &minioStorage{client: client, bucket: location[0], prefix: location[1]}
+		}
+	case "filesystem":
+		signing = signingKey(env)
+		for purpose, location := range locations {
+			store, err := newFilesystemStorage(env.StoragePath, location[0], location[1], env.StoragePublicUrl, purpose)
+			if err != nil {
+				return err
+			}
+			buckets[purpose] = store
+		}
+	default:
+		return fmt.Errorf("unknown storage backend %s", env.StorageBackend)
+	}
+	return nil
+}
+
+// For returns the store configured for purpose
+func For(purpose Purpose) Storage {
+	return buckets[purpose]
 }
 
-func Upload(name string, buffer bytes.Buffer) error {
-	_, err := client.PutObject(ctx, env.MinioAvatarBucket, name+".webp", &buffer, int64(buffer.Len()), minio.PutObjectOptions{ContentType: "image/webp"})
-	return err
+// IsNotFound reports whether err means the object does not exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
 }
-func Remove(name string) error {
-	return client.RemoveObject(ctx, env.MinioAvatarBucket, name+".webp", minio.RemoveObjectOptions{})
+
+// AvatarKey is the key of a users avatar at one of AvatarSizes
+func AvatarKey(userId string, size int) string {
+	return userId + "/" + strconv.Itoa(size) + ".webp"
+}
+
+// LegacyAvatarKey is where avatars uploaded before multiple sizes existed are stored
+func LegacyAvatarKey(userId string) string {
+	return userId + ".webp"
+}
+
+// AvatarUploadKey is where a presigned avatar upload waits to be processed
+func AvatarUploadKey(userId string, uploadId string) string {
+	return "uploads/" + userId + "/" + uploadId
+}
+
+// AssetKey is the key of a project asset. The extension of name is kept so
+// stores that derive the content type from the key serve it correctly.
+func AssetKey(projectId string, assetId string, name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if len(ext) < 2 || len(ext) > 16 || strings.Trim(ext, ".abcdefghijklmnopqrstuvwxyz0123456789") != "" || strings.Count(ext, ".") > 1 {
+		ext = ""
+	}
+	return projectId + "/" + assetId + ext
+}
+
+func joinKey(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return path.Join(prefix, key)
+}
+func trimKey(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
 }
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type minioStorage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newMinioClient(endpoint string, accessKeyId string, accessKey string, secure bool) (*minio.Client, error) {
+	return minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKeyId, accessKey, ""),
+		Secure: secure,
+	})
+}
+
+func (m *minioStorage) Put(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	_, err := m.client.PutObject(ctx, m.bucket, joinKey(m.prefix, key), reader, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+func (m *minioStorage) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := m.Stat(ctx, key)
+	if err != nil {
+		return nil, info, err
+	}
+	object, err := m.client.GetObject(ctx, m.bucket, joinKey(m.prefix, key), minio.GetObjectOptions{})
+	return object, info, convertMinioError(err)
+}
+func (m *minioStorage) Delete(ctx context.Context, key string) error {
+	return convertMinioError(m.client.RemoveObject(ctx, m.bucket, joinKey(m.prefix, key), minio.RemoveObjectOptions{}))
+}
+func (m *minioStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// Stops the listing goroutine when returning early on an error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var objects []ObjectInfo
+	for object := range m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{Prefix: joinKey(m.prefix, prefix), Recursive: true}) {
+		if object.Err != nil {
+			return nil, convertMinioError(object.Err)
+		}
+		objects = append(objects, m.convertInfo(object))
+	}
+	return objects, nil
+}
+func (m *minioStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := m.client.StatObject(ctx, m.bucket, joinKey(m.prefix, key), minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, convertMinioError(err)
+	}
+	return m.convertInfo(info), nil
+}
+func (m *minioStorage) PresignPut(ctx context.Context, key string, contentType string, size int64, expiry time.Duration) (string, error) {
+	// Signing Content-Length makes the store reject bodies of any other size
+	headers := http.Header{"Content-Type": {contentType}, "Content-Length": {strconv.FormatInt(size, 10)}}
+	u, err := m.client.PresignHeader(ctx, http.MethodPut, m.bucket, joinKey(m.prefix, key), expiry, nil, headers)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+func (m *minioStorage) PresignGet(ctx context.Context, key string, contentType string, expiry time.Duration) (string, error) {
+	// Downloaded rather than opened on the bucket origin, like the filesystem backend
+	params := url.Values{"response-content-disposition": {"attachment"}}
+	if contentType != "" {
+		params.Set("response-content-type", contentType)
+	}
+	u, err := m.client.PresignedGetObject(ctx, m.bucket, joinKey(m.prefix, key), expiry, params)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (m *minioStorage) convertInfo(info minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          trimKey(m.prefix, info.Key),
+		Size:         info.Size,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}
+}
+func convertMinioError(err error) error {
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
@@ -2,27 +2,55 @@ package storage
 
 import (
 	"bytes"
-	"encoding/base64"
 	"image"
-	"strings"
 
 	"github.com/chai2010/webp"
 	"github.com/disintegration/imaging"
 )
 
-func Resize(b64 string, w int, h int) (*image.NRGBA, error) {
-	data, err := base64.StdEncoding.DecodeString(b64)
-	if err != nil {
-		return nil, err
-	}
+// Crop selects the square an avatar is cut from. Rect is relative to the
+// image's top left corner and FocalX/FocalY are fractions of the width and height.
+type Crop struct {
+	Rect   *image.Rectangle
+	FocalX float64
+	FocalY float64
+}
 
-	img, _, err := image.Decode(strings.NewReader(string(data)))
-	if err != nil {
-		return nil, err
+// CenterCrop keeps the middle of the image
+var CenterCrop = Crop{FocalX: 0.5, FocalY: 0.5}
+
+// Square cuts the largest square out of img, or out of crop.Rect when set,
+// positioned as close to centered on the focal point as the edges allow
+func Square(img image.Image, crop Crop) (image.Image, error) {
+	bounds := img.Bounds()
+	if crop.Rect != nil {
+		rect := crop.Rect.Add(bounds.Min).Intersect(bounds)
+		if rect.Empty() {
+			return nil, ErrCrop
+		}
+		img = imaging.Crop(img, rect)
+		bounds = img.Bounds()
 	}
 
-	resized := imaging.Resize(img, w, h, imaging.Lanczos)
-	return resized, nil
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x := clamp(bounds.Min.X+int(crop.FocalX*float64(bounds.Dx()))-side/2, bounds.Min.X, bounds.Max.X-side)
+	y := clamp(bounds.Min.Y+int(crop.FocalY*float64(bounds.Dy()))-side/2, bounds.Min.Y, bounds.Max.Y-side)
+	return imaging.Crop(img, image.Rect(x, y, x+side, y+side)), nil
+}
+func clamp(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+func Resize(img image.Image, w int, h int) *image.NRGBA {
+	return imaging.Resize(img, w, h, imaging.Lanczos)
 }
 func ToWebp(img *image.NRGBA) (*bytes.Buffer, error) {
 	var buffer bytes.Buffer
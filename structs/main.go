@@ -23,14 +23,78 @@ type Environment struct {
 	Port              string
 	RequestsPerSecond string
 
-	GitToken    string
-	GitUrl      string
-	GitUsername string
+	GitToken     string
+	GitUrl       string
+	GitUsername  string
+	GitProvider  string
+	GitProviders string
+	GitVersion   string
+	GitLocalPath string
+	ForgejoUrl   string
+	ForgejoToken string
+	ForgejoOwner string
+	GithubUrl    string
+	GithubToken  string
+	GithubOwner  string
+	GitlabUrl    string
+	GitlabToken  string
+	GitlabOwner  string
 
 	MinioEndpoint     string
 	MinioAccessKeyId  string
 	MinioAccessKey    string
 	MinioAvatarBucket string
+	MinioSecure       string
+
+	StorageBackend          string
+	StoragePath             string
+	StoragePublicUrl        string
+	StorageSigningKey       string
+	StorageAvatarsBucket    string
+	StorageAvatarsPrefix    string
+	StorageExportsBucket    string
+	StorageExportsPrefix    string
+	StorageAssetsBucket     string
+	StorageAssetsPrefix     string
+	StorageQuarantineBucket string
+	StorageQuarantinePrefix string
+
+	PasswordAlgorithm string
+	Argon2Time        string
+	Argon2Memory      string
+	Argon2Threads     string
+	BcryptCost        string
+
+	EncryptionKeys  string
+	EncryptionKeyId string
+
+	RegistrationMode    string
+	RegistrationDomains string
+	InvitesPerUser      string
+
+	CaptchaProvider         string
+	CaptchaSecret           string
+	CaptchaRoutes           string
+	CaptchaFailureThreshold string
+
+	AvatarDefault      string
+	AvatarMaxBytes     string
+	AvatarMaxDimension string
+	BodyLimit          string
+
+	AssetMaxBytes     string
+	AssetContentTypes string
+	UploadExpiry      string
+
+	ModerationClassifiers       string
+	ModerationThresholds        string
+	ModerationFailMode          string
+	ModerationRemoteUrl         string
+	ModerationRemoteToken       string
+	ModerationWorkers           string
+	ModerationTimeout           string
+	ModerationAdminEmails       string
+	ModerationBlocklistDistance string
 }
 type User struct {
 	ID       string `gorm:"type:bigint;primaryKey"`
@@ -39,32 +103,461 @@ type User struct {
 	Verified bool   `gorm:"default:false"`
 
 	TotpSecret   string
+	TotpKeyID    string
 	TotpVerified bool `gorm:"default:false"`
 
+	Username *string
+	// Lowercased username, unique so usernames are case insensitive
+	UsernameKey *string `gorm:"uniqueIndex"`
+	DisplayName string
+	Bio         string
+	Locale      string
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 type ApiUser struct {
-	ID           string `json:"id"`
-	Email        string `json:"email"`
-	Verified     bool   `json:"verified"`
-	TotpVerified bool   `json:"totp_verified"`
+	ID           string  `json:"id"`
+	Email        string  `json:"email"`
+	Verified     bool    `json:"verified"`
+	TotpVerified bool    `json:"totp_verified"`
+	Username     *string `json:"username"`
+	DisplayName  string  `json:"display_name"`
+	Bio          string  `json:"bio"`
+	Locale       string  `json:"locale"`
+}
+
+// ApiProfile is the public view of a user and never includes the email
+type ApiProfile struct {
+	ID          string  `json:"id"`
+	Username    *string `json:"username"`
+	DisplayName string  `json:"display_name"`
+	Bio         string  `json:"bio"`
+	Locale      string  `json:"locale"`
 }
 type Project struct {
-	ID        string `gorm:"uniqueIndex"`
-	UserID    string `gorm:"type:bigint"`
-	User      User   `gorm:"foreignKey:UserID"`
-	Name      string `gorm:"notNull"`
+	ID     string `gorm:"uniqueIndex"`
+	UserID string `gorm:"type:bigint"`
+	User   User   `gorm:"foreignKey:UserID"`
+	Name   string `gorm:"notNull"`
+	// Description of the repository, the name when empty
+	Description string
+	// private projects can only be seen by users with a role, public ones by every signed in user
+	Visibility string `gorm:"default:private"`
+	// Settings declared by the template of the project, see TemplateSetting
+	Settings map[string]interface{} `gorm:"serializer:json"`
+	// Organization owning the project, UserID then is the user that created it
+	OrganizationID *string `gorm:"type:bigint;index"`
+	// The repository of the project is RepoOwner/Repo on Provider. They are
+	// kept when the project changes owner, so the repository stays reachable.
+	Provider  string
+	RepoOwner string
+	Repo      string
+	// Template the project was created from
+	Template string
+	// Production and development branch of the repository
+	ProdBranch string
+	DevBranch  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+type ApiProject struct {
+	ID          string `json:"id"`
+	UserID      string `json:"user_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+	// Only returned to editors of a single project, environment values can be secrets
+	Settings map[string]interface{} `json:"settings,omitempty" gorm:"-"`
+	Provider string                 `json:"provider"`
+	// Organization owning the project
+	OrganizationID *string   `json:"organization_id"`
+	RepoOwner      string    `json:"repo_owner"`
+	Repo           string    `json:"repo"`
+	Template       string    `json:"template"`
+	ProdBranch     string    `json:"prod_branch"`
+	DevBranch      string    `json:"dev_branch"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Organization owns projects on behalf of its members
+type Organization struct {
+	ID        string `gorm:"type:bigint;primaryKey"`
+	Slug      string `gorm:"uniqueIndex"`
+	Name      string
+	CreatedBy string `gorm:"type:bigint"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
-type ApiProject struct {
-	ID        string    `json:"id"`
+type ApiOrganization struct {
+	ID        string `json:"id"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	CreatedBy string `json:"created_by"`
+	// Role of the signed in user
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+type OrganizationMember struct {
+	OrganizationID string `gorm:"type:bigint;primaryKey"`
+	UserID         string `gorm:"type:bigint;primaryKey;index"`
+	// member, admin or owner
+	Role      string `gorm:"notNull"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+type ApiOrganizationMember struct {
+	OrganizationID string    `json:"organization_id"`
+	UserID         string    `json:"user_id"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// OrganizationInvitation makes a user an OrganizationMember once they accept it
+type OrganizationInvitation struct {
+	ID             string `gorm:"type:bigint;primaryKey"`
+	OrganizationID string `gorm:"type:bigint;index"`
+	// Invitations by email address have no UserID until they are accepted
+	UserID    string `gorm:"type:bigint;index"`
+	Email     string `gorm:"index"`
+	Role      string `gorm:"notNull"`
+	InvitedBy string `gorm:"type:bigint"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+type ApiOrganizationInvitation struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id"`
+	UserID         string    `json:"user_id"`
+	Email          string    `json:"email,omitempty"`
+	Role           string    `json:"role"`
+	InvitedBy      string    `json:"invited_by"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Team gives organization members access to some of its projects
+type Team struct {
+	ID             string `gorm:"type:bigint;primaryKey"`
+	OrganizationID string `gorm:"type:bigint;index"`
+	Name           string
+	CreatedAt      time.Time
+}
+type TeamMember struct {
+	TeamID string `gorm:"type:bigint;primaryKey"`
+	UserID string `gorm:"type:bigint;primaryKey;index"`
+}
+type TeamProject struct {
+	TeamID    string `gorm:"type:bigint;primaryKey"`
+	ProjectID string `gorm:"type:bigint;primaryKey;index"`
+	// viewer, editor or admin
+	Role string `gorm:"notNull"`
+}
+type ApiTeam struct {
+	ID             string           `json:"id"`
+	OrganizationID string           `json:"organization_id"`
+	Name           string           `json:"name"`
+	Members        []string         `json:"members"`
+	Projects       []ApiTeamProject `json:"projects"`
+	CreatedAt      time.Time        `json:"created_at"`
+}
+type ApiTeamProject struct {
+	ProjectID string `json:"project_id"`
+	Role      string `json:"role"`
+}
+
+// ProjectMember gives a user other than the owner access to a project
+type ProjectMember struct {
+	ProjectID string `gorm:"type:bigint;primaryKey"`
+	UserID    string `gorm:"type:bigint;primaryKey;index"`
+	// viewer, editor or admin
+	Role      string `gorm:"notNull"`
+	InvitedBy string `gorm:"type:bigint"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+type ApiProjectMember struct {
+	ProjectID string    `json:"project_id"`
 	UserID    string    `json:"user_id"`
-	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invited_by"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// ProjectInvitation makes a user a ProjectMember once they accept it
+type ProjectInvitation struct {
+	ID        string `gorm:"type:bigint;primaryKey"`
+	ProjectID string `gorm:"type:bigint;index"`
+	// Invitations by email address have no UserID until they are accepted
+	UserID    string `gorm:"type:bigint;index"`
+	Email     string `gorm:"index"`
+	Role      string `gorm:"notNull"`
+	InvitedBy string `gorm:"type:bigint"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+type ApiProjectInvitation struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"project_id"`
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProjectTransfer is a request to hand a project to another user. Rows are
+// kept after they are answered as a record of who owned a project when.
+type ProjectTransfer struct {
+	ID         string `gorm:"type:bigint;primaryKey"`
+	ProjectID  string `gorm:"type:bigint;index"`
+	FromUserID string `gorm:"type:bigint"`
+	// Transfers by email address have no ToUserID until they are accepted
+	ToUserID string `gorm:"type:bigint;index"`
+	ToEmail  string `gorm:"index"`
+	// pending, accepted, declined or cancelled
+	Status      string `gorm:"notNull;default:pending"`
+	ExpiresAt   time.Time
+	RespondedAt *time.Time
+	CreatedAt   time.Time
+}
+type ApiProjectTransfer struct {
+	ID          string     `json:"id"`
+	ProjectID   string     `json:"project_id"`
+	FromUserID  string     `json:"from_user_id"`
+	ToUserID    string     `json:"to_user_id"`
+	ToEmail     string     `json:"to_email,omitempty"`
+	Status      string     `json:"status"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Promotion records the development branch of a project being brought into
+// its production branch, directly or through a pull request
+type Promotion struct {
+	ID        string `gorm:"type:bigint;primaryKey"`
+	ProjectID string `gorm:"type:bigint;index"`
+	UserID    string `gorm:"type:bigint"`
+	// fast_forward, merge or pull_request
+	Method string
+	// Commit of the development branch that was promoted
+	DevSHA string
+	// Commit the production branch points at afterwards, empty for pull requests
+	ProdSHA        string
+	PullRequestURL string
+	CreatedAt      time.Time
+}
+type ApiPromotion struct {
+	ID             string    `json:"id"`
+	ProjectID      string    `json:"project_id"`
+	UserID         string    `json:"user_id"`
+	Method         string    `json:"method"`
+	DevSHA         string    `json:"dev_sha"`
+	ProdSHA        string    `json:"prod_sha"`
+	PullRequestURL string    `json:"pull_request_url"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Template is a repository new projects are created from
+type Template struct {
+	Name        string `gorm:"primaryKey"`
+	Title       string
+	Description string
+	Language    string
+	// URL of an icon
+	Icon string
+	// JSON array of tags
+	Tags string
+	// public templates are listed, unlisted ones can be used by name, private ones
+	// only by members of Organizations
+	Visibility    string   `gorm:"default:public"`
+	Organizations []string `gorm:"serializer:json"`
+	// Provider hosting the template repository, empty for the default provider.
+	// Projects from this template are created on the same provider.
+	Provider string
+	// Owner and name of the template repository, empty for the provider owner and template_<name>
+	Owner string
+	Repo  string
+	// ProdBranch is the default branch of the template repository, DevBranch is created from it
+	ProdBranch string `gorm:"default:prod"`
+	DevBranch  string `gorm:"default:dev"`
+	// Settings projects from this template have, the default settings when empty
+	Settings  []TemplateSetting `gorm:"serializer:json"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+type ApiTemplate struct {
+	Name        string   `json:"name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Language    string   `json:"language"`
+	Icon        string   `json:"icon"`
+	Tags        []string `json:"tags"`
+	Visibility  string   `json:"visibility"`
+	// Organizations whose members can use a private template
+	Organizations []string `json:"organizations,omitempty"`
+	Provider      string   `json:"provider"`
+	ProdBranch    string   `json:"prod_branch"`
+	DevBranch     string   `json:"dev_branch"`
+	// Settings of projects created from the template
+	Settings []TemplateSetting `json:"settings"`
+	// Variables of the template manifest, only returned for a single template
+	Variables []TemplateVariable `json:"variables,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// TemplateVariable is a value asked for when a project is created from a template
+type TemplateVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     string `json:"default"`
+	Required    bool   `json:"required"`
+	// Pattern the whole value has to match
+	Pattern string `json:"pattern"`
+}
+
+// TemplateSetting declares a setting of the projects created from a template
+type TemplateSetting struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// string, path (a file in the repository) or env (an object of environment variables)
+	Type string `json:"type"`
+	// Pattern the whole value of a string or path has to match
+	Pattern string `json:"pattern"`
+	// Longest value, or most variables for env. A default applies when 0.
+	Max int `json:"max"`
+}
+type Invite struct {
+	Code      string  `gorm:"primaryKey"`
+	CreatedBy *string `gorm:"type:bigint;index"`
+	UsedBy    *string `gorm:"type:bigint"`
+	UsedAt    *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+type ApiInvite struct {
+	Code      string     `json:"code"`
+	UsedBy    *string    `json:"used_by"`
+	UsedAt    *time.Time `json:"used_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Upload is an object a client sends straight to storage through a presigned URL.
+// It stays pending until the completion endpoint has checked and processed it.
+type Upload struct {
+	ID          string  `gorm:"primaryKey"`
+	UserID      string  `gorm:"type:bigint;index"`
+	ProjectID   *string `gorm:"type:bigint"`
+	Purpose     string  `gorm:"notNull"`
+	Key         string  `gorm:"notNull"`
+	Name        string
+	ContentType string
+	Size        int64
+	Status      string `gorm:"default:pending"`
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+type ApiUpload struct {
+	ID        string            `json:"id"`
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+type Asset struct {
+	ID          string `gorm:"primaryKey"`
+	ProjectID   string `gorm:"type:bigint;index"`
+	UserID      string `gorm:"type:bigint"`
+	Key         string `gorm:"notNull"`
+	Name        string
+	ContentType string
+	Size        int64
+	CreatedAt   time.Time
+}
+type ApiAsset struct {
+	ID          string    `json:"id"`
+	ProjectID   string    `json:"project_id"`
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ModerationDecision records what the moderation pipeline decided about an
+// image and the scores of every classifier that ran
+type ModerationDecision struct {
+	ID        string `gorm:"type:bigint;primaryKey"`
+	UserID    string `gorm:"type:bigint;index"`
+	Subject   string `gorm:"notNull"`
+	SubjectID string
+	ProjectID *string `gorm:"type:bigint"`
+	Name      string
+	Outcome   string `gorm:"index"`
+	Label     string
+	// JSON array of classifier results
+	Results string
+	// Perceptual hash of the image, see nsfw.DHash
+	Hash string
+	// Perceptual hash of the uncropped upload an avatar was cut from
+	SourceHash string
+
+	// Rejected images are kept under Key in quarantine storage until reviewed
+	Key         string
+	ContentType string
+	// Review is pending while the image waits in the review queue, then approved or rejected
+	Review     string `gorm:"index"`
+	ReviewNote string
+	ReviewedAt *time.Time
+	Appeal     string
+	AppealedAt *time.Time
+
+	CreatedAt time.Time
+}
+
+// BlockedHash is the perceptual hash of an image that is rejected on sight
+type BlockedHash struct {
+	ID         string  `gorm:"type:bigint;primaryKey"`
+	Hash       string  `gorm:"uniqueIndex"`
+	DecisionID *string `gorm:"type:bigint"`
+	Reason     string
+	CreatedAt  time.Time
+}
+type ApiBlockedHash struct {
+	ID         string    `json:"id"`
+	Hash       string    `json:"hash"`
+	DecisionID *string   `json:"decision_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+type ApiModerationDecision struct {
+	ID         string      `json:"id"`
+	UserID     string      `json:"user_id"`
+	Subject    string      `json:"subject"`
+	SubjectID  string      `json:"subject_id"`
+	ProjectID  *string     `json:"project_id"`
+	Name       string      `json:"name"`
+	Outcome    string      `json:"outcome"`
+	Label      string      `json:"label"`
+	Results    interface{} `json:"results"`
+	Hash       string      `json:"hash"`
+	SourceHash string      `json:"source_hash"`
+	Review     string      `json:"review"`
+	ReviewNote string      `json:"review_note"`
+	ReviewedAt *time.Time  `json:"reviewed_at"`
+	Appeal     string      `json:"appeal"`
+	AppealedAt *time.Time  `json:"appealed_at"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
 type Session struct {
 	UserID string
 	IP     string